@@ -0,0 +1,296 @@
+// Package bloomfilter implements a Bloom filter, an add-only set membership
+// structure: it takes less memory than a cuckoo filter for the same error
+// rate, but items can't be deleted.
+package bloomfilter
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+
+	"github.com/fukua95/pds/internal/hashing"
+)
+
+// defaultExpansion is the growth factor of the filters New creates, as in
+// RedisBloom.
+const defaultExpansion = 2
+
+// tighteningRatio is the ratio between the error rates of consecutive
+// sub-filters. With 1/2, the error rates sum to at most twice that of the
+// first sub-filter.
+const tighteningRatio = 0.5
+
+// A subFilter is a fixed-size Bloom filter sized for capacity items.
+type subFilter struct {
+	// bitNum is the number of bits, m, and hashNum the number of bits set per
+	// item, k.
+	bitNum   uint64
+	hashNum  uint64
+	capacity uint64
+	itemNum  uint64
+	fpr      float64
+	bits     []uint64
+}
+
+// BloomFilter is a scalable Bloom filter: once the last sub-filter holds as
+// many items as it was sized for, a new one is added, expansion times larger
+// and with half its false positive rate, so that the overall false positive
+// rate stays below the one the filter was created with.
+type BloomFilter struct {
+	filters   []subFilter
+	expansion uint64
+	itemNum   uint64
+}
+
+// New returns a filter sized for capacity items with a false positive rate of
+// fpr, which doubles its capacity when it's full. It panics if fpr isn't in
+// (0, 1) or if the filter would be too large; see NewChecked.
+func New(capacity uint64, fpr float64) *BloomFilter {
+	return NewWithExpansion(capacity, fpr, defaultExpansion)
+}
+
+// NewWithExpansion is like New, but each sub-filter is expansion times larger
+// than the previous one. With an expansion of 0, the filter never grows, and
+// its false positive rate rises above fpr once it holds more than capacity
+// items.
+func NewWithExpansion(capacity uint64, fpr float64, expansion uint64) *BloomFilter {
+	bf, err := newFilter(capacity, fpr, expansion)
+	if err != nil {
+		panic("bloomfilter: " + err.Error())
+	}
+	return bf
+}
+
+// NewChecked is like New, but returns an error instead of panicking.
+// A capacity of 0 is treated as 1.
+func NewChecked(capacity uint64, fpr float64) (*BloomFilter, error) {
+	return newFilter(capacity, fpr, defaultExpansion)
+}
+
+func newFilter(capacity uint64, fpr float64, expansion uint64) (*BloomFilter, error) {
+	if !(fpr > 0 && fpr < 1) {
+		return nil, errors.New("fpr must be in (0, 1)")
+	}
+	if expansion > 0 {
+		fpr *= tighteningRatio
+	}
+	sf, ok := newSubFilter(max(capacity, 1), fpr)
+	if !ok {
+		return nil, errors.New("capacity is too large")
+	}
+	return &BloomFilter{
+		filters:   []subFilter{sf},
+		expansion: expansion,
+	}, nil
+}
+
+func newSubFilter(capacity uint64, fpr float64) (subFilter, bool) {
+	bitNum, hashNum := optimalParams(capacity, fpr)
+	if bitNum >= math.MaxInt {
+		return subFilter{}, false
+	}
+	return subFilter{
+		bitNum:   uint64(bitNum),
+		hashNum:  uint64(hashNum),
+		capacity: capacity,
+		fpr:      fpr,
+		bits:     make([]uint64, (uint64(bitNum)+63)/64),
+	}, true
+}
+
+// optimalParams returns the number of bits m and of hashes k that minimize the
+// memory for n items at a false positive rate of p:
+// m = -n ln(p) / ln(2)^2 and k = m/n ln(2).
+func optimalParams(n uint64, p float64) (m float64, k float64) {
+	m = math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k = max(math.Round(m/float64(n)*math.Ln2), 1)
+	return m, k
+}
+
+// hashes returns the two hashes the bits of data are derived from, by double
+// hashing: the i-th bit of data is (h1 + i*h2) % bitNum. h2 is seeded with h1,
+// as in RedisBloom: hashes with close seeds are correlated.
+func hashes(data []byte) (h1, h2 uint64) {
+	h1 = hashing.Sum64(data, 0)
+	return h1, hashing.Sum64(data, h1)
+}
+
+// add sets the bits of the item hashed to h1 and h2, and reports whether any
+// of them wasn't set.
+func (sf *subFilter) add(h1, h2 uint64) bool {
+	added := false
+	for i := range sf.hashNum {
+		bit := (h1 + i*h2) % sf.bitNum
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if sf.bits[word]&mask == 0 {
+			sf.bits[word] |= mask
+			added = true
+		}
+	}
+	if added {
+		sf.itemNum++
+	}
+	return added
+}
+
+func (sf *subFilter) exists(h1, h2 uint64) bool {
+	for i := range sf.hashNum {
+		bit := (h1 + i*h2) % sf.bitNum
+		if sf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (sf *subFilter) popCount() uint64 {
+	n := 0
+	for _, w := range sf.bits {
+		n += bits.OnesCount64(w)
+	}
+	return uint64(n)
+}
+
+// grow adds a sub-filter, and reports whether it could.
+func (bf *BloomFilter) grow() bool {
+	last := &bf.filters[len(bf.filters)-1]
+	capacity := last.capacity * bf.expansion
+	if capacity/bf.expansion != last.capacity {
+		return false
+	}
+	sf, ok := newSubFilter(capacity, last.fpr*tighteningRatio)
+	if !ok {
+		return false
+	}
+	bf.filters = append(bf.filters, sf)
+	return true
+}
+
+// Add adds data to the filter. It returns false if data may already have
+// been added, i.e. it's reported by Exists, and true otherwise.
+func (bf *BloomFilter) Add(data []byte) bool {
+	h1, h2 := hashes(data)
+	for i := range bf.filters {
+		if bf.filters[i].exists(h1, h2) {
+			return false
+		}
+	}
+
+	last := &bf.filters[len(bf.filters)-1]
+	// A filter that can't grow anymore keeps filling its last sub-filter.
+	if last.itemNum >= last.capacity && bf.expansion > 0 && bf.grow() {
+		last = &bf.filters[len(bf.filters)-1]
+	}
+	last.add(h1, h2)
+	bf.itemNum++
+	return true
+}
+
+// Exists reports whether data may have been added. It never returns false for
+// an added item, but returns true for others with the false positive rate.
+func (bf *BloomFilter) Exists(data []byte) bool {
+	h1, h2 := hashes(data)
+	// The last sub-filter is the largest, and holds the most items.
+	for i := len(bf.filters) - 1; i >= 0; i-- {
+		if bf.filters[i].exists(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// ItemNum returns the number of items added, not counting the ones Add
+// reported as already present.
+func (bf *BloomFilter) ItemNum() uint64 {
+	return bf.itemNum
+}
+
+// BitNum returns the number of bits of the filter, over all its sub-filters.
+func (bf *BloomFilter) BitNum() uint64 {
+	n := uint64(0)
+	for i := range bf.filters {
+		n += bf.filters[i].bitNum
+	}
+	return n
+}
+
+// HashNum returns the number of bits set per item in the first sub-filter.
+// Later sub-filters have lower false positive rates, and set more.
+func (bf *BloomFilter) HashNum() uint64 {
+	return bf.filters[0].hashNum
+}
+
+// FilterNum returns the number of sub-filters.
+func (bf *BloomFilter) FilterNum() int {
+	return len(bf.filters)
+}
+
+// PopCount returns the number of bits of the filter that are set.
+func (bf *BloomFilter) PopCount() uint64 {
+	n := uint64(0)
+	for i := range bf.filters {
+		n += bf.filters[i].popCount()
+	}
+	return n
+}
+
+// FillRatio returns the fraction of the bits of the filter that are set.
+// A sub-filter holding as many items as it was sized for is about half full.
+func (bf *BloomFilter) FillRatio() float64 {
+	return float64(bf.PopCount()) / float64(bf.BitNum())
+}
+
+// EstimateCount estimates the number of distinct items added from the number
+// of bits set, X, with -(m/k) ln(1 - X/m) for each sub-filter of m bits and k
+// hashes. Unlike ItemNum, it doesn't depend on what Add reported, and it's
+// still meaningful after Merge. A sub-filter with all its bits set gives no
+// estimate, and math.MaxUint64 is returned.
+func (bf *BloomFilter) EstimateCount() uint64 {
+	sum := 0.0
+	for i := range bf.filters {
+		sf := &bf.filters[i]
+		m, k, x := float64(sf.bitNum), float64(sf.hashNum), float64(sf.popCount())
+		sum += -m / k * math.Log1p(-x/m)
+	}
+	if sum >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return uint64(math.Round(sum))
+}
+
+// Merge ORs the bits of other into bf, so that bf holds the union of both
+// sets. Both filters must have the same sub-filters, with the same number of
+// bits and hashes, and the same expansion; otherwise an error is returned and
+// bf is left unchanged, e.g. when one of them grew and the other didn't.
+//
+// The item counts are summed, which overcounts the items added to both: the
+// merged filter may thus grow earlier than it would have, never later.
+func (bf *BloomFilter) Merge(other *BloomFilter) error {
+	if len(bf.filters) != len(other.filters) || bf.expansion != other.expansion {
+		return errors.New("filters have different parameters")
+	}
+	for i := range bf.filters {
+		a, b := &bf.filters[i], &other.filters[i]
+		if a.bitNum != b.bitNum || a.hashNum != b.hashNum || a.capacity != b.capacity {
+			return errors.New("filters have different parameters")
+		}
+	}
+
+	for i := range bf.filters {
+		a, b := &bf.filters[i], &other.filters[i]
+		for j, w := range b.bits {
+			a.bits[j] |= w
+		}
+		a.itemNum = addSat(a.itemNum, b.itemNum)
+	}
+	bf.itemNum = addSat(bf.itemNum, other.itemNum)
+	return nil
+}
+
+// addSat returns a + b, or math.MaxUint64 if it overflows.
+func addSat(a, b uint64) uint64 {
+	if a+b < a {
+		return math.MaxUint64
+	}
+	return a + b
+}
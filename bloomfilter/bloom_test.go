@@ -0,0 +1,150 @@
+package bloomfilter
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	bf := NewWithExpansion(1000, 0.01, 0)
+	assert.Equal(t, uint64(9586), bf.BitNum())
+	assert.Equal(t, uint64(7), bf.HashNum())
+	assert.Len(t, bf.filters[0].bits, 150)
+
+	// The first sub-filter of a scalable filter has half the error rate.
+	bf = New(1000, 0.02)
+	assert.Equal(t, uint64(9586), bf.BitNum())
+	assert.Equal(t, 1, bf.FilterNum())
+
+	bf = NewWithExpansion(0, 0.5, 0)
+	assert.Equal(t, uint64(2), bf.BitNum())
+	assert.Equal(t, uint64(1), bf.HashNum())
+
+	for _, fpr := range []float64{0, 1, -0.5, math.NaN()} {
+		_, err := NewChecked(1000, fpr)
+		assert.Error(t, err)
+		assert.Panics(t, func() { New(1000, fpr) })
+	}
+	_, err := NewChecked(math.MaxUint64, 0.01)
+	assert.Error(t, err)
+}
+
+func TestAddExists(t *testing.T) {
+	const n = 10000
+	bf := New(n, 0.01)
+	// Add may report a new item as present, with the false positive rate.
+	added := 0
+	for i := 0; i < n; i++ {
+		if bf.Add([]byte(strconv.Itoa(i))) {
+			added++
+		}
+	}
+	assert.GreaterOrEqual(t, added, n*99/100)
+	assert.False(t, bf.Add([]byte("0")))
+	assert.Equal(t, uint64(added), bf.ItemNum())
+	for i := 0; i < n; i++ {
+		assert.True(t, bf.Exists([]byte(strconv.Itoa(i))))
+	}
+
+	fp := 0
+	for i := n; i < 11*n; i++ {
+		if bf.Exists([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	assert.Less(t, float64(fp)/(10*n), 0.01)
+	assert.Equal(t, 1, bf.FilterNum())
+}
+
+func TestScaling(t *testing.T) {
+	const n = 100000
+	bf := New(1000, 0.01)
+	for i := 0; i < n; i++ {
+		bf.Add([]byte(strconv.Itoa(i)))
+		if i == 999 {
+			assert.Equal(t, 1, bf.FilterNum())
+			assert.InDelta(t, 0.5, bf.FillRatio(), 0.02)
+		}
+	}
+	// 1000 + 2000 + ... + 32000 < n <= 1000 + 2000 + ... + 64000
+	assert.Equal(t, 7, bf.FilterNum())
+	for i := 1; i < bf.FilterNum(); i++ {
+		assert.Equal(t, 2*bf.filters[i-1].capacity, bf.filters[i].capacity)
+		assert.GreaterOrEqual(t, bf.filters[i].hashNum, bf.filters[i-1].hashNum)
+	}
+	assert.Less(t, bf.FillRatio(), 0.5)
+	for i := 0; i < n; i++ {
+		assert.True(t, bf.Exists([]byte(strconv.Itoa(i))))
+	}
+
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if bf.Exists([]byte(strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	assert.Less(t, float64(fp)/n, 0.01)
+
+	// A non-scaling filter keeps a single sub-filter, and fills up.
+	bf = NewWithExpansion(1000, 0.01, 0)
+	for i := 0; i < 10000; i++ {
+		bf.Add([]byte(strconv.Itoa(i)))
+	}
+	assert.Equal(t, 1, bf.FilterNum())
+	assert.Greater(t, bf.FillRatio(), 0.9)
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(1000, 0.01), New(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 500)))
+	}
+	assert.NoError(t, a.Merge(b))
+	assert.Equal(t, uint64(1000), a.ItemNum())
+	for i := 0; i < 1000; i++ {
+		assert.True(t, a.Exists([]byte(strconv.Itoa(i))))
+	}
+
+	// Merging a filter into itself changes nothing but the counts.
+	fill := a.FillRatio()
+	assert.NoError(t, a.Merge(a))
+	assert.Equal(t, fill, a.FillRatio())
+
+	for _, other := range []*BloomFilter{New(2000, 0.01), New(1000, 0.001), NewWithExpansion(1000, 0.01, 4)} {
+		assert.Error(t, b.Merge(other))
+	}
+	a.Add([]byte("grow"))
+	assert.Equal(t, 2, a.FilterNum())
+	assert.Error(t, b.Merge(a))
+	assert.Equal(t, uint64(500), b.ItemNum())
+}
+
+func TestEstimateCount(t *testing.T) {
+	bf := New(10000, 0.01)
+	assert.Equal(t, uint64(0), bf.EstimateCount())
+	assert.Equal(t, uint64(0), bf.PopCount())
+
+	for _, n := range []int{100, 5000, 10000, 50000} {
+		for i := 0; i < n; i++ {
+			bf.Add([]byte(strconv.Itoa(i)))
+		}
+		assert.InEpsilon(t, n, bf.EstimateCount(), 0.02, "%d items", n)
+	}
+	assert.Equal(t, bf.FillRatio(), float64(bf.PopCount())/float64(bf.BitNum()))
+
+	// Merging a filter into itself doubles ItemNum, not the estimate.
+	estimate := bf.EstimateCount()
+	assert.NoError(t, bf.Merge(bf))
+	assert.Equal(t, estimate, bf.EstimateCount())
+
+	bf = NewWithExpansion(10, 0.5, 0)
+	for i := 0; i < 1000; i++ {
+		bf.Add([]byte(strconv.Itoa(i)))
+	}
+	assert.Equal(t, bf.BitNum(), bf.PopCount())
+	assert.Equal(t, uint64(math.MaxUint64), bf.EstimateCount())
+}
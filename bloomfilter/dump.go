@@ -0,0 +1,122 @@
+package bloomfilter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// The binary format starts with binaryMagic and binaryVersion, followed by the
+// expansion, the item count and the number of sub-filters as little-endian
+// uint64. Each sub-filter follows, as its bit count, hash count, capacity,
+// item count and false positive rate, then its bits as little-endian uint64
+// words. It ends with the CRC32 of everything before.
+const (
+	binaryMagic      = "BLMF"
+	binaryVersion    = 1
+	binaryHeaderSize = len(binaryMagic) + 1 + 3*8
+	subHeaderSize    = 5 * 8
+	checksumSize     = 4
+)
+
+// ErrCorruptData is returned when decoding data whose checksum doesn't match.
+var ErrCorruptData = errors.New("corrupt data")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	size := binaryHeaderSize + checksumSize
+	for i := range bf.filters {
+		size += subHeaderSize + len(bf.filters[i].bits)*8
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, binaryMagic...)
+	data = append(data, binaryVersion)
+	data = binary.LittleEndian.AppendUint64(data, bf.expansion)
+	data = binary.LittleEndian.AppendUint64(data, bf.itemNum)
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(bf.filters)))
+	for i := range bf.filters {
+		sf := &bf.filters[i]
+		data = binary.LittleEndian.AppendUint64(data, sf.bitNum)
+		data = binary.LittleEndian.AppendUint64(data, sf.hashNum)
+		data = binary.LittleEndian.AppendUint64(data, sf.capacity)
+		data = binary.LittleEndian.AppendUint64(data, sf.itemNum)
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(sf.fpr))
+		for _, w := range sf.bits {
+			data = binary.LittleEndian.AppendUint64(data, w)
+		}
+	}
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data)), nil
+}
+
+// maxHashNum bounds the number of hashes of a decoded sub-filter. New takes
+// about -log2(fpr) hashes, which is below it for every positive float64, so
+// only corrupt data exceeds it, and would make every operation loop for ages.
+const maxHashNum = 2048
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The checksum is verified before anything is decoded, and ErrCorruptData is
+// returned if it doesn't match.
+func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize+checksumSize {
+		return errors.New("data too short")
+	}
+	if string(data[:len(binaryMagic)]) != binaryMagic {
+		return errors.New("not a bloom filter")
+	}
+	payload, tail := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(tail) {
+		return ErrCorruptData
+	}
+	if payload[len(binaryMagic)] != binaryVersion {
+		return errors.New("unsupported version")
+	}
+
+	rest := payload[len(binaryMagic)+1:]
+	next := func() uint64 {
+		v := binary.LittleEndian.Uint64(rest)
+		rest = rest[8:]
+		return v
+	}
+	res := BloomFilter{expansion: next(), itemNum: next()}
+	filterNum := next()
+	// Each sub-filter takes at least its header, which bounds filterNum by the
+	// size of the data.
+	if filterNum == 0 || filterNum > uint64(len(rest)/subHeaderSize) {
+		return fmt.Errorf("%w: invalid number of sub-filters", ErrCorruptData)
+	}
+	res.filters = make([]subFilter, filterNum)
+	for i := range res.filters {
+		if len(rest) < subHeaderSize {
+			return errors.New("data too short")
+		}
+		sf := subFilter{
+			bitNum:   next(),
+			hashNum:  next(),
+			capacity: next(),
+			itemNum:  next(),
+			fpr:      math.Float64frombits(next()),
+		}
+		if sf.bitNum == 0 || sf.hashNum == 0 || sf.hashNum > maxHashNum || sf.capacity == 0 ||
+			!(sf.fpr > 0 && sf.fpr < 1) {
+			return fmt.Errorf("%w: invalid sub-filter", ErrCorruptData)
+		}
+		words := (sf.bitNum-1)/64 + 1
+		if words > uint64(len(rest)/8) {
+			return errors.New("data too short")
+		}
+		sf.bits = make([]uint64, words)
+		for j := range sf.bits {
+			sf.bits[j] = next()
+		}
+		res.filters[i] = sf
+	}
+	if len(rest) != 0 {
+		return errors.New("trailing data")
+	}
+
+	*bf = res
+	return nil
+}
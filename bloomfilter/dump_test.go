@@ -0,0 +1,62 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	bf := New(1000, 0.01)
+	for i := 0; i < 3000; i++ {
+		bf.Add([]byte(strconv.Itoa(i)))
+	}
+	assert.Equal(t, 2, bf.FilterNum())
+
+	data, err := bf.MarshalBinary()
+	assert.NoError(t, err)
+	loaded := &BloomFilter{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, bf, loaded)
+	for i := 0; i < 3000; i++ {
+		assert.True(t, loaded.Exists([]byte(strconv.Itoa(i))))
+	}
+
+	// The loaded filter keeps growing the same way.
+	for i := 3000; i < 8000; i++ {
+		bf.Add([]byte(strconv.Itoa(i)))
+		loaded.Add([]byte(strconv.Itoa(i)))
+	}
+	assert.Equal(t, bf, loaded)
+
+	assert.Error(t, loaded.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, loaded.UnmarshalBinary(append(bytes.Clone(data), 0)))
+	assert.Error(t, loaded.UnmarshalBinary(data[:10]))
+	corrupted := bytes.Clone(data)
+	corrupted[binaryHeaderSize+3] ^= 1
+	assert.ErrorIs(t, loaded.UnmarshalBinary(corrupted), ErrCorruptData)
+	assert.Equal(t, bf, loaded)
+
+	// Sub-filter parameters that no filter has are rejected, even with a
+	// valid checksum.
+	withField := func(off int, v uint64) []byte {
+		d := bytes.Clone(data[:len(data)-checksumSize])
+		binary.LittleEndian.PutUint64(d[binaryHeaderSize+off:], v)
+		return binary.LittleEndian.AppendUint32(d, crc32.ChecksumIEEE(d))
+	}
+	for _, d := range [][]byte{
+		withField(8, 1<<60),
+		withField(8, maxHashNum+1),
+		withField(32, math.Float64bits(math.NaN())),
+		withField(32, math.Float64bits(0)),
+		withField(32, math.Float64bits(1)),
+	} {
+		assert.ErrorIs(t, loaded.UnmarshalBinary(d), ErrCorruptData)
+	}
+	assert.NoError(t, loaded.UnmarshalBinary(withField(8, maxHashNum)))
+}
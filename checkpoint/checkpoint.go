@@ -0,0 +1,180 @@
+// Package checkpoint saves and loads sets of named data structures of this
+// module, e.g. to checkpoint all the filters and sketches of a process at once.
+// Each structure is written with its type tag, and Load dispatches on the tag
+// to decode it into the registered type.
+package checkpoint
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/fukua95/pds/bloomfilter"
+	"github.com/fukua95/pds/countminsketch"
+	"github.com/fukua95/pds/cuckoofilter"
+	"github.com/fukua95/pds/hyperloglog"
+	"github.com/fukua95/pds/tdigest"
+	"github.com/fukua95/pds/topk"
+)
+
+// Dumpable is implemented by the structures that can be checkpointed: all the
+// filters and sketches of this module that have a binary encoding.
+type Dumpable interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]func() Dumpable)
+	tags      = make(map[reflect.Type]string)
+)
+
+// Register makes the type of the values returned by newFn loadable under tag.
+// newFn returns an empty value, into which Load decodes. It panics if tag or
+// the type is already registered.
+func Register(tag string, newFn func() Dumpable) {
+	mu.Lock()
+	defer mu.Unlock()
+	typ := reflect.TypeOf(newFn())
+	if _, ok := factories[tag]; ok {
+		panic("checkpoint: tag " + tag + " registered twice")
+	}
+	if _, ok := tags[typ]; ok {
+		panic("checkpoint: type " + typ.String() + " registered twice")
+	}
+	factories[tag] = newFn
+	tags[typ] = tag
+}
+
+func init() {
+	Register("cuckoofilter", func() Dumpable { return &cuckoofilter.CuckooFilter{} })
+	Register("countminsketch", func() Dumpable { return &countminsketch.CMS{} })
+	Register("bloomfilter", func() Dumpable { return &bloomfilter.BloomFilter{} })
+	Register("hyperloglog", func() Dumpable { return &hyperloglog.HLL{} })
+	Register("topk", func() Dumpable { return &topk.TopK{} })
+	Register("tdigest", func() Dumpable { return &tdigest.TDigest{} })
+}
+
+// The format starts with magic and version, followed by the number of entries
+// as a little-endian uint64. Each entry follows, sorted by name, as its name,
+// its tag and its encoding, each preceded by its length as a little-endian
+// uint64. The encodings carry their own checksums.
+const (
+	magic   = "PDSC"
+	version = 1
+
+	// maxNameSize bounds the size of names and tags.
+	maxNameSize = 1 << 16
+)
+
+// Save writes items to w, with the tags of their types, which must have been
+// registered.
+func Save(w io.Writer, items map[string]Dumpable) error {
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	bw.WriteString(magic)
+	bw.WriteByte(version)
+	bw.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(items))))
+	for _, name := range names {
+		tag, ok := tags[reflect.TypeOf(items[name])]
+		if !ok {
+			return fmt.Errorf("%s: unregistered type %T", name, items[name])
+		}
+		data, err := items[name].MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, field := range [][]byte{[]byte(name), []byte(tag), data} {
+			bw.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(field))))
+			bw.Write(field)
+		}
+	}
+	return bw.Flush()
+}
+
+// Load reads the items written by Save, decoding each into a new value of the
+// type registered for its tag.
+func Load(r io.Reader) (map[string]Dumpable, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(magic)+1+8)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, errors.New("not a checkpoint")
+	}
+	if head[len(magic)] != version {
+		return nil, errors.New("unsupported version")
+	}
+	n := binary.LittleEndian.Uint64(head[len(magic)+1:])
+
+	mu.RLock()
+	defer mu.RUnlock()
+	items := make(map[string]Dumpable)
+	for range n {
+		name, err := readField(br, maxNameSize)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := readField(br, maxNameSize)
+		if err != nil {
+			return nil, err
+		}
+		newFn, ok := factories[string(tag)]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown tag %q", name, tag)
+		}
+		data, err := readField(br, -1)
+		if err != nil {
+			return nil, err
+		}
+		v := newFn()
+		if err := v.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		items[string(name)] = v
+	}
+	return items, nil
+}
+
+// readField reads a field preceded by its length, of at most maxSize bytes if
+// maxSize isn't negative. The field is read in chunks, so that a corrupt
+// length can't allocate more memory than r holds.
+func readField(r io.Reader, maxSize int) ([]byte, error) {
+	var size [8]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint64(size[:])
+	if maxSize >= 0 && n > uint64(maxSize) {
+		return nil, errors.New("field too large")
+	}
+	var buf []byte
+	for n > 0 {
+		chunk := min(n, 1<<20)
+		start := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		n -= chunk
+	}
+	return buf, nil
+}
@@ -0,0 +1,72 @@
+package checkpoint
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/fukua95/pds/bloomfilter"
+	"github.com/fukua95/pds/countminsketch"
+	"github.com/fukua95/pds/cuckoofilter"
+	"github.com/fukua95/pds/hyperloglog"
+	"github.com/fukua95/pds/tdigest"
+	"github.com/fukua95/pds/topk"
+	"github.com/stretchr/testify/assert"
+)
+
+type unregistered struct{ Dumpable }
+
+func TestSaveLoad(t *testing.T) {
+	cf := cuckoofilter.New(1024, 2, 20, 1)
+	cms, _ := countminsketch.New(0.01, 0.01)
+	bf := bloomfilter.New(1000, 0.01)
+	hll := hyperloglog.New(12)
+	tk := topk.New(5, 50, 5, 0.9)
+	td := tdigest.New(100)
+	for i := 0; i < 1000; i++ {
+		k := []byte(strconv.Itoa(i))
+		cf.Insert(k)
+		cms.IncrBy(k, 1)
+		bf.Add(k)
+		hll.Add(k)
+		tk.Add([]byte(strconv.Itoa(i % 7)))
+		td.Add(float64(i), 1)
+	}
+	items := map[string]Dumpable{"cf": cf, "cms": cms, "bf": bf, "hll": hll, "topk": tk, "td": td}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Save(&buf, items))
+	data := bytes.Clone(buf.Bytes())
+	loaded, err := Load(&buf)
+	assert.NoError(t, err)
+	assert.Len(t, loaded, len(items))
+	assert.True(t, cf.Equal(loaded["cf"].(*cuckoofilter.CuckooFilter)))
+	assert.Equal(t, cms, loaded["cms"])
+	assert.Equal(t, bf, loaded["bf"])
+	assert.Equal(t, hll, loaded["hll"])
+	assert.Equal(t, tk, loaded["topk"])
+	assert.Equal(t, td.Quantile(0.5), loaded["td"].(*tdigest.TDigest).Quantile(0.5))
+
+	// Entries are sorted, so checkpoints are reproducible.
+	buf.Reset()
+	assert.NoError(t, Save(&buf, items))
+	assert.Equal(t, data, buf.Bytes())
+
+	_, err = Load(bytes.NewReader(data[:len(data)-1]))
+	assert.Error(t, err)
+	_, err = Load(bytes.NewReader([]byte("nope")))
+	assert.Error(t, err)
+	assert.Error(t, Save(&buf, map[string]Dumpable{"x": unregistered{}}))
+}
+
+func TestRegister(t *testing.T) {
+	assert.Panics(t, func() { Register("topk", func() Dumpable { return unregistered{} }) })
+	assert.Panics(t, func() { Register("other", func() Dumpable { return &topk.TopK{} }) })
+
+	Register("unregistered", func() Dumpable { return unregistered{&topk.TopK{}} })
+	var buf bytes.Buffer
+	assert.NoError(t, Save(&buf, map[string]Dumpable{"x": unregistered{topk.New(1, 1, 1, 1)}}))
+	loaded, err := Load(&buf)
+	assert.NoError(t, err)
+	assert.IsType(t, unregistered{}, loaded["x"])
+}
@@ -0,0 +1,15 @@
+package countminsketch
+
+// CMS32 is a count-min sketch with 32-bit counters, which takes half the
+// memory of CMS on 64-bit platforms. Counters saturate at math.MaxUint32.
+type CMS32 = CMSOf[uint32]
+
+// New32 is like New, for a sketch with 32-bit counters.
+func New32(overEst float64, prob float64) (*CMS32, error) {
+	return NewOf[uint32](overEst, prob)
+}
+
+// NewByDim32 is like NewByDim, for a sketch with 32-bit counters.
+func NewByDim32(width uint, depth uint) (*CMS32, error) {
+	return NewByDimOf[uint32](width, depth)
+}
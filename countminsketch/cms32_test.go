@@ -0,0 +1,70 @@
+package countminsketch
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCMS32(t *testing.T) {
+	cms, err := NewByDim32(100000, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 100000*10*4, len(cms.cells)*int(unsafe.Sizeof(cms.cells[0])))
+	for _, dim := range [][2]uint{{0, 5}, {100, 0}, {math.MaxUint/4 + 1, 4}} {
+		_, err := NewByDim32(dim[0], dim[1])
+		assert.Error(t, err)
+	}
+
+	// The same estimates as CMS, with the same dimensions.
+	cms, _ = New32(0.01, 0.01)
+	ref, _ := New(0.01, 0.01)
+	for i := 0; i < 1000; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.Equal(t, uint32(ref.IncrBy(k, uint(i))), cms.IncrBy(k, uint32(i)))
+	}
+	assert.Equal(t, ref.TotalCount(), cms.TotalCount())
+	assert.Equal(t, ref.Width(), cms.Width())
+	assert.Equal(t, ref.Depth(), cms.Depth())
+	for i := 0; i < 1000; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.Equal(t, uint32(ref.Query(k)), cms.Query(k))
+	}
+
+	k := []byte("key")
+	cms.IncrBy(k, math.MaxUint32-1)
+	assert.Equal(t, uint32(math.MaxUint32), cms.IncrBy(k, 10))
+	assert.Equal(t, uint32(math.MaxUint32-5), cms.DecrBy(k, 5))
+	assert.Equal(t, uint32(0), cms.DecrBy(k, math.MaxUint32))
+
+	cms.Reset()
+	assert.Equal(t, uint(0), cms.counter)
+	fresh, _ := New32(0.01, 0.01)
+	assert.Equal(t, fresh, cms)
+}
+
+func TestCMS32Merge(t *testing.T) {
+	a, _ := New32(0.001, 0.01)
+	b, _ := New32(0.001, 0.01)
+	for i := 0; i < 1000; i++ {
+		k := []byte(strconv.Itoa(i))
+		a.IncrBy(k, 1)
+		b.IncrBy(k, 2)
+	}
+	assert.NoError(t, a.Merge(b))
+	assert.Equal(t, uint(3000), a.counter)
+	for i := 0; i < 1000; i++ {
+		assert.GreaterOrEqual(t, a.Query([]byte(strconv.Itoa(i))), uint32(3))
+	}
+
+	other, _ := New32(0.01, 0.01)
+	assert.Error(t, a.Merge(other))
+	assert.Equal(t, uint(3000), a.counter)
+
+	k := []byte("key")
+	a.IncrBy(k, math.MaxUint32-10)
+	assert.NoError(t, a.Merge(a))
+	assert.Equal(t, uint32(math.MaxUint32), a.Query(k))
+}
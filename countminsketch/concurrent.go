@@ -0,0 +1,108 @@
+package countminsketch
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// ConcurrentCMS is a count-min sketch safe for concurrent use, without locks:
+// every cell, and the total count, is an atomic counter.
+//
+// Each cell is updated atomically, but an IncrBy as a whole isn't: a Query
+// that runs concurrently with it may see some of its cells incremented and
+// others not. As cells only grow, the estimate is then between the ones from
+// before and after the IncrBy. Atomic operations are sequentially consistent,
+// so an IncrBy that returned before a Query started is always seen by it, and
+// Query never underestimates the increments that happened before it.
+type ConcurrentCMS struct {
+	width   uint
+	depth   uint
+	counter atomic.Uint64
+	// cells are stored in a single slice, row after row.
+	cells        []atomic.Uint64
+	conservative bool
+	hasher       Hasher
+}
+
+// NewConcurrent returns a concurrent sketch with the dimensions and the
+// counters of cms, which is left unchanged.
+func NewConcurrent(cms *CMS) *ConcurrentCMS {
+	c := &ConcurrentCMS{
+		width:        cms.width,
+		depth:        cms.depth,
+		cells:        make([]atomic.Uint64, cms.width*cms.depth),
+		conservative: cms.conservative,
+		hasher:       cms.hasher,
+	}
+	c.counter.Store(uint64(cms.counter))
+	for i := range cms.cells {
+		for j, v := range cms.cells[i] {
+			c.cells[uint(i)*cms.width+uint(j)].Store(uint64(v))
+		}
+	}
+	return c
+}
+
+// Width returns the number of counters per row.
+func (c *ConcurrentCMS) Width() uint {
+	return c.width
+}
+
+// Depth returns the number of rows.
+func (c *ConcurrentCMS) Depth() uint {
+	return c.depth
+}
+
+// TotalCount returns the sum of the increments of the sketch.
+func (c *ConcurrentCMS) TotalCount() uint {
+	return uint(c.counter.Load())
+}
+
+// IncrBy increments the count of data by val and returns its new estimate.
+// Cells are clamped at math.MaxUint, as with CMS.IncrBy.
+func (c *ConcurrentCMS) IncrBy(data []byte, val uint) uint {
+	minCount := uint(math.MaxUint)
+	h := hashOf(c.hasher, data)
+	for i := range c.depth {
+		cell := &c.cells[i*c.width+h.index(int(i), c.width)]
+		minCount = min(minCount, addAtomic(cell, val))
+	}
+	addAtomic(&c.counter, val)
+	return minCount
+}
+
+// addAtomic adds val to v, clamping at math.MaxUint, and returns the new value.
+func addAtomic(v *atomic.Uint64, val uint) uint {
+	for {
+		old := v.Load()
+		n := addSat(uint(old), val)
+		if v.CompareAndSwap(old, uint64(n)) {
+			return n
+		}
+	}
+}
+
+// Return an estimate counter for item.
+func (c *ConcurrentCMS) Query(data []byte) uint {
+	minCount := uint(math.MaxUint)
+	h := hashOf(c.hasher, data)
+	for i := range c.depth {
+		minCount = min(minCount, uint(c.cells[i*c.width+h.index(int(i), c.width)].Load()))
+	}
+	return minCount
+}
+
+// Snapshot returns a copy of the sketch as a CMS, e.g. to serialize or merge
+// it. Cells updated concurrently may or may not be included.
+func (c *ConcurrentCMS) Snapshot() *CMS {
+	res, _ := NewByDim(c.width, c.depth)
+	for i := range res.cells {
+		for j := range res.cells[i] {
+			res.cells[i][j] = uint(c.cells[uint(i)*c.width+uint(j)].Load())
+		}
+	}
+	res.counter = uint(c.counter.Load())
+	res.conservative = c.conservative
+	res.hasher = c.hasher
+	return res
+}
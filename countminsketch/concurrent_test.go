@@ -0,0 +1,47 @@
+package countminsketch
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrent(t *testing.T) {
+	const workers, n = 8, 2000
+	cms, _ := New(0.001, 0.01)
+	cms.IncrBy([]byte("init"), 3)
+	c := NewConcurrent(cms)
+	assert.Equal(t, cms.Width(), c.Width())
+	assert.Equal(t, cms.Depth(), c.Depth())
+	assert.Equal(t, uint(3), c.Query([]byte("init")))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				k := []byte(strconv.Itoa(i))
+				c.IncrBy(k, 1)
+				assert.GreaterOrEqual(t, c.Query(k), uint(1))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint(3+workers*n), c.TotalCount())
+	for i := 0; i < n; i++ {
+		k := []byte(strconv.Itoa(i))
+		cms.IncrBy(k, workers)
+		assert.GreaterOrEqual(t, c.Query(k), uint(workers))
+	}
+	assert.Equal(t, cms, c.Snapshot())
+
+	k := []byte("key")
+	c.IncrBy(k, math.MaxUint-1)
+	assert.Equal(t, uint(math.MaxUint), c.IncrBy(k, 10))
+	assert.Equal(t, uint(math.MaxUint), c.TotalCount())
+}
@@ -3,20 +3,74 @@ package countminsketch
 import (
 	"errors"
 	"math"
+	"slices"
 
-	"github.com/aviddiviner/go-murmur"
+	"github.com/fukua95/pds/internal/hashing"
 )
 
+// ErrSaturated is returned by IncrByChecked when a counter reached
+// math.MaxUint, after which the estimates that use it are meaningless.
+var ErrSaturated = errors.New("counter saturated")
+
 type CMS struct {
 	width   uint
 	depth   uint
 	counter uint
 	cells   [][]uint
+	// conservative is set once the sketch was updated by IncrByConservative.
+	conservative bool
+	hasher       Hasher // nil means MurmurHasher
 }
 
+// A Hasher hashes the items of a sketch. The cells of an item are derived from
+// two of its hashes, with different seeds.
+type Hasher = hashing.Hasher
+
+// MurmurHasher is the default Hasher: MurmurHash64A, as RedisBloom's filters
+// use. RedisBloom's sketches hash differently, see RedisBloomHasher.
+type MurmurHasher = hashing.Murmur
+
+// XXHasher is XXH64, which is faster than MurmurHasher on long items.
+type XXHasher = hashing.XXHash
+
+// RedisBloomHasher is the 32-bit MurmurHash2, and maps items to cells as
+// RedisBloom's CMS.* commands do: the cell of an item in row i is its hash
+// seeded with i, modulo the width, rather than derived from two hashes. It
+// takes one hash per row, but a sketch that uses it counts items in the same
+// cells as RedisBloom, e.g. one loaded by LoadRedisBloomCMS.
+type RedisBloomHasher = hashing.Murmur2
+
+// New returns a sketch whose estimates exceed the true counts by at most
+// overEst times the total count, except with probability prob. Both must be
+// in (0, 1); see dimFromProb for the dimensions.
 func New(overEst float64, prob float64) (*CMS, error) {
-	width, depth := dimFromProb(overEst, prob)
+	width, depth, err := dimFromProb(overEst, prob)
+	if err != nil {
+		return nil, err
+	}
+	return NewByDim(width, depth)
+}
 
+// NewWithHasher is like New, but the sketch hashes items with h instead of
+// MurmurHash64A, e.g. XXHasher.
+//
+// Serialized sketches record whether they use MurmurHasher, XXHasher or another
+// hasher, and decoding restores the first two. Other hashers can't be
+// serialized: a sketch built with one must be decoded into a sketch created by
+// NewWithHasher with the same hasher. Sketches that use different hashers
+// can't be merged.
+func NewWithHasher(overEst float64, prob float64, h Hasher) (*CMS, error) {
+	cms, err := New(overEst, prob)
+	if err != nil {
+		return nil, err
+	}
+	cms.hasher = h
+	return cms, nil
+}
+
+// NewByDim returns a sketch of depth rows of width counters, e.g. to match a
+// sketch created elsewhere.
+func NewByDim(width uint, depth uint) (*CMS, error) {
 	if width <= 0 || depth <= 0 {
 		return nil, errors.New("invalid Parameter")
 	}
@@ -37,25 +91,123 @@ func New(overEst float64, prob float64) (*CMS, error) {
 	return cms, nil
 }
 
-func (cms *CMS) hash(data []byte, seed uint64) uint {
-	return uint(murmur.MurmurHash64A(data, seed))
+// TotalCount returns the sum of the increments of the sketch, less its
+// decrements.
+func (cms *CMS) TotalCount() uint {
+	return cms.counter
+}
+
+// Width returns the number of counters per row.
+func (cms *CMS) Width() uint {
+	return cms.width
+}
+
+// Depth returns the number of rows.
+func (cms *CMS) Depth() uint {
+	return cms.depth
 }
 
-// Recommend width and depth for expected n different items,
-// with probability of an error (prob) and over estimation error (overEst)
-func dimFromProb(overEst float64, prob float64) (uint, uint) {
-	if overEst <= 0 || overEst >= 1 || prob <= 0 || prob >= 1 {
-		return 0, 0
+// itemHash locates the cells of an item, see hashOf.
+type itemHash struct {
+	h1, h2 uint64
+	// data is the item itself, for RedisBloomHasher, which hashes it once per
+	// row instead.
+	data   []byte
+	perRow bool
+}
+
+// hashOf returns what the cells of data are derived from. Usually that's two
+// hashes, for double hashing: the cell of data in row i is (h1 + i*h2) % width.
+// It takes two hashes per operation rather than one per row.
+//
+// h2 is seeded with h1: with seeds that differ in a single bit, such as 0 and
+// 1, the two hashes are correlated, and items collide about 30% more often.
+//
+// RedisBloomHasher instead hashes data with seed i for row i, as RedisBloom
+// does, so that sketches loaded from it find the cells of their items.
+func hashOf(h Hasher, data []byte) itemHash {
+	switch h.(type) {
+	case nil:
+		h = MurmurHasher{}
+	case RedisBloomHasher:
+		return itemHash{data: data, perRow: true}
 	}
-	width := uint(math.Ceil(2.0 / overEst))
-	depth := uint(math.Ceil(math.Log10(prob) / math.Log10(0.5)))
-	return width, depth
+	h1 := h.Sum64(data, 0)
+	return itemHash{h1: h1, h2: h.Sum64(data, h1)}
+}
+
+// index returns the cell in row i of the item.
+func (h itemHash) index(i int, width uint) uint {
+	if h.perRow {
+		return uint(RedisBloomHasher{}.Sum64(h.data, uint64(i)) % uint64(width))
+	}
+	return uint((h.h1 + uint64(i)*h.h2) % uint64(width))
+}
+
+// dimFromProb returns the dimensions of a sketch that overestimates by at most
+// overEst times the total count with a failure probability of failProb: each
+// row exceeds the bound with probability at most 1/2 when width is
+// ceil(2/overEst), so depth = ceil(log2(1/failProb)) rows all exceed it with
+// probability at most failProb.
+func dimFromProb(overEst float64, failProb float64) (width uint, depth uint, err error) {
+	if !(overEst > 0 && overEst < 1) || !(failProb > 0 && failProb < 1) {
+		return 0, 0, errors.New("invalid Parameter")
+	}
+	w := math.Ceil(2.0 / overEst)
+	d := math.Ceil(-math.Log2(failProb))
+	if w >= math.MaxUint || d >= math.MaxUint {
+		return 0, 0, errors.New("parameter are too large")
+	}
+	width, depth = uint(w), uint(d)
+	if width < 1 || depth < 1 {
+		return 0, 0, errors.New("invalid Parameter")
+	}
+	return width, depth, nil
+}
+
+// ErrorBounds returns the guarantees of the dimensions of the sketch, the
+// inverse of New: Query overestimates a count by at most overEst times
+// TotalCount, except with probability prob.
+func (cms *CMS) ErrorBounds() (overEst float64, prob float64) {
+	return 2 / float64(cms.width), math.Pow(0.5, float64(cms.depth))
+}
+
+// MaxError returns the largest overestimate of Query, given the current total
+// count, that holds with probability 1 - prob (see ErrorBounds).
+func (cms *CMS) MaxError() uint {
+	overEst, _ := cms.ErrorBounds()
+	return uint(math.Ceil(overEst * float64(cms.counter)))
+}
+
+// CellStats returns the smallest and largest cells of the sketch, and the
+// number of cells that aren't 0. It complements ErrorBounds to monitor a
+// sketch: once maxCell is math.MaxUint, or close to whatever ceiling the
+// caller allows, cells are saturated and the estimates that use them are
+// meaningless, so the sketch should be scaled or reset. A high share of
+// non-zero cells means items collide often.
+func (cms *CMS) CellStats() (minCell, maxCell, nonZero uint) {
+	minCell = math.MaxUint
+	for _, row := range cms.cells {
+		for _, v := range row {
+			minCell = min(minCell, v)
+			maxCell = max(maxCell, v)
+			if v != 0 {
+				nonZero++
+			}
+		}
+	}
+	return minCell, maxCell, nonZero
 }
 
 func (cms *CMS) IncrBy(data []byte, val uint) uint {
+	return cms.incrBy(hashOf(cms.hasher, data), val)
+}
+
+// incrBy is IncrBy for the item hashed to h.
+func (cms *CMS) incrBy(h itemHash, val uint) uint {
 	minCount := uint(math.MaxUint)
 	for i := range cms.cells {
-		hash := cms.hash(data, uint64(i)) % cms.width
+		hash := h.index(i, cms.width)
 
 		cms.cells[i][hash] += val
 		if cms.cells[i][hash] < val {
@@ -68,12 +220,346 @@ func (cms *CMS) IncrBy(data []byte, val uint) uint {
 	return minCount
 }
 
-// Return an estimate counter for item.
-func (cms *CMS) Query(data []byte) uint {
+// Incr increments the count of data by 1 and returns its new estimate.
+func (cms *CMS) Incr(data []byte) uint {
+	return cms.IncrBy(data, 1)
+}
+
+// IncrByReturningPrev is like IncrBy, but also returns the estimate of data
+// from before the increment, without hashing data twice. A prev of 0 means
+// data was never seen before, since estimates never underestimate.
+func (cms *CMS) IncrByReturningPrev(data []byte, val uint) (prev, cur uint) {
+	prev, cur = math.MaxUint, math.MaxUint
+	h := hashOf(cms.hasher, data)
+	for i := range cms.cells {
+		hash := h.index(i, cms.width)
+		prev = min(prev, cms.cells[i][hash])
+		cms.cells[i][hash] = addSat(cms.cells[i][hash], val)
+		cur = min(cur, cms.cells[i][hash])
+	}
+	cms.counter += val
+	return prev, cur
+}
+
+// IncrByChecked is like IncrBy, but returns ErrSaturated if any of the cells
+// of data was clamped at math.MaxUint, so the caller knows to scale or reset
+// the sketch. The increment is applied either way.
+func (cms *CMS) IncrByChecked(data []byte, val uint) (uint, error) {
+	minCount := cms.IncrBy(data, val)
+	h := hashOf(cms.hasher, data)
+	for i := range cms.cells {
+		if cms.cells[i][h.index(i, cms.width)] == math.MaxUint {
+			return minCount, ErrSaturated
+		}
+	}
+	return minCount, nil
+}
+
+// DecrBy decrements the count of data by val and returns its new estimate.
+// Cells are clamped at 0, and so is the total count.
+//
+// Decrements break the guarantee that Query never underestimates: the cells
+// of data are shared with other items, and decrementing them for data also
+// lowers their counts. With decrements, treat Query as a lower bound on the
+// count rather than an upper bound.
+func (cms *CMS) DecrBy(data []byte, val uint) uint {
+	minCount := uint(math.MaxUint)
+	h := hashOf(cms.hasher, data)
+	for i := range cms.cells {
+		hash := h.index(i, cms.width)
+		cms.cells[i][hash] -= min(cms.cells[i][hash], val)
+		minCount = min(minCount, cms.cells[i][hash])
+	}
+	cms.counter -= min(cms.counter, val)
+	return minCount
+}
+
+// IncrByConservative is like IncrBy, but uses conservative update: the cells
+// of data are only raised to the new estimate, its current minimum plus val,
+// instead of all being incremented by val. Query still never underestimates,
+// and overestimates much less on skewed streams.
+//
+// The cells of such a sketch aren't the sum of the increments of the items
+// that map to them anymore, so it can't be merged: Merge rejects it.
+func (cms *CMS) IncrByConservative(data []byte, val uint) uint {
+	var buf [16]uint
+	idx := buf[:0]
 	minCount := uint(math.MaxUint)
+	h := hashOf(cms.hasher, data)
 	for i := range cms.cells {
-		hash := cms.hash(data, uint64(i)) % cms.width
+		hash := h.index(i, cms.width)
+		idx = append(idx, hash)
 		minCount = min(minCount, cms.cells[i][hash])
 	}
+
+	target := addSat(minCount, val)
+	for i, hash := range idx {
+		cms.cells[i][hash] = max(cms.cells[i][hash], target)
+	}
+	cms.counter += val
+	cms.conservative = true
+	return target
+}
+
+// Return an estimate counter for item.
+func (cms *CMS) Query(data []byte) uint {
+	return cms.query(hashOf(cms.hasher, data))
+}
+
+// query is Query for the item hashed to h.
+func (cms *CMS) query(h itemHash) uint {
+	minCount := uint(math.MaxUint)
+	for i, row := range cms.cells {
+		minCount = min(minCount, row[h.index(i, cms.width)])
+	}
 	return minCount
 }
+
+// QueryWithError returns the estimate of data, as Query, along with
+// MaxError: the count of data is at most estimate, and at least estimate less
+// maxOverEstimate with probability 1 - prob (see ErrorBounds). It's meant to
+// display an estimate with its error, e.g. "estimate ± maxOverEstimate".
+func (cms *CMS) QueryWithError(data []byte) (estimate uint, maxOverEstimate uint) {
+	return cms.Query(data), cms.MaxError()
+}
+
+// IsHeavyHitter reports whether the estimate of data is at least fraction of
+// the total count. Since Query never underestimates, a key above the threshold
+// is always reported, but keys below it may be too.
+func (cms *CMS) IsHeavyHitter(data []byte, fraction float64) bool {
+	return float64(cms.Query(data)) >= fraction*float64(cms.counter)
+}
+
+// QueryMany returns the estimates of items, in the same order, as Query would.
+// The rows are walked once per item, and only the result is allocated. Each
+// item is hashed right before its cells are read: hashing a batch of items
+// first is slower, see BenchmarkQueryMany.
+func (cms *CMS) QueryMany(items [][]byte) []uint {
+	res := make([]uint, len(items))
+	for k, data := range items {
+		res[k] = cms.query(hashOf(cms.hasher, data))
+	}
+	return res
+}
+
+// IncrByMany increments the count of each of items by the value at the same
+// index of vals, in order, as IncrBy would, and returns their new estimates.
+// If items and vals have different lengths, an error is returned and cms is
+// left unchanged. As with QueryMany, each item is hashed right before its
+// cells are updated.
+func (cms *CMS) IncrByMany(items [][]byte, vals []uint) ([]uint, error) {
+	if len(items) != len(vals) {
+		return nil, errors.New("items and vals have different lengths")
+	}
+	res := make([]uint, len(items))
+	for k, data := range items {
+		res[k] = cms.incrBy(hashOf(cms.hasher, data), vals[k])
+	}
+	return res, nil
+}
+
+// Merge adds the counters of others into cms.
+// All sketches must have the same width, depth and hasher, and none of them
+// may have been updated by IncrByConservative; otherwise an error is returned
+// and cms is left unchanged. Cells are clamped at math.MaxUint.
+func (cms *CMS) Merge(others ...*CMS) error {
+	if cms.conservative {
+		return errors.New("conservative sketches can't be merged")
+	}
+	for _, o := range others {
+		if o.conservative {
+			return errors.New("conservative sketches can't be merged")
+		}
+		if o.width != cms.width || o.depth != cms.depth {
+			return errors.New("sketches have different dimensions")
+		}
+		if !cms.sameHasher(o) {
+			return errors.New("sketches use different hashers")
+		}
+	}
+
+	for _, o := range others {
+		for i := range cms.cells {
+			for j, v := range o.cells[i] {
+				cms.cells[i][j] = addSat(cms.cells[i][j], v)
+			}
+		}
+		cms.counter = addSat(cms.counter, o.counter)
+	}
+	return nil
+}
+
+// MergeWeighted adds the counters of sketches, each multiplied by its weight,
+// into cms, e.g. to correct for sources sampled at different rates. The
+// weighted sum of each cell is rounded to the nearest integer before it's
+// added, and clamped at math.MaxUint. Merge is MergeWeighted with weights of 1.
+// The conditions of Merge apply, and the weights must be finite and not
+// negative; otherwise an error is returned and cms is left unchanged.
+func (cms *CMS) MergeWeighted(sketches []*CMS, weights []float64) error {
+	if len(sketches) != len(weights) {
+		return errors.New("sketches and weights have different lengths")
+	}
+	for _, w := range weights {
+		if !(w >= 0 && w <= math.MaxFloat64) {
+			return errors.New("invalid weight")
+		}
+	}
+	if cms.conservative {
+		return errors.New("conservative sketches can't be merged")
+	}
+	for _, o := range sketches {
+		if o.conservative {
+			return errors.New("conservative sketches can't be merged")
+		}
+		if o.width != cms.width || o.depth != cms.depth {
+			return errors.New("sketches have different dimensions")
+		}
+		if !cms.sameHasher(o) {
+			return errors.New("sketches use different hashers")
+		}
+	}
+
+	for i := range cms.cells {
+		for j := range cms.cells[i] {
+			sum := 0.0
+			for k, o := range sketches {
+				sum += weights[k] * float64(o.cells[i][j])
+			}
+			cms.cells[i][j] = addSat(cms.cells[i][j], roundSat(sum))
+		}
+	}
+	sum := 0.0
+	for k, o := range sketches {
+		sum += weights[k] * float64(o.counter)
+	}
+	cms.counter = addSat(cms.counter, roundSat(sum))
+	return nil
+}
+
+// roundSat returns f rounded to the nearest integer, or math.MaxUint if it
+// doesn't fit, for f >= 0.
+func roundSat(f float64) uint {
+	if f >= math.MaxUint {
+		return math.MaxUint
+	}
+	return uint(math.Round(f))
+}
+
+// Subtract subtracts the counters of other from those of cms, clamping at 0,
+// e.g. to get the net counts of a sketch of inserts and one of deletes. As
+// with DecrBy, Query on the result is a lower bound on the net count of an
+// item rather than an upper bound.
+// Both sketches must have the same width, depth and hasher, and neither may
+// have been updated by IncrByConservative; otherwise an error is returned and
+// cms is left unchanged.
+func (cms *CMS) Subtract(other *CMS) error {
+	if cms.conservative || other.conservative {
+		return errors.New("conservative sketches can't be subtracted")
+	}
+	if other.width != cms.width || other.depth != cms.depth {
+		return errors.New("sketches have different dimensions")
+	}
+	if !cms.sameHasher(other) {
+		return errors.New("sketches use different hashers")
+	}
+
+	for i := range cms.cells {
+		for j, v := range other.cells[i] {
+			cms.cells[i][j] -= min(cms.cells[i][j], v)
+		}
+	}
+	cms.counter -= min(cms.counter, other.counter)
+	return nil
+}
+
+// addSat returns a + b, or math.MaxUint if it overflows.
+func addSat(a, b uint) uint {
+	if a+b < a {
+		return math.MaxUint
+	}
+	return a + b
+}
+
+// Reset sets all counters to 0, keeping the dimensions and the memory of the
+// sketch.
+func (cms *CMS) Reset() {
+	for i := range cms.cells {
+		clear(cms.cells[i])
+	}
+	cms.counter = 0
+	cms.conservative = false
+}
+
+// Scale multiplies every counter, and the total count, by factor, rounding
+// down, so that older counts fade as new ones are added: calling it
+// periodically gives an exponential decay of the counts. It panics unless
+// 0 <= factor <= 1.
+//
+// Rounding is done cell by cell, so Query(data) after Scale may be slightly
+// lower than Query(data) * factor, but it's still the minimum of the cells of
+// data, and still an upper bound on the decayed count of data.
+func (cms *CMS) Scale(factor float64) {
+	if !(factor >= 0 && factor <= 1) {
+		panic("countminsketch: scale factor out of [0, 1]")
+	}
+	for i := range cms.cells {
+		for j, v := range cms.cells[i] {
+			cms.cells[i][j] = scale(v, factor)
+		}
+	}
+	cms.counter = scale(cms.counter, factor)
+}
+
+// scale returns v * factor rounded down, for factor <= 1.
+func scale(v uint, factor float64) uint {
+	r := float64(v) * factor
+	// float64(v) may round above v, or even above math.MaxUint.
+	if r >= float64(v) {
+		return v
+	}
+	return uint(r)
+}
+
+// Clone returns a deep copy of cms, which can be updated independently.
+func (cms *CMS) Clone() *CMS {
+	res := *cms
+	res.cells = make([][]uint, len(cms.cells))
+	for i := range cms.cells {
+		res.cells[i] = slices.Clone(cms.cells[i])
+	}
+	return &res
+}
+
+// QueryMeanMin returns an estimate of the count of data with the count-mean-min
+// estimator: the count of each row is corrected by the noise expected from the
+// other items, (counter - cell) / (width - 1), and the median of the corrected
+// counts is returned, clamped to [0, Query(data)]. It overestimates less than
+// Query, mostly for the light items of skewed streams, but may underestimate.
+func (cms *CMS) QueryMeanMin(data []byte) uint {
+	minCount := cms.Query(data)
+	if cms.width < 2 {
+		return minCount
+	}
+
+	estimates := make([]float64, len(cms.cells))
+	h := hashOf(cms.hasher, data)
+	for i := range cms.cells {
+		cell := cms.cells[i][h.index(i, cms.width)]
+		noise := 0.0
+		if cms.counter > cell {
+			noise = float64(cms.counter-cell) / float64(cms.width-1)
+		}
+		estimates[i] = float64(cell) - noise
+	}
+	slices.Sort(estimates)
+	n := len(estimates)
+	median := estimates[n/2]
+	if n%2 == 0 {
+		median = (estimates[n/2-1] + estimates[n/2]) / 2
+	}
+
+	if median <= 0 {
+		return 0
+	}
+	return min(uint(median), minCount)
+}
@@ -0,0 +1,579 @@
+package countminsketch
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewByDim(t *testing.T) {
+	cms, err := NewByDim(100, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(100), cms.Width())
+	assert.Equal(t, uint(5), cms.Depth())
+	assert.Equal(t, uint(0), cms.TotalCount())
+	assert.Len(t, cms.cells, 5)
+	for _, row := range cms.cells {
+		assert.Len(t, row, 100)
+	}
+
+	// Same dimensions as New.
+	byProb, _ := New(0.02, 0.01)
+	byDim, _ := NewByDim(byProb.width, byProb.depth)
+	assert.Equal(t, byProb, byDim)
+	assert.NoError(t, byProb.Merge(byDim))
+
+	for _, dim := range [][2]uint{{0, 5}, {100, 0}, {math.MaxUint/4 + 1, 4}} {
+		cms, err := NewByDim(dim[0], dim[1])
+		assert.Error(t, err)
+		assert.Nil(t, cms)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, _ := New(0.001, 0.01)
+	b, _ := New(0.001, 0.01)
+	c, _ := New(0.001, 0.01)
+	for i := 0; i < 1000; i++ {
+		k := []byte(strconv.Itoa(i))
+		a.IncrBy(k, 1)
+		b.IncrBy(k, 2)
+		c.IncrBy(k, uint(i))
+	}
+
+	assert.NoError(t, a.Merge(b, c))
+	assert.Equal(t, uint(3000+999*1000/2), a.counter)
+	for i := 0; i < 1000; i++ {
+		assert.GreaterOrEqual(t, a.Query([]byte(strconv.Itoa(i))), uint(3+i))
+	}
+
+	other, _ := New(0.01, 0.01)
+	assert.Error(t, a.Merge(b, other))
+	assert.Equal(t, uint(3000+999*1000/2), a.counter)
+
+	// Sketches must use the same hasher, also through snapshots.
+	xx, _ := NewWithHasher(0.001, 0.01, XXHasher{})
+	xx.IncrBy([]byte("a"), 1)
+	assert.Error(t, a.Merge(b, xx))
+	assert.Error(t, a.Merge(NewConcurrent(xx).Snapshot()))
+	redis := xx.Clone()
+	redis.hasher = RedisBloomHasher{}
+	assert.Error(t, a.Merge(redis))
+	assert.Equal(t, uint(3000+999*1000/2), a.counter)
+	assert.NoError(t, xx.Merge(NewConcurrent(xx).Snapshot()))
+
+	// Cells saturate instead of wrapping around.
+	k := []byte("key")
+	d, _ := New(0.001, 0.01)
+	d.IncrBy(k, math.MaxUint-10)
+	assert.NoError(t, d.Merge(d))
+	assert.Equal(t, uint(math.MaxUint), d.Query(k))
+	assert.Equal(t, uint(math.MaxUint), d.counter)
+}
+
+func TestReset(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	for i := 0; i < 1000; i++ {
+		cms.IncrBy([]byte(strconv.Itoa(i)), uint(i+1))
+	}
+	row := &cms.cells[0][0]
+	cms.Reset()
+	assert.Same(t, row, &cms.cells[0][0])
+	assert.Equal(t, uint(0), cms.counter)
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, uint(0), cms.Query([]byte(strconv.Itoa(i))))
+	}
+	fresh, _ := New(0.01, 0.01)
+	assert.Equal(t, fresh, cms)
+}
+
+func TestIncrByConservative(t *testing.T) {
+	classic, _ := NewByDim(200, 4)
+	cons, _ := NewByDim(200, 4)
+	// A skewed stream: item i occurs i times.
+	for i := 1; i <= 300; i++ {
+		k := []byte(strconv.Itoa(i))
+		for j := 0; j < i; j++ {
+			classic.IncrBy(k, 1)
+			cons.IncrByConservative(k, 1)
+		}
+	}
+	assert.Equal(t, classic.counter, cons.counter)
+
+	classicErr, consErr := uint(0), uint(0)
+	for i := 1; i <= 300; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.GreaterOrEqual(t, cons.Query(k), uint(i))
+		assert.LessOrEqual(t, cons.Query(k), classic.Query(k))
+		classicErr += classic.Query(k) - uint(i)
+		consErr += cons.Query(k) - uint(i)
+	}
+	assert.Less(t, consErr, classicErr/2)
+
+	assert.Error(t, cons.Merge(classic))
+	assert.Error(t, classic.Merge(cons))
+	cons.Reset()
+	assert.NoError(t, cons.Merge(classic))
+
+	k := []byte("key")
+	assert.Equal(t, uint(math.MaxUint), cons.IncrByConservative(k, math.MaxUint))
+	assert.Equal(t, uint(math.MaxUint), cons.IncrByConservative(k, 1))
+}
+
+func TestQueryMeanMin(t *testing.T) {
+	cms, _ := NewByDim(100, 5)
+	// A few heavy items and many light ones.
+	for i := 0; i < 2000; i++ {
+		cms.IncrBy([]byte(strconv.Itoa(i)), 1)
+	}
+	for i := 0; i < 5; i++ {
+		cms.IncrBy([]byte("heavy"+strconv.Itoa(i)), 1000)
+	}
+
+	minErr, meanMinErr := 0.0, 0.0
+	for i := 0; i < 2000; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.LessOrEqual(t, cms.QueryMeanMin(k), cms.Query(k))
+		minErr += math.Abs(float64(cms.Query(k)) - 1)
+		meanMinErr += math.Abs(float64(cms.QueryMeanMin(k)) - 1)
+	}
+	assert.Less(t, meanMinErr, minErr/2)
+	for i := 0; i < 5; i++ {
+		assert.InDelta(t, 1000, float64(cms.QueryMeanMin([]byte("heavy"+strconv.Itoa(i)))), 100)
+	}
+
+	narrow, _ := NewByDim(1, 3)
+	narrow.IncrBy([]byte("a"), 5)
+	assert.Equal(t, uint(5), narrow.QueryMeanMin([]byte("b")))
+
+	empty, _ := NewByDim(100, 4)
+	assert.Equal(t, uint(0), empty.QueryMeanMin([]byte("a")))
+}
+
+func TestDecrBy(t *testing.T) {
+	cms, _ := New(0.001, 0.01)
+	a, b := []byte("a"), []byte("b")
+	cms.IncrBy(a, 10)
+	cms.IncrBy(b, 3)
+	assert.Equal(t, uint(6), cms.DecrBy(a, 4))
+	assert.Equal(t, uint(6), cms.Query(a))
+	assert.Equal(t, uint(9), cms.TotalCount())
+
+	assert.Equal(t, uint(0), cms.DecrBy(b, 5))
+	assert.Equal(t, uint(0), cms.Query(b))
+	assert.Equal(t, uint(4), cms.counter)
+
+	assert.Equal(t, uint(0), cms.DecrBy(a, math.MaxUint))
+	assert.Equal(t, uint(0), cms.counter)
+	assert.Equal(t, uint(0), cms.DecrBy([]byte("missing"), 1))
+}
+
+func TestQueryMany(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	items := make([][]byte, 100)
+	for i := range items {
+		items[i] = []byte(strconv.Itoa(i))
+		cms.IncrBy(items[i], uint(i))
+	}
+	items = append(items, []byte("missing"))
+
+	counts := cms.QueryMany(items)
+	assert.Len(t, counts, len(items))
+	for i, data := range items {
+		assert.Equal(t, cms.Query(data), counts[i])
+	}
+	assert.Empty(t, cms.QueryMany(nil))
+}
+
+func TestIncrByMany(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	single, _ := New(0.01, 0.01)
+	items := make([][]byte, 200)
+	vals := make([]uint, len(items))
+	for i := range items {
+		items[i] = []byte(strconv.Itoa(i % 150))
+		vals[i] = uint(i)
+	}
+	vals[3] = math.MaxUint
+
+	counts, err := cms.IncrByMany(items, vals)
+	assert.NoError(t, err)
+	for i, data := range items {
+		assert.Equal(t, single.IncrBy(data, vals[i]), counts[i])
+	}
+	assert.Equal(t, single, cms)
+	counts, err = cms.IncrByMany(nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+
+	_, err = cms.IncrByMany(items, vals[1:])
+	assert.Error(t, err)
+	assert.Equal(t, single, cms)
+}
+
+func TestCellStats(t *testing.T) {
+	cms, _ := NewByDim(100, 4)
+	minCell, maxCell, nonZero := cms.CellStats()
+	assert.Equal(t, [3]uint{0, 0, 0}, [3]uint{minCell, maxCell, nonZero})
+
+	cms.IncrBy([]byte("a"), 3)
+	cms.IncrBy([]byte("b"), 5)
+	minCell, maxCell, nonZero = cms.CellStats()
+	assert.Equal(t, uint(0), minCell)
+	assert.GreaterOrEqual(t, maxCell, uint(5))
+	assert.LessOrEqual(t, maxCell, uint(8))
+	assert.GreaterOrEqual(t, nonZero, uint(4))
+	assert.LessOrEqual(t, nonZero, uint(8))
+
+	// A saturated cell shows as the max.
+	cms.IncrBy([]byte("a"), math.MaxUint)
+	_, maxCell, _ = cms.CellStats()
+	assert.Equal(t, uint(math.MaxUint), maxCell)
+
+	full, _ := NewByDim(1, 2)
+	full.IncrBy([]byte("a"), 2)
+	minCell, maxCell, nonZero = full.CellStats()
+	assert.Equal(t, [3]uint{2, 2, 2}, [3]uint{minCell, maxCell, nonZero})
+}
+
+func TestIncrByReturningPrev(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	assert.Equal(t, uint(1), cms.Incr([]byte("a")))
+	assert.Equal(t, uint(2), cms.Incr([]byte("a")))
+
+	prev, cur := cms.IncrByReturningPrev([]byte("b"), 5)
+	assert.Equal(t, [2]uint{0, 5}, [2]uint{prev, cur})
+	prev, cur = cms.IncrByReturningPrev([]byte("a"), 3)
+	assert.Equal(t, [2]uint{2, 5}, [2]uint{prev, cur})
+	assert.Equal(t, uint(10), cms.TotalCount())
+	prev, cur = cms.IncrByReturningPrev([]byte("a"), math.MaxUint)
+	assert.Equal(t, [2]uint{5, math.MaxUint}, [2]uint{prev, cur})
+	assert.Equal(t, uint(math.MaxUint), cms.Query([]byte("a")))
+	assert.Equal(t, uint(5), cms.Query([]byte("b")))
+}
+
+func TestIncrByChecked(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	a, b := []byte("a"), []byte("b")
+	n, err := cms.IncrByChecked(a, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(10), n)
+
+	n, err = cms.IncrByChecked(a, math.MaxUint-20)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(math.MaxUint-10), n)
+
+	n, err = cms.IncrByChecked(a, 20)
+	assert.ErrorIs(t, err, ErrSaturated)
+	assert.Equal(t, uint(math.MaxUint), n)
+	assert.Equal(t, uint(math.MaxUint), cms.Query(a))
+
+	// Only the cells of the key matter.
+	_, err = cms.IncrByChecked(b, 1)
+	assert.NoError(t, err)
+}
+
+func TestErrorBounds(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	overEst, prob := cms.ErrorBounds()
+	assert.LessOrEqual(t, overEst, 0.01)
+	assert.LessOrEqual(t, prob, 0.01)
+	assert.Equal(t, uint(0), cms.MaxError())
+
+	cms, _ = NewByDim(200, 3)
+	overEst, prob = cms.ErrorBounds()
+	assert.Equal(t, 0.01, overEst)
+	assert.Equal(t, 0.125, prob)
+	cms.IncrBy([]byte("a"), 1000)
+	cms.IncrBy([]byte("b"), 50)
+	assert.Equal(t, uint(11), cms.MaxError())
+
+	estimate, maxOverEstimate := cms.QueryWithError([]byte("a"))
+	assert.Equal(t, cms.Query([]byte("a")), estimate)
+	assert.GreaterOrEqual(t, estimate, uint(1000))
+	assert.Equal(t, uint(11), maxOverEstimate)
+}
+
+func TestClone(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	k := []byte("key")
+	cms.IncrBy(k, 5)
+	clone := cms.Clone()
+	assert.Equal(t, cms, clone)
+
+	cms.IncrBy(k, 5)
+	assert.Equal(t, uint(10), cms.Query(k))
+	assert.Equal(t, uint(5), clone.Query(k))
+	assert.Equal(t, uint(5), clone.TotalCount())
+	clone.Reset()
+	assert.Equal(t, uint(10), cms.Query(k))
+}
+
+func TestScale(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	a, b := []byte("a"), []byte("b")
+	cms.IncrBy(a, 100)
+	cms.IncrBy(b, 7)
+	cms.Scale(0.5)
+	assert.Equal(t, uint(50), cms.Query(a))
+	assert.Equal(t, uint(3), cms.Query(b))
+	assert.Equal(t, uint(53), cms.TotalCount())
+
+	cms.IncrBy(b, 10)
+	assert.Equal(t, uint(13), cms.Query(b))
+
+	cms.Scale(1)
+	assert.Equal(t, uint(50), cms.Query(a))
+	cms.IncrBy(a, math.MaxUint)
+	cms.Scale(1)
+	assert.Equal(t, uint(math.MaxUint), cms.Query(a))
+	cms.Scale(0.5)
+	assert.Equal(t, uint(math.MaxUint/2+1), cms.Query(a))
+
+	cms.Scale(0)
+	fresh, _ := New(0.01, 0.01)
+	assert.Equal(t, fresh, cms)
+
+	assert.Panics(t, func() { cms.Scale(-0.5) })
+	assert.Panics(t, func() { cms.Scale(1.5) })
+	assert.Panics(t, func() { cms.Scale(math.NaN()) })
+}
+
+func TestIsHeavyHitter(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	for i := 0; i < 1000; i++ {
+		cms.IncrBy([]byte(strconv.Itoa(i)), 1)
+	}
+	cms.IncrBy([]byte("heavy"), 500)
+	assert.True(t, cms.IsHeavyHitter([]byte("heavy"), 0.3))
+	assert.False(t, cms.IsHeavyHitter([]byte("heavy"), 0.5))
+	assert.False(t, cms.IsHeavyHitter([]byte("1"), 0.3))
+}
+
+func TestNew(t *testing.T) {
+	for _, p := range []struct {
+		overEst, prob float64
+		width, depth  uint
+	}{
+		{0.01, 0.01, 200, 7},
+		{0.001, 0.01, 2000, 7},
+		{0.02, 0.001, 100, 10},
+		{0.5, 0.5, 4, 1},
+		{0.1, 0.25, 20, 2},
+		{0.3, 0.99, 7, 1},
+		{0.999, 1e-9, 3, 30},
+	} {
+		cms, err := New(p.overEst, p.prob)
+		assert.NoError(t, err)
+		assert.Equal(t, p.width, cms.Width(), "%v", p)
+		assert.Equal(t, p.depth, cms.Depth(), "%v", p)
+	}
+
+	for _, p := range [][2]float64{
+		{0, 0.01}, {1, 0.01}, {-0.1, 0.01}, {0.01, 0}, {0.01, 1}, {0.01, 1.5},
+		{math.NaN(), 0.01}, {0.01, math.NaN()}, {1e-300, 0.01},
+	} {
+		cms, err := New(p[0], p[1])
+		assert.Error(t, err, "%v", p)
+		assert.Nil(t, cms)
+	}
+}
+
+func BenchmarkIncrBy(b *testing.B) {
+	cms, _ := New(0.0001, 0.0001)
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cms.IncrBy(keys[i%len(keys)], 1)
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	cms, _ := New(0.0001, 0.0001)
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+		cms.IncrBy(keys[i], 1)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cms.Query(keys[i%len(keys)])
+	}
+}
+
+// queryManyHashFirst is QueryMany, but it hashes batches of 64 keys before
+// reading the cells of any of them.
+func queryManyHashFirst(cms *CMS, keys [][]byte, res []uint) {
+	var buf [64]itemHash
+	for start := 0; start < len(keys); start += len(buf) {
+		hs := buf[:min(len(buf), len(keys)-start)]
+		for i := range hs {
+			hs[i] = hashOf(cms.hasher, keys[start+i])
+		}
+		for i := range hs {
+			res[start+i] = cms.query(hs[i])
+		}
+	}
+}
+
+// incrByManyHashFirst is IncrByMany, but it hashes batches of 64 keys before
+// updating the cells of any of them.
+func incrByManyHashFirst(cms *CMS, keys [][]byte, vals []uint, res []uint) {
+	var buf [64]itemHash
+	for start := 0; start < len(keys); start += len(buf) {
+		hs := buf[:min(len(buf), len(keys)-start)]
+		for i := range hs {
+			hs[i] = hashOf(cms.hasher, keys[start+i])
+		}
+		for i := range hs {
+			res[start+i] = cms.incrBy(hs[i], vals[start+i])
+		}
+	}
+}
+
+// BenchmarkQueryMany compares QueryMany and IncrByMany, which hash each key
+// right before its cells are used, with hashing batches of keys before using
+// the cells of any of them. The latter was measured 3-6% slower on amd64.
+func BenchmarkQueryMany(b *testing.B) {
+	cms, _ := New(0.0001, 0.0001)
+	keys := make([][]byte, 1024)
+	vals := make([]uint, len(keys))
+	res := make([]uint, len(keys))
+	for i := range keys {
+		keys[i] = []byte("key:" + strconv.Itoa(i))
+		vals[i] = 1
+		cms.IncrBy(keys[i], 1)
+	}
+	b.Run("QueryInterleaved", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cms.QueryMany(keys)
+		}
+	})
+	b.Run("QueryHashFirst", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			queryManyHashFirst(cms, keys, res)
+		}
+	})
+	b.Run("IncrByInterleaved", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cms.IncrByMany(keys, vals)
+		}
+	})
+	b.Run("IncrByHashFirst", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			incrByManyHashFirst(cms, keys, vals, res)
+		}
+	})
+}
+
+func TestSubtract(t *testing.T) {
+	inserts, _ := New(0.001, 0.01)
+	deletes, _ := New(0.001, 0.01)
+	for i := 0; i < 100; i++ {
+		inserts.IncrBy([]byte(strconv.Itoa(i)), 10)
+	}
+	for i := 0; i < 50; i++ {
+		deletes.IncrBy([]byte(strconv.Itoa(i)), 4)
+	}
+	deletes.IncrBy([]byte("missing"), 5)
+
+	assert.NoError(t, inserts.Subtract(deletes))
+	assert.Equal(t, uint(1000-200-5), inserts.TotalCount())
+	for i := 0; i < 100; i++ {
+		want := uint(10)
+		if i < 50 {
+			want = 6
+		}
+		assert.LessOrEqual(t, inserts.Query([]byte(strconv.Itoa(i))), want)
+	}
+	assert.Equal(t, uint(0), inserts.Query([]byte("missing")))
+
+	// Counters are clamped at 0.
+	assert.NoError(t, deletes.Subtract(inserts))
+	assert.NoError(t, deletes.Subtract(deletes))
+	fresh, _ := New(0.001, 0.01)
+	assert.Equal(t, fresh, deletes)
+
+	other, _ := New(0.01, 0.01)
+	assert.Error(t, inserts.Subtract(other))
+	other, _ = New(0.001, 0.01)
+	other.IncrByConservative([]byte("a"), 1)
+	assert.Error(t, inserts.Subtract(other))
+	other, _ = NewWithHasher(0.001, 0.01, XXHasher{})
+	other.IncrBy([]byte("a"), 1)
+	assert.Error(t, inserts.Subtract(other))
+	assert.Equal(t, uint(795), inserts.TotalCount())
+}
+
+func TestMergeWeighted(t *testing.T) {
+	a, _ := New(0.001, 0.01)
+	b, _ := New(0.001, 0.01)
+	k := []byte("key")
+	a.IncrBy(k, 10)
+	b.IncrBy(k, 3)
+
+	cms, _ := New(0.001, 0.01)
+	cms.IncrBy(k, 1)
+	assert.NoError(t, cms.MergeWeighted([]*CMS{a, b}, []float64{2, 0.5}))
+	assert.Equal(t, uint(1+20+2), cms.Query(k))
+	assert.Equal(t, uint(23), cms.TotalCount())
+
+	// Weights of 1 are Merge.
+	merged, _ := New(0.001, 0.01)
+	assert.NoError(t, merged.Merge(a, b))
+	weighted, _ := New(0.001, 0.01)
+	assert.NoError(t, weighted.MergeWeighted([]*CMS{a, b}, []float64{1, 1}))
+	assert.Equal(t, merged, weighted)
+
+	b.IncrBy(k, math.MaxUint/2)
+	assert.NoError(t, cms.MergeWeighted([]*CMS{b}, []float64{4}))
+	assert.Equal(t, uint(math.MaxUint), cms.Query(k))
+
+	other, _ := New(0.01, 0.01)
+	xx, _ := NewWithHasher(0.001, 0.01, XXHasher{})
+	for _, c := range []struct {
+		sketches []*CMS
+		weights  []float64
+	}{
+		{[]*CMS{a}, []float64{1, 2}},
+		{[]*CMS{a}, []float64{-1}},
+		{[]*CMS{a}, []float64{math.Inf(1)}},
+		{[]*CMS{a}, []float64{math.NaN()}},
+		{[]*CMS{a, other}, []float64{1, 1}},
+		{[]*CMS{a, xx}, []float64{1, 1}},
+	} {
+		assert.Error(t, weighted.MergeWeighted(c.sketches, c.weights))
+	}
+	assert.Equal(t, merged, weighted)
+}
+
+func TestNewWithHasher(t *testing.T) {
+	cms, err := NewWithHasher(0.01, 0.01, XXHasher{})
+	assert.NoError(t, err)
+	def, _ := New(0.01, 0.01)
+	for i := 0; i < 100; i++ {
+		k := []byte(strconv.Itoa(i))
+		cms.IncrBy(k, uint(i))
+		def.IncrBy(k, uint(i))
+	}
+	assert.NotEqual(t, def.cells, cms.cells)
+	for i := 0; i < 100; i++ {
+		assert.GreaterOrEqual(t, cms.Query([]byte(strconv.Itoa(i))), uint(i))
+	}
+
+	// The hasher is kept by Clone, NewConcurrent and decoding.
+	assert.Equal(t, cms, cms.Clone())
+	assert.Equal(t, cms, NewConcurrent(cms).Snapshot())
+	data, _ := cms.MarshalBinary()
+	loaded := &CMS{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, cms, loaded)
+
+	_, err = NewWithHasher(0, 0.01, XXHasher{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,265 @@
+package countminsketch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/fukua95/pds/internal/hashing"
+)
+
+// The binary format starts with binaryMagic and binaryVersion, followed by a
+// flags byte and the id of the hasher (see hashing.IDMurmur), then width,
+// depth and counter as little-endian uint64, then the cells row after row as
+// little-endian uint64 as well, so that dumps don't depend on the size of
+// uint. It ends with the CRC32 of everything before.
+//
+// Version 2 maps items to cells by double hashing, and version 3 adds the
+// hasher id; version 2 sketches, which have none, were all hashed with
+// MurmurHash64A and are still read. Version 1 sketches, hashed with a seed per
+// row, can't be read anymore: their counts would be attributed to the wrong
+// items.
+const (
+	binaryMagic      = "CMSK"
+	binaryVersion    = 3
+	binaryVersionV2  = 2
+	binaryHeaderSize = len(binaryMagic) + 3 + 3*8
+	checksumSize     = 4
+
+	flagConservative = 1 << 0
+)
+
+// ErrCorruptData is returned when decoding data whose checksum doesn't match.
+var ErrCorruptData = errors.New("corrupt data")
+
+// hasherID returns the id of the hasher of cms in serialized sketches.
+func (cms *CMS) hasherID() uint8 {
+	switch cms.hasher.(type) {
+	case nil, MurmurHasher:
+		return hashing.IDMurmur
+	case XXHasher:
+		return hashing.IDXXHash
+	case RedisBloomHasher:
+		return hashing.IDMurmur2
+	}
+	return hashing.IDCustom
+}
+
+// setHasherID sets the hasher of cms to the one identified by id. A custom
+// hasher can't be restored: cms must already have one, which is kept.
+func (cms *CMS) setHasherID(id uint8) error {
+	switch id {
+	case hashing.IDMurmur:
+		cms.hasher = nil
+	case hashing.IDXXHash:
+		cms.hasher = XXHasher{}
+	case hashing.IDMurmur2:
+		cms.hasher = RedisBloomHasher{}
+	case hashing.IDCustom:
+		if cms.hasherID() != hashing.IDCustom {
+			return errors.New("sketch uses a custom hasher")
+		}
+	default:
+		return errors.New("unknown hasher")
+	}
+	return nil
+}
+
+// sameHasher reports whether cms and other hash items the same way. Custom
+// hashers must be equal values of a comparable type.
+func (cms *CMS) sameHasher(other *CMS) bool {
+	id := cms.hasherID()
+	if id != other.hasherID() {
+		return false
+	}
+	if id != hashing.IDCustom {
+		return true
+	}
+	t := reflect.TypeOf(cms.hasher)
+	return t == reflect.TypeOf(other.hasher) && t.Comparable() && cms.hasher == other.hasher
+}
+
+func (cms *CMS) binarySize() int {
+	return binaryHeaderSize + int(cms.width*cms.depth)*8 + checksumSize
+}
+
+// WriteTo implements io.WriterTo.
+// It writes the same format as MarshalBinary, one row after another, without
+// materializing the whole encoding in memory.
+func (cms *CMS) WriteTo(w io.Writer) (int64, error) {
+	total := int64(0)
+	crc := crc32.NewIEEE()
+	write := func(p []byte) error {
+		crc.Write(p)
+		n, err := w.Write(p)
+		total += int64(n)
+		return err
+	}
+
+	head := make([]byte, 0, binaryHeaderSize)
+	head = append(head, binaryMagic...)
+	flags := byte(0)
+	if cms.conservative {
+		flags |= flagConservative
+	}
+	head = append(head, binaryVersion, flags, cms.hasherID())
+	head = binary.LittleEndian.AppendUint64(head, uint64(cms.width))
+	head = binary.LittleEndian.AppendUint64(head, uint64(cms.depth))
+	head = binary.LittleEndian.AppendUint64(head, uint64(cms.counter))
+	if err := write(head); err != nil {
+		return total, err
+	}
+
+	row := make([]byte, 0, cms.width*8)
+	for i := range cms.cells {
+		row = row[:0]
+		for _, v := range cms.cells[i] {
+			row = binary.LittleEndian.AppendUint64(row, uint64(v))
+		}
+		if err := write(row); err != nil {
+			return total, err
+		}
+	}
+
+	n, err := w.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32()))
+	total += int64(n)
+	return total, err
+}
+
+// ReadFrom implements io.ReaderFrom.
+// It reads a sketch written by WriteTo or MarshalBinary, consuming exactly the
+// bytes of the encoding, and replaces cms with it. The dimensions are checked
+// before the cells are allocated, and the cells are allocated as they are read.
+// ErrCorruptData is returned if the data doesn't match its checksum.
+// Counters that don't fit in a uint, on 32-bit platforms, are an error.
+func (cms *CMS) ReadFrom(r io.Reader) (int64, error) {
+	total := int64(0)
+	crc := crc32.NewIEEE()
+	read := func(p []byte) error {
+		n, err := io.ReadFull(r, p)
+		crc.Write(p[:n])
+		total += int64(n)
+		return err
+	}
+
+	head := make([]byte, binaryHeaderSize)
+	if err := read(head[:len(binaryMagic)+2]); err != nil {
+		return total, err
+	}
+	if string(head[:len(binaryMagic)]) != binaryMagic {
+		return total, errors.New("not a count-min sketch")
+	}
+	flags := head[len(binaryMagic)+1]
+	hasherID := hashing.IDMurmur
+	switch head[len(binaryMagic)] {
+	case binaryVersion:
+		if err := read(head[len(binaryMagic)+2:]); err != nil {
+			return total, err
+		}
+		hasherID = head[len(binaryMagic)+2]
+	case binaryVersionV2:
+		// The header has no hasher id: read the fields after it.
+		if err := read(head[len(binaryMagic)+3:]); err != nil {
+			return total, err
+		}
+	default:
+		return total, errors.New("unsupported version")
+	}
+	width := binary.LittleEndian.Uint64(head[len(binaryMagic)+3:])
+	depth := binary.LittleEndian.Uint64(head[len(binaryMagic)+11:])
+	counter := binary.LittleEndian.Uint64(head[len(binaryMagic)+19:])
+	if counter > math.MaxUint {
+		return total, fmt.Errorf("%w: value out of range", ErrCorruptData)
+	}
+	// The size of a row in bytes must fit in an int.
+	if width == 0 || depth == 0 || width > math.MaxInt/8 || width > math.MaxUint/depth {
+		return total, fmt.Errorf("%w: invalid dimensions", ErrCorruptData)
+	}
+
+	// Rows are allocated as they are read, so that a bogus header can't
+	// allocate more memory than the reader actually holds.
+	res := &CMS{
+		width:        uint(width),
+		depth:        uint(depth),
+		counter:      uint(counter),
+		cells:        make([][]uint, 0, min(depth, 64)),
+		conservative: flags&flagConservative != 0,
+		hasher:       cms.hasher,
+	}
+	if err := res.setHasherID(hasherID); err != nil {
+		return total, err
+	}
+	row := make([]byte, 0, min(width*8, 1<<16))
+	for range depth {
+		cells := make([]uint, 0, min(width, 1<<13))
+		for uint64(len(cells)) < width {
+			n := min(width-uint64(len(cells)), uint64(cap(row))/8)
+			row = row[:n*8]
+			if err := read(row); err != nil {
+				return total, err
+			}
+			for j := range n {
+				v := binary.LittleEndian.Uint64(row[j*8:])
+				if v > math.MaxUint {
+					return total, errors.New("value out of range")
+				}
+				cells = append(cells, uint(v))
+			}
+		}
+		res.cells = append(res.cells, cells)
+	}
+
+	sum := crc.Sum32()
+	tail := make([]byte, checksumSize)
+	if err := read(tail); err != nil {
+		return total, err
+	}
+	if binary.LittleEndian.Uint32(tail) != sum {
+		return total, ErrCorruptData
+	}
+
+	*cms = *res
+	return total, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (cms *CMS) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, cms.binarySize()))
+	if _, err := cms.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The checksum is verified before anything is decoded, and ErrCorruptData is
+// returned if it doesn't match.
+func (cms *CMS) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize-1+checksumSize {
+		return errors.New("data too short")
+	}
+	if string(data[:len(binaryMagic)]) != binaryMagic {
+		return errors.New("not a count-min sketch")
+	}
+	payload, tail := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(tail) {
+		return ErrCorruptData
+	}
+
+	res := CMS{hasher: cms.hasher}
+	r := bytes.NewReader(data)
+	if _, err := res.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return errors.New("trailing data")
+	}
+
+	*cms = res
+	return nil
+}
@@ -0,0 +1,139 @@
+package countminsketch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	cms, _ := New(0.01, 0.01)
+	for i := 0; i < 1000; i++ {
+		cms.IncrBy([]byte(strconv.Itoa(i)), uint(i))
+	}
+
+	data, err := cms.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, binaryHeaderSize+int(cms.width*cms.depth)*8+checksumSize, len(data))
+	assert.Equal(t, uint64(cms.width), binary.LittleEndian.Uint64(data[7:]))
+
+	loaded := &CMS{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, cms, loaded)
+	for i := 0; i < 1000; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.Equal(t, cms.Query(k), loaded.Query(k))
+	}
+
+	// The conservative flag is kept, so the sketch still can't be merged.
+	cms.IncrByConservative([]byte("key"), 1)
+	data, _ = cms.MarshalBinary()
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Error(t, loaded.Merge(cms))
+
+	assert.Error(t, loaded.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, loaded.UnmarshalBinary(append(bytes.Clone(data), 0)))
+	assert.Error(t, loaded.UnmarshalBinary(data[:10]))
+	v1 := bytes.Clone(data)
+	v1[len(binaryMagic)] = 1
+	binary.LittleEndian.PutUint32(v1[len(v1)-checksumSize:], crc32.ChecksumIEEE(v1[:len(v1)-checksumSize]))
+	assert.EqualError(t, loaded.UnmarshalBinary(v1), "unsupported version")
+	corrupted := bytes.Clone(data)
+	corrupted[binaryHeaderSize+3] ^= 1
+	assert.ErrorIs(t, loaded.UnmarshalBinary(corrupted), ErrCorruptData)
+	assert.Equal(t, cms, loaded)
+}
+
+func TestWriteTo(t *testing.T) {
+	cms, _ := NewByDim(100, 4)
+	for i := 0; i < 1000; i++ {
+		cms.IncrBy([]byte(strconv.Itoa(i)), uint(i))
+	}
+
+	var buf bytes.Buffer
+	n, err := cms.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	data, _ := cms.MarshalBinary()
+	assert.Equal(t, data, buf.Bytes())
+
+	// ReadFrom consumes only the encoding.
+	buf.WriteString("next")
+	loaded := &CMS{}
+	n, err = loaded.ReadFrom(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, cms, loaded)
+	assert.Equal(t, "next", buf.String())
+
+	_, err = loaded.ReadFrom(bytes.NewReader(data[:len(data)-1]))
+	assert.Error(t, err)
+	corrupted := bytes.Clone(data)
+	corrupted[binaryHeaderSize+3] ^= 1
+	_, err = loaded.ReadFrom(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ErrCorruptData)
+	assert.Equal(t, cms, loaded)
+
+	// Huge dimensions are rejected before anything is allocated.
+	huge := bytes.Clone(data[:binaryHeaderSize])
+	binary.LittleEndian.PutUint64(huge[6:], 1<<62)
+	binary.LittleEndian.PutUint64(huge[14:], 1<<62)
+	_, err = loaded.ReadFrom(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, ErrCorruptData)
+
+	// So are dimensions that don't match the data.
+	binary.LittleEndian.PutUint64(huge[6:], 1<<20)
+	binary.LittleEndian.PutUint64(huge[14:], 1<<30)
+	_, err = loaded.ReadFrom(bytes.NewReader(huge))
+	assert.Error(t, err)
+	assert.Equal(t, cms, loaded)
+}
+
+func TestMarshalBinaryHasher(t *testing.T) {
+	cms, _ := NewWithHasher(0.01, 0.01, XXHasher{})
+	for i := 0; i < 1000; i++ {
+		cms.IncrBy([]byte(strconv.Itoa(i)), uint(i))
+	}
+
+	// The hasher is restored from its id.
+	data, _ := cms.MarshalBinary()
+	loaded := &CMS{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, cms, loaded)
+	cms.hasher = MurmurHasher{}
+	data, _ = cms.MarshalBinary()
+	loaded, _ = NewWithHasher(0.5, 0.5, XXHasher{})
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Nil(t, loaded.hasher)
+
+	// Version 2 sketches have no hasher id and were hashed with MurmurHash64A.
+	v2 := append([]byte(binaryMagic), binaryVersionV2, 0)
+	v2 = append(v2, data[len(binaryMagic)+3:len(data)-checksumSize]...)
+	v2 = binary.LittleEndian.AppendUint32(v2, crc32.ChecksumIEEE(v2))
+	assert.NoError(t, loaded.UnmarshalBinary(v2))
+	assert.Equal(t, cms.cells, loaded.cells)
+	assert.Nil(t, loaded.hasher)
+
+	// A custom hasher must be given by the receiver.
+	cms.hasher = seededFNV{}
+	data, _ = cms.MarshalBinary()
+	assert.Equal(t, byte(0xff), data[len(binaryMagic)+2])
+	assert.Error(t, loaded.UnmarshalBinary(data))
+	loaded.hasher = seededFNV{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, cms, loaded)
+}
+
+type seededFNV struct{}
+
+func (seededFNV) Sum64(data []byte, seed uint64) uint64 {
+	f := fnv.New64a()
+	f.Write(binary.LittleEndian.AppendUint64(nil, seed))
+	f.Write(data)
+	return f.Sum64()
+}
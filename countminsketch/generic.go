@@ -0,0 +1,150 @@
+package countminsketch
+
+import (
+	"errors"
+	"math"
+)
+
+// Unsigned is the type of the counters of a CMSOf.
+type Unsigned interface {
+	~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// CMSOf is a count-min sketch with counters of type T: narrow counters take
+// less memory, wide ones saturate later. Counters saturate at the largest
+// value of T. The cells are stored in a single slice, row after row.
+//
+// It maps items to cells as CMS does, so both give the same estimates as long
+// as no counter saturates.
+type CMSOf[T Unsigned] struct {
+	width   uint
+	depth   uint
+	counter uint
+	cells   []T
+}
+
+// NewOf is like New, for a sketch with counters of type T.
+func NewOf[T Unsigned](overEst float64, prob float64) (*CMSOf[T], error) {
+	width, depth, err := dimFromProb(overEst, prob)
+	if err != nil {
+		return nil, err
+	}
+	return NewByDimOf[T](width, depth)
+}
+
+// NewByDimOf is like NewByDim, for a sketch with counters of type T.
+func NewByDimOf[T Unsigned](width uint, depth uint) (*CMSOf[T], error) {
+	if width <= 0 || depth <= 0 {
+		return nil, errors.New("invalid Parameter")
+	}
+	if width > math.MaxInt/depth {
+		return nil, errors.New("parameter are too large")
+	}
+
+	return &CMSOf[T]{
+		width: width,
+		depth: depth,
+		cells: make([]T, width*depth),
+	}, nil
+}
+
+// TotalCount returns the sum of the increments of the sketch, less its
+// decrements.
+func (cms *CMSOf[T]) TotalCount() uint {
+	return cms.counter
+}
+
+// Width returns the number of counters per row.
+func (cms *CMSOf[T]) Width() uint {
+	return cms.width
+}
+
+// Depth returns the number of rows.
+func (cms *CMSOf[T]) Depth() uint {
+	return cms.depth
+}
+
+// cell returns the position in cells of the cell in row i of the item hashed
+// to h, with the same double hashing as CMS.
+func (cms *CMSOf[T]) cell(h itemHash, i uint) uint {
+	return i*cms.width + h.index(int(i), cms.width)
+}
+
+// IncrBy increments the count of data by val and returns its new estimate.
+// Cells are clamped at the largest value of T.
+func (cms *CMSOf[T]) IncrBy(data []byte, val T) T {
+	minCount := ^T(0)
+	h := hashOf(nil, data)
+	for i := range cms.depth {
+		c := cms.cell(h, i)
+		cms.cells[c] = addSatOf(cms.cells[c], val)
+		minCount = min(minCount, cms.cells[c])
+	}
+	cms.counter = addSat(cms.counter, toUint(val))
+	return minCount
+}
+
+// DecrBy decrements the count of data by val and returns its new estimate.
+// Cells are clamped at 0, and so is the total count. As with CMS.DecrBy, Query
+// is then a lower bound on the count rather than an upper bound.
+func (cms *CMSOf[T]) DecrBy(data []byte, val T) T {
+	minCount := ^T(0)
+	h := hashOf(nil, data)
+	for i := range cms.depth {
+		c := cms.cell(h, i)
+		cms.cells[c] -= min(cms.cells[c], val)
+		minCount = min(minCount, cms.cells[c])
+	}
+	cms.counter -= min(cms.counter, toUint(val))
+	return minCount
+}
+
+// Return an estimate counter for item.
+func (cms *CMSOf[T]) Query(data []byte) T {
+	minCount := ^T(0)
+	h := hashOf(nil, data)
+	for i := range cms.depth {
+		minCount = min(minCount, cms.cells[cms.cell(h, i)])
+	}
+	return minCount
+}
+
+// Merge adds the counters of others into cms.
+// All sketches must have the same width and depth; otherwise an error is
+// returned and cms is left unchanged. Cells are clamped at the largest value
+// of T.
+func (cms *CMSOf[T]) Merge(others ...*CMSOf[T]) error {
+	for _, o := range others {
+		if o.width != cms.width || o.depth != cms.depth {
+			return errors.New("sketches have different dimensions")
+		}
+	}
+
+	for _, o := range others {
+		for i, v := range o.cells {
+			cms.cells[i] = addSatOf(cms.cells[i], v)
+		}
+		cms.counter = addSat(cms.counter, o.counter)
+	}
+	return nil
+}
+
+// Reset sets all counters to 0, keeping the dimensions and the memory of the
+// sketch.
+func (cms *CMSOf[T]) Reset() {
+	clear(cms.cells)
+	cms.counter = 0
+}
+
+// addSatOf returns a + b, or the largest value of T if it overflows.
+func addSatOf[T Unsigned](a, b T) T {
+	if a+b < a {
+		return ^T(0)
+	}
+	return a + b
+}
+
+// toUint returns v as a uint, clamped at math.MaxUint on 32-bit platforms.
+func toUint[T Unsigned](v T) uint {
+	return uint(min(uint64(v), math.MaxUint))
+}
@@ -0,0 +1,55 @@
+package countminsketch
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCMSOf(t *testing.T) {
+	small, err := NewByDimOf[uint8](1000, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000*5, len(small.cells)*int(unsafe.Sizeof(small.cells[0])))
+	_, err = NewByDimOf[uint16](0, 5)
+	assert.Error(t, err)
+	_, err = NewOf[uint16](0, 0.01)
+	assert.Error(t, err)
+
+	// The same estimates as CMS, as long as no counter saturates.
+	wide, _ := NewOf[uint64](0.01, 0.01)
+	mid, _ := NewOf[uint16](0.01, 0.01)
+	ref, _ := New(0.01, 0.01)
+	for i := 0; i < 1000; i++ {
+		k := []byte(strconv.Itoa(i))
+		n := ref.IncrBy(k, uint(i))
+		assert.Equal(t, uint64(n), wide.IncrBy(k, uint64(i)))
+		assert.Equal(t, uint16(n), mid.IncrBy(k, uint16(i)))
+	}
+	assert.Equal(t, ref.TotalCount(), wide.TotalCount())
+	assert.Equal(t, ref.TotalCount(), mid.TotalCount())
+	for i := 0; i < 1000; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.Equal(t, uint64(ref.Query(k)), wide.Query(k))
+	}
+
+	// Counters saturate at the largest value of the type.
+	k := []byte("key")
+	assert.Equal(t, uint8(200), small.IncrBy(k, 200))
+	assert.Equal(t, uint8(math.MaxUint8), small.IncrBy(k, 100))
+	assert.Equal(t, uint8(math.MaxUint8-5), small.DecrBy(k, 5))
+	assert.Equal(t, uint(295), small.TotalCount())
+	assert.Equal(t, uint64(math.MaxUint64), wide.IncrBy(k, math.MaxUint64))
+
+	other, _ := NewByDimOf[uint8](1000, 5)
+	other.IncrBy(k, 10)
+	assert.NoError(t, small.Merge(other))
+	assert.Equal(t, uint8(math.MaxUint8), small.Query(k))
+	assert.Error(t, small.Merge(&CMSOf[uint8]{width: 10, depth: 5}))
+
+	small.Reset()
+	assert.Equal(t, uint8(0), small.Query(k))
+	assert.Equal(t, uint(0), small.TotalCount())
+}
@@ -0,0 +1,113 @@
+package countminsketch
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// HeavyHitter is a key and the estimate of its count.
+type HeavyHitter struct {
+	Key   string
+	Count uint
+}
+
+// HeavyHitters tracks the keys with the largest estimates of a sketch: a
+// min-heap of candidates is updated on every IncrBy, and a key replaces the
+// smallest candidate once its estimate exceeds it.
+//
+// Like the sketch, it may report false heavy hitters, whose estimates are
+// inflated by collisions, but won't miss a key whose count is above that of
+// the smallest candidate when it's incremented.
+type HeavyHitters struct {
+	cms  *CMS
+	heap hitterHeap
+}
+
+// NewHeavyHitters returns a tracker of the size keys with the largest estimates
+// of cms. cms should only be updated through the tracker.
+func NewHeavyHitters(cms *CMS, size int) *HeavyHitters {
+	return &HeavyHitters{
+		cms: cms,
+		heap: hitterHeap{
+			size:  max(size, 0),
+			index: make(map[string]int),
+		},
+	}
+}
+
+// Sketch returns the underlying sketch.
+func (h *HeavyHitters) Sketch() *CMS {
+	return h.cms
+}
+
+// IncrBy increments the count of data by val, updates the candidates and
+// returns the new estimate of data.
+func (h *HeavyHitters) IncrBy(data []byte, val uint) uint {
+	count := h.cms.IncrBy(data, val)
+	h.heap.update(data, count)
+	return count
+}
+
+// Query returns an estimate of the count of data.
+func (h *HeavyHitters) Query(data []byte) uint {
+	return h.cms.Query(data)
+}
+
+// TopK returns at most k candidates, by decreasing estimate.
+func (h *HeavyHitters) TopK(k int) []HeavyHitter {
+	res := slices.Clone(h.heap.items)
+	slices.SortFunc(res, func(a, b HeavyHitter) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	})
+	return res[:min(max(k, 0), len(res))]
+}
+
+// hitterHeap is a min-heap of at most size candidates, by estimate. index maps
+// each key to its position in items.
+type hitterHeap struct {
+	items []HeavyHitter
+	index map[string]int
+	size  int
+}
+
+func (h *hitterHeap) update(data []byte, count uint) {
+	if i, ok := h.index[string(data)]; ok {
+		h.items[i].Count = count
+		heap.Fix(h, i)
+		return
+	}
+	if len(h.items) < h.size {
+		heap.Push(h, HeavyHitter{Key: string(data), Count: count})
+		return
+	}
+	if len(h.items) > 0 && count > h.items[0].Count {
+		delete(h.index, h.items[0].Key)
+		h.items[0] = HeavyHitter{Key: string(data), Count: count}
+		h.index[h.items[0].Key] = 0
+		heap.Fix(h, 0)
+	}
+}
+
+func (h *hitterHeap) Len() int { return len(h.items) }
+
+func (h *hitterHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h *hitterHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Key] = i
+	h.index[h.items[j].Key] = j
+}
+
+func (h *hitterHeap) Push(x any) {
+	item := x.(HeavyHitter)
+	h.index[item.Key] = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *hitterHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, item.Key)
+	return item
+}
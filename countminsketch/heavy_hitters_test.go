@@ -0,0 +1,36 @@
+package countminsketch
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeavyHitters(t *testing.T) {
+	cms, _ := New(0.001, 0.01)
+	h := NewHeavyHitters(cms, 3)
+	assert.Same(t, cms, h.Sketch())
+	assert.Empty(t, h.TopK(3))
+
+	for i := 0; i < 1000; i++ {
+		h.IncrBy([]byte(strconv.Itoa(i%100)), 1)
+	}
+	for i, n := range []uint{50, 40, 30, 20} {
+		h.IncrBy([]byte("heavy"+strconv.Itoa(i)), n)
+	}
+	assert.Equal(t, uint(50), h.Query([]byte("heavy0")))
+
+	top := h.TopK(3)
+	assert.Equal(t, []HeavyHitter{{"heavy0", 50}, {"heavy1", 40}, {"heavy2", 30}}, top)
+	assert.Len(t, h.TopK(10), 3)
+	assert.Len(t, h.TopK(1), 1)
+	assert.Empty(t, h.TopK(-1))
+
+	// A candidate is updated in place.
+	h.IncrBy([]byte("heavy2"), 25)
+	assert.Equal(t, HeavyHitter{"heavy2", 55}, h.TopK(1)[0])
+	assert.Len(t, h.heap.index, 3)
+
+	assert.Empty(t, NewHeavyHitters(cms, 0).TopK(1))
+}
@@ -0,0 +1,75 @@
+package countminsketch
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// CellInfo is a cell of a sketch and its value.
+type CellInfo struct {
+	Row   uint
+	Col   uint
+	Value uint
+}
+
+// HotCells returns the n cells with the largest values, by decreasing value,
+// then by row and column. Cells that are 0 are left out.
+//
+// It's a diagnostic: cells can't be mapped back to items, but a few cells far
+// above the others show that the sketch is dominated by heavy items, and many
+// large cells that it's too narrow for the stream. It walks all cells, keeping
+// the n largest in a heap.
+func (cms *CMS) HotCells(n int) []CellInfo {
+	if n <= 0 {
+		return []CellInfo{}
+	}
+	h := cellHeap{}
+	for i, row := range cms.cells {
+		for j, v := range row {
+			c := CellInfo{Row: uint(i), Col: uint(j), Value: v}
+			if v == 0 || (len(h) == n && !hotter(c, h[0])) {
+				continue
+			}
+			if len(h) < n {
+				heap.Push(&h, c)
+			} else {
+				h[0] = c
+				heap.Fix(&h, 0)
+			}
+		}
+	}
+	res := []CellInfo(h)
+	slices.SortFunc(res, func(a, b CellInfo) int {
+		return cmp.Or(cmp.Compare(b.Value, a.Value), cmp.Compare(a.Row, b.Row), cmp.Compare(a.Col, b.Col))
+	})
+	return res
+}
+
+// hotter reports whether a comes before b in the result of HotCells.
+func hotter(a, b CellInfo) bool {
+	if a.Value != b.Value {
+		return a.Value > b.Value
+	}
+	if a.Row != b.Row {
+		return a.Row < b.Row
+	}
+	return a.Col < b.Col
+}
+
+// cellHeap is a min-heap of cells, the coldest first.
+type cellHeap []CellInfo
+
+func (h cellHeap) Len() int { return len(h) }
+
+func (h cellHeap) Less(i, j int) bool { return hotter(h[j], h[i]) }
+
+func (h cellHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *cellHeap) Push(x any) { *h = append(*h, x.(CellInfo)) }
+
+func (h *cellHeap) Pop() any {
+	c := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return c
+}
@@ -0,0 +1,45 @@
+package countminsketch
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotCells(t *testing.T) {
+	cms, _ := NewByDim(4, 3)
+	assert.Empty(t, cms.HotCells(3))
+
+	cms.cells = [][]uint{
+		{0, 5, 1, 0},
+		{9, 0, 5, 2},
+		{0, 0, 0, 7},
+	}
+	assert.Equal(t, []CellInfo{{1, 0, 9}, {2, 3, 7}, {0, 1, 5}, {1, 2, 5}}, cms.HotCells(4))
+	assert.Equal(t, []CellInfo{{1, 0, 9}, {2, 3, 7}, {0, 1, 5}}, cms.HotCells(3))
+	assert.Len(t, cms.HotCells(100), 6)
+	assert.Empty(t, cms.HotCells(0))
+	assert.Empty(t, cms.HotCells(-1))
+
+	// The same cells as sorting them all.
+	cms, _ = NewByDim(100, 5)
+	rng := rand.New(rand.NewSource(1))
+	var all []CellInfo
+	for i := range cms.cells {
+		for j := range cms.cells[i] {
+			cms.cells[i][j] = uint(rng.Intn(50))
+			if cms.cells[i][j] != 0 {
+				all = append(all, CellInfo{uint(i), uint(j), cms.cells[i][j]})
+			}
+		}
+	}
+	slices.SortFunc(all, func(a, b CellInfo) int {
+		if hotter(a, b) {
+			return -1
+		}
+		return 1
+	})
+	assert.Equal(t, all[:20], cms.HotCells(20))
+}
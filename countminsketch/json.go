@@ -0,0 +1,42 @@
+package countminsketch
+
+import "encoding/json"
+
+// jsonMaxCells is the number of cells up to which MarshalJSON dumps the grid.
+const jsonMaxCells = 4096
+
+type jsonStats struct {
+	Min     uint `json:"min"`
+	Max     uint `json:"max"`
+	NonZero uint `json:"nonZero"`
+}
+
+type jsonCMS struct {
+	Width        uint       `json:"width"`
+	Depth        uint       `json:"depth"`
+	Counter      uint       `json:"counter"`
+	Conservative bool       `json:"conservative"`
+	Cells        [][]uint   `json:"cells,omitempty"`
+	Stats        *jsonStats `json:"stats,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, for people inspecting a sketch, e.g.
+// on a debug endpoint: the grid of cells is only included up to 4096 cells,
+// larger sketches get the min and max cells and the number of non-zero cells
+// instead. It can't be decoded back into a sketch.
+func (cms *CMS) MarshalJSON() ([]byte, error) {
+	v := jsonCMS{
+		Width:        cms.width,
+		Depth:        cms.depth,
+		Counter:      cms.counter,
+		Conservative: cms.conservative,
+	}
+	if cms.width*cms.depth <= jsonMaxCells {
+		v.Cells = cms.cells
+	} else {
+		var s jsonStats
+		s.Min, s.Max, s.NonZero = cms.CellStats()
+		v.Stats = &s
+	}
+	return json.Marshal(v)
+}
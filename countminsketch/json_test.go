@@ -0,0 +1,39 @@
+package countminsketch
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	cms, _ := NewByDim(3, 2)
+	cms.cells = [][]uint{{0, 4, 1}, {5, 0, 0}}
+	cms.counter = 5
+	data, err := json.Marshal(cms)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"width":3,"depth":2,"counter":5,"conservative":false,"cells":[[0,4,1],[5,0,0]]}`, string(data))
+
+	cms, _ = NewByDim(2049, 2)
+	for i := 0; i < 100; i++ {
+		cms.IncrByConservative([]byte(strconv.Itoa(i)), 1)
+	}
+	cms.IncrByConservative([]byte("hot"), 50)
+	data, err = json.Marshal(cms)
+	assert.NoError(t, err)
+	var v struct {
+		Counter      uint
+		Conservative bool
+		Cells        [][]uint
+		Stats        jsonStats
+	}
+	assert.NoError(t, json.Unmarshal(data, &v))
+	assert.Equal(t, uint(150), v.Counter)
+	assert.True(t, v.Conservative)
+	assert.Nil(t, v.Cells)
+	assert.Equal(t, uint(0), v.Stats.Min)
+	assert.Equal(t, uint(50), v.Stats.Max)
+	assert.InDelta(t, 202, v.Stats.NonZero, 10)
+}
@@ -0,0 +1,56 @@
+package countminsketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// redisBloomHeaderSize is the size of the width, depth and counter fields of a
+// RedisBloom sketch.
+const redisBloomHeaderSize = 3 * 8
+
+// LoadRedisBloomCMS returns a sketch with the dimensions, the total count and
+// the cells of a sketch exported from RedisBloom's CMS.* commands.
+//
+// data holds the fields that RedisBloom saves, without the RDB encoding around
+// them: width, depth and counter as little-endian uint64, then the width*depth
+// cells, row after row, as little-endian uint32, as RedisBloom keeps them.
+//
+// The sketch hashes items with RedisBloomHasher, as RedisBloom does, so Query
+// returns the estimates of CMS.QUERY, and IncrBy counts items in the cells
+// CMS.INCRBY would.
+func LoadRedisBloomCMS(data []byte) (*CMS, error) {
+	if len(data) < redisBloomHeaderSize {
+		return nil, errors.New("data too short")
+	}
+	width := binary.LittleEndian.Uint64(data)
+	depth := binary.LittleEndian.Uint64(data[8:])
+	counter := binary.LittleEndian.Uint64(data[16:])
+	if counter > math.MaxUint {
+		return nil, fmt.Errorf("%w: value out of range", ErrCorruptData)
+	}
+	// The size of the cells in bytes must fit in an int.
+	if width == 0 || depth == 0 || width > math.MaxInt/4/depth {
+		return nil, fmt.Errorf("%w: invalid dimensions", ErrCorruptData)
+	}
+	cells := data[redisBloomHeaderSize:]
+	if uint64(len(cells)) != width*depth*4 {
+		return nil, fmt.Errorf("%w: %d bytes of cells, want %d", ErrCorruptData, len(cells), width*depth*4)
+	}
+
+	cms, err := NewByDim(uint(width), uint(depth))
+	if err != nil {
+		return nil, err
+	}
+	cms.counter = uint(counter)
+	cms.hasher = RedisBloomHasher{}
+	for i := range cms.cells {
+		for j := range cms.cells[i] {
+			cms.cells[i][j] = uint(binary.LittleEndian.Uint32(cells))
+			cells = cells[4:]
+		}
+	}
+	return cms, nil
+}
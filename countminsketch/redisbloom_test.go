@@ -0,0 +1,71 @@
+package countminsketch
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/aviddiviner/go-murmur"
+	"github.com/stretchr/testify/assert"
+)
+
+func redisBloomDump(width, depth, counter uint64, cells ...uint32) []byte {
+	data := binary.LittleEndian.AppendUint64(nil, width)
+	data = binary.LittleEndian.AppendUint64(data, depth)
+	data = binary.LittleEndian.AppendUint64(data, counter)
+	for _, v := range cells {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+	return data
+}
+
+func TestLoadRedisBloomCMS(t *testing.T) {
+	cms, err := LoadRedisBloomCMS(redisBloomDump(3, 2, 7, 1, 0, 6, 4, 3, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), cms.Width())
+	assert.Equal(t, uint(2), cms.Depth())
+	assert.Equal(t, uint(7), cms.TotalCount())
+	assert.Equal(t, [][]uint{{1, 0, 6}, {4, 3, 0}}, cms.cells)
+	minCell, maxCell, nonZero := cms.CellStats()
+	assert.Equal(t, []uint{0, 6, 4}, []uint{minCell, maxCell, nonZero})
+
+	_, err = LoadRedisBloomCMS(redisBloomDump(3, 2, 7)[:20])
+	assert.Error(t, err)
+	_, err = LoadRedisBloomCMS(redisBloomDump(3, 2, 7, 1, 0, 6, 4, 3))
+	assert.ErrorIs(t, err, ErrCorruptData)
+	_, err = LoadRedisBloomCMS(redisBloomDump(3, 2, 7, 1, 0, 6, 4, 3, 0, 0))
+	assert.ErrorIs(t, err, ErrCorruptData)
+	_, err = LoadRedisBloomCMS(redisBloomDump(0, 2, 0))
+	assert.ErrorIs(t, err, ErrCorruptData)
+	_, err = LoadRedisBloomCMS(redisBloomDump(1<<62, 1<<62, 0))
+	assert.ErrorIs(t, err, ErrCorruptData)
+}
+
+func TestLoadRedisBloomCMSItems(t *testing.T) {
+	// Count items as CMS.INCRBY does.
+	const width, depth = 1000, 4
+	cells := make([]uint32, width*depth)
+	counts := map[string]uint32{"hello": 3, "foo": 2, "a key of typical length": 7}
+	counter := uint64(0)
+	for item, n := range counts {
+		for i := range uint32(depth) {
+			cells[i*width+murmur.MurmurHash2([]byte(item), i)%width] += n
+		}
+		counter += uint64(n)
+	}
+
+	cms, err := LoadRedisBloomCMS(redisBloomDump(width, depth, counter, cells...))
+	assert.NoError(t, err)
+	for item, n := range counts {
+		assert.Equal(t, uint(n), cms.Query([]byte(item)))
+	}
+	assert.Equal(t, uint(4), cms.IncrBy([]byte("foo"), 2))
+	assert.Equal(t, uint(0), cms.Query([]byte("bar")))
+
+	// The hasher is kept by serialization.
+	data, err := cms.MarshalBinary()
+	assert.NoError(t, err)
+	res := &CMS{}
+	assert.NoError(t, res.UnmarshalBinary(data))
+	assert.Equal(t, uint(4), res.Query([]byte("foo")))
+	assert.Equal(t, uint(3), res.Query([]byte("hello")))
+}
@@ -0,0 +1,95 @@
+package countminsketch
+
+import (
+	"errors"
+	"time"
+)
+
+// WindowedCMS counts the items of a sliding time window with a ring of
+// sketches, each covering an interval of time. IncrBy adds to the sketch of
+// the current interval and Query sums the estimates of all the sketches; when
+// an interval ends, the sketch of the oldest one is reset and reused.
+//
+// The window thus spans between bucketNum-1 and bucketNum intervals: counts
+// are forgotten an interval at a time, rather than item by item.
+type WindowedCMS struct {
+	buckets  []*CMS
+	cur      int
+	interval time.Duration
+	// start is when the interval of buckets[cur] started.
+	start time.Time
+	now   func() time.Time
+}
+
+// NewWindowed returns a sliding window of bucketNum intervals, counted by
+// sketches of depth rows of width counters. now is the clock that drives the
+// window; nil means time.Now.
+func NewWindowed(width, depth uint, bucketNum int, interval time.Duration, now func() time.Time) (*WindowedCMS, error) {
+	if bucketNum <= 0 || interval <= 0 {
+		return nil, errors.New("invalid Parameter")
+	}
+	if now == nil {
+		now = time.Now
+	}
+
+	w := &WindowedCMS{
+		buckets:  make([]*CMS, bucketNum),
+		interval: interval,
+		start:    now(),
+		now:      now,
+	}
+	for i := range w.buckets {
+		cms, err := NewByDim(width, depth)
+		if err != nil {
+			return nil, err
+		}
+		w.buckets[i] = cms
+	}
+	return w, nil
+}
+
+// advance moves the window to the current time, resetting the sketches of the
+// intervals that ended. A clock that goes backwards doesn't move the window.
+func (w *WindowedCMS) advance() {
+	steps := w.now().Sub(w.start) / w.interval
+	if steps <= 0 {
+		return
+	}
+	w.start = w.start.Add(steps * w.interval)
+	for range min(steps, time.Duration(len(w.buckets))) {
+		w.cur = (w.cur + 1) % len(w.buckets)
+		w.buckets[w.cur].Reset()
+	}
+}
+
+// IncrBy increments the count of data in the current interval by val and
+// returns its new estimate over the window.
+func (w *WindowedCMS) IncrBy(data []byte, val uint) uint {
+	w.advance()
+	w.buckets[w.cur].IncrBy(data, val)
+	return w.query(data)
+}
+
+// Query returns an estimate of the count of data over the window.
+func (w *WindowedCMS) Query(data []byte) uint {
+	w.advance()
+	return w.query(data)
+}
+
+func (w *WindowedCMS) query(data []byte) uint {
+	count := uint(0)
+	for _, cms := range w.buckets {
+		count = addSat(count, cms.Query(data))
+	}
+	return count
+}
+
+// TotalCount returns the sum of the increments over the window.
+func (w *WindowedCMS) TotalCount() uint {
+	w.advance()
+	count := uint(0)
+	for _, cms := range w.buckets {
+		count = addSat(count, cms.TotalCount())
+	}
+	return count
+}
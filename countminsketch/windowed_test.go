@@ -0,0 +1,49 @@
+package countminsketch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowedCMS(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	w, err := NewWindowed(1000, 5, 3, time.Minute, clock)
+	assert.NoError(t, err)
+
+	a, b := []byte("a"), []byte("b")
+	assert.Equal(t, uint(1), w.IncrBy(a, 1))
+	now = now.Add(time.Minute)
+	assert.Equal(t, uint(3), w.IncrBy(a, 2))
+	assert.Equal(t, uint(5), w.IncrBy(b, 5))
+	now = now.Add(90 * time.Second)
+	assert.Equal(t, uint(7), w.IncrBy(a, 4))
+	assert.Equal(t, uint(12), w.TotalCount())
+
+	// The first interval leaves the window.
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, uint(6), w.Query(a))
+	assert.Equal(t, uint(5), w.Query(b))
+	assert.Equal(t, uint(11), w.TotalCount())
+
+	// A clock going backwards doesn't move the window.
+	now = now.Add(-time.Hour)
+	assert.Equal(t, uint(6), w.Query(a))
+
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, uint(0), w.Query(a))
+	assert.Equal(t, uint(0), w.TotalCount())
+	assert.Equal(t, uint(1), w.IncrBy(a, 1))
+
+	for _, p := range []struct {
+		bucketNum int
+		interval  time.Duration
+	}{{0, time.Minute}, {3, 0}} {
+		_, err := NewWindowed(1000, 5, p.bucketNum, p.interval, nil)
+		assert.Error(t, err)
+	}
+	_, err = NewWindowed(0, 5, 3, time.Minute, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package cuckoofilter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+)
+
+// loadCheckInterval is the number of lines LoadFromReader inserts between
+// checks of its context.
+const loadCheckInterval = 1024
+
+// maxLineSize bounds the size of a line read by LoadFromReader.
+const maxLineSize = 1 << 20
+
+// InsertMany inserts all items in order and returns whether each of them
+// was inserted. Each item is hashed right before it's inserted: hashing a
+// batch of items first is slower, see BenchmarkInsertMany.
+func (cf *CuckooFilter) InsertMany(items [][]byte) []bool {
+	res := make([]bool, len(items))
+	for i, data := range items {
+		status := cf.insertFp(cf.buildParams(data))
+		res[i] = status == Inserted || status == AlreadyExist
+	}
+	return res
+}
+
+// InsertAll inserts items in order, like as many calls to Insert, and returns
+// the number of them that were inserted. Unlike InsertMany, it doesn't
+// allocate, and takes the items as arguments: cf.InsertAll(a, b, c).
+func (cf *CuckooFilter) InsertAll(items ...[]byte) int {
+	n := 0
+	for _, data := range items {
+		if status := cf.insertFp(cf.buildParams(data)); status == Inserted || status == AlreadyExist {
+			n++
+		}
+	}
+	return n
+}
+
+// ExistMany reports whether each of the items may be in the filter. As with
+// InsertMany, each item is hashed right before its lookup, see
+// BenchmarkExistMany.
+func (cf *CuckooFilter) ExistMany(items [][]byte) []bool {
+	res := make([]bool, len(items))
+	for i, data := range items {
+		res[i] = cf.existFp(cf.buildParams(data))
+	}
+	return res
+}
+
+// DeleteMany deletes all items and returns whether each of them was found.
+// Unlike repeated calls to Delete, the filter is compacted at most once,
+// after the whole batch is deleted.
+func (cf *CuckooFilter) DeleteMany(items [][]byte) []bool {
+	res := make([]bool, len(items))
+	deleted := false
+	for i, data := range items {
+		res[i] = cf.deleteFp(cf.buildParams(data))
+		deleted = deleted || res[i]
+	}
+	if deleted {
+		cf.compactIfNeeded()
+	}
+	return res
+}
+
+// LoadFromReader inserts every line read from r, without its line terminator
+// ("\n" or "\r\n"), and returns the number of items inserted. Empty lines
+// are skipped. Lines can't be longer than 1 MiB.
+// The context is checked every 1024 lines, and its error returned once it's
+// done. If an item can't be inserted, the load stops with an error too;
+// the items inserted so far remain in the filter.
+func (cf *CuckooFilter) LoadFromReader(ctx context.Context, r io.Reader) (inserted uint64, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if line%loadCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return inserted, err
+			}
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if status := cf.insertFp(cf.buildParams(scanner.Bytes())); status != Inserted {
+			return inserted, fmt.Errorf("line %d: %v", line, status)
+		}
+		inserted++
+	}
+	return inserted, scanner.Err()
+}
@@ -0,0 +1,207 @@
+package cuckoofilter
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func keys(from, to int) [][]byte {
+	res := make([][]byte, 0, to-from)
+	for i := from; i < to; i++ {
+		res = append(res, []byte(strconv.Itoa(i)))
+	}
+	return res
+}
+
+func TestInsertMany(t *testing.T) {
+	cap := 10000
+	cf := New(uint64(cap/8), defaultBucketSize, 50, 1)
+	res := cf.InsertMany(keys(0, cap))
+	assert.Len(t, res, cap)
+	for i := range res {
+		assert.True(t, res[i])
+	}
+	assert.Equal(t, uint64(cap), cf.itemNum)
+
+	expected := New(uint64(cap/8), defaultBucketSize, 50, 1)
+	fill(expected, cap)
+	assert.Equal(t, expected, cf)
+
+	assert.Empty(t, cf.InsertMany(nil))
+}
+
+func TestInsertAll(t *testing.T) {
+	cf := New(1000, defaultBucketSize, 20, 1)
+	assert.Equal(t, 3, cf.InsertAll([]byte("a"), []byte("b"), []byte("c")))
+	assert.Equal(t, 0, cf.InsertAll())
+	assert.Equal(t, uint64(3), cf.itemNum)
+	for _, k := range []string{"a", "b", "c"} {
+		assert.True(t, cf.Exist([]byte(k)))
+	}
+
+	items := keys(0, 5000)
+	assert.Equal(t, len(items), cf.InsertAll(items...))
+	assert.Greater(t, cf.filterNum, uint16(1))
+
+	// Items that don't fit in a filter that can't grow aren't counted.
+	full := New(8, 1, 1, 0)
+	n := full.InsertAll(keys(0, 100)...)
+	assert.Less(t, n, 100)
+	assert.Equal(t, uint64(n), full.itemNum)
+}
+
+func TestExistMany(t *testing.T) {
+	cap := 10000
+	cf := New(uint64(cap), defaultBucketSize, 50, 1)
+	fill(cf, cap)
+
+	items := keys(0, 2*cap)
+	res := cf.ExistMany(items)
+	assert.Len(t, res, len(items))
+	for i := range items {
+		assert.Equal(t, cf.Exist(items[i]), res[i])
+		if i < cap {
+			assert.True(t, res[i])
+		}
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	cap := 10000
+	cf := New(uint64(cap/8), defaultBucketSize, 50, 2)
+	fill(cf, cap)
+	filterNum := cf.filterNum
+
+	res := cf.DeleteMany(keys(cap/8, cap+10))
+	for i := range res {
+		assert.Equal(t, i < cap-cap/8, res[i])
+	}
+	assert.Equal(t, uint64(cap/8), cf.itemNum)
+	assert.Equal(t, uint64(0), cf.deleteNum)
+	assert.Less(t, cf.filterNum, filterNum)
+	for _, k := range keys(0, cap/8) {
+		assert.True(t, cf.Exist(k))
+	}
+
+	// Few deletions don't trigger compaction.
+	cf.DeleteMany(keys(0, 10))
+	assert.Equal(t, uint64(10), cf.deleteNum)
+}
+
+func TestLoadFromReader(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 5000; i++ {
+		sb.WriteString(strconv.Itoa(i))
+		if i%2 == 0 {
+			sb.WriteString("\r\n\n")
+		} else {
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString("last")
+
+	cf := New(1000, defaultBucketSize, 20, 1)
+	n, err := cf.LoadFromReader(context.Background(), strings.NewReader(sb.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5001), n)
+	assert.Equal(t, uint64(5001), cf.itemNum)
+	for _, k := range keys(0, 5000) {
+		assert.True(t, cf.Exist(k))
+	}
+	assert.True(t, cf.Exist([]byte("last")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cf = New(1000, defaultBucketSize, 20, 1)
+	n, err = cf.LoadFromReader(ctx, strings.NewReader(sb.String()))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, n, uint64(loadCheckInterval))
+	assert.Equal(t, n, cf.itemNum)
+
+	cf = New(64, defaultBucketSize, 20, 0)
+	n, err = cf.LoadFromReader(context.Background(), strings.NewReader(sb.String()))
+	assert.ErrorContains(t, err, "NoSpace")
+	assert.Equal(t, n, cf.itemNum)
+
+	_, err = cf.LoadFromReader(context.Background(), strings.NewReader(strings.Repeat("x", maxLineSize+1)))
+	assert.Error(t, err)
+}
+
+// existManyHashFirst is ExistMany, but it hashes batches of 64 keys before
+// looking any of them up.
+func existManyHashFirst(cf *CuckooFilter, keys [][]byte, res []bool) {
+	var buf [64]params
+	for start := 0; start < len(keys); start += len(buf) {
+		ps := buf[:min(len(buf), len(keys)-start)]
+		for i := range ps {
+			ps[i] = cf.buildParams(keys[start+i])
+		}
+		for i := range ps {
+			res[start+i] = cf.existFp(ps[i])
+		}
+	}
+}
+
+// insertManyHashFirst is InsertMany, but it hashes batches of 64 keys before
+// inserting any of them.
+func insertManyHashFirst(cf *CuckooFilter, keys [][]byte, res []bool) {
+	var buf [64]params
+	for start := 0; start < len(keys); start += len(buf) {
+		ps := buf[:min(len(buf), len(keys)-start)]
+		for i := range ps {
+			ps[i] = cf.buildParams(keys[start+i])
+		}
+		for i := range ps {
+			res[start+i] = cf.insertFp(ps[i]) == Inserted
+		}
+	}
+}
+
+// BenchmarkExistMany compares ExistMany, which hashes each key right before
+// its lookup, with hashing batches of keys before looking any of them up.
+// The latter was measured about 10% slower on amd64: the lookups of a batch
+// can't overlap with the hashing of the next keys anymore.
+func BenchmarkExistMany(b *testing.B) {
+	cf, keys := benchFilter()
+	keys = keys[:1024]
+	res := make([]bool, len(keys))
+	b.Run("Interleaved", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cf.ExistMany(keys)
+		}
+	})
+	b.Run("HashFirst", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			existManyHashFirst(cf, keys, res)
+		}
+	})
+}
+
+// BenchmarkInsertMany is BenchmarkExistMany for InsertMany, into a filter
+// that is reset once half full. Hashing first was measured about 15% slower.
+func BenchmarkInsertMany(b *testing.B) {
+	keys := keys(0, 1024)
+	res := make([]bool, len(keys))
+	cf := New(1<<20, 4, 20, 1)
+	b.Run("Interleaved", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if cf.itemNum > 1<<19 {
+				cf.Reset()
+			}
+			cf.InsertMany(keys)
+		}
+	})
+	cf.Reset()
+	b.Run("HashFirst", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if cf.itemNum > 1<<19 {
+				cf.Reset()
+			}
+			insertManyHashFirst(cf, keys, res)
+		}
+	})
+}
@@ -1,27 +1,49 @@
 package cuckoofilter
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
+	"math/bits"
+	"reflect"
+	"slices"
 
-	"github.com/aviddiviner/go-murmur"
+	"github.com/fukua95/pds/internal/hashing"
 )
 
 type cuckooHash uint64
-type fingerprint uint8
+type fingerprint uint16
 
 const nullFp fingerprint = 0
 
+// Parameters used when a filter is created without explicit ones.
+const (
+	defaultCapacity   = 1024
+	defaultBucketSize = 2
+	defaultMaxIter    = 20
+	defaultExpansion  = 1
+)
+
+// A bucket is a view of the slots of one bucket of a sub-filter, which stores
+// its fingerprints in fpSize bytes each, little-endian.
 type bucket struct {
-	slots []fingerprint
+	slots  []byte
+	fpSize uint8
 }
 
 type subCF struct {
 	bucketNum  uint64
 	bucketSize uint16
-	buckets    []bucket // all buckets have the same size
+	fpSize     uint8
+	data       []byte // the slots of all buckets, one bucket after the other
 }
 
 // A scalable cuckoo filter.
+//
+// Items are arbitrary byte slices, including empty ones: nil and []byte{} are
+// the same item, which is hashed like any other. MurmurHash64A of the empty
+// item is 0, so it's stored as fingerprint 1 in bucket 0, as in RedisBloom.
 type CuckooFilter struct {
 	bucketNum  uint64
 	bucketSize uint16
@@ -30,7 +52,85 @@ type CuckooFilter struct {
 	maxIter    uint16
 	expansion  uint16
 	filterNum  uint16
+	fpSize     uint8 // 1 or 2 bytes
 	filters    []subCF
+	hasher     Hasher // nil means MurmurHasher
+	pool       Pool   // nil means sub-filters are allocated on the heap
+
+	// maxIter is scaled with the load factor when autoMaxIter is set.
+	autoMaxIter bool
+
+	// Eviction picks victims at random when randomEvict is set.
+	randomEvict bool
+	seed        uint64
+	rngState    uint64
+
+	// compactCursor is the progress of CompactStep.
+	compactCursor compactCursor
+}
+
+// A Hasher hashes the items of a filter. Both the bucket indexes and the
+// fingerprint of an item are derived from its 64-bit hash.
+type Hasher interface {
+	Sum64(data []byte) uint64
+}
+
+// MurmurHasher is the default Hasher: MurmurHash64A with seed 0, as RedisBloom uses.
+type MurmurHasher struct{}
+
+func (MurmurHasher) Sum64(data []byte) uint64 {
+	return hashing.Sum64(data, 0)
+}
+
+// XXHasher is XXH64 with seed 0. It's faster than MurmurHasher, mostly on long
+// items, but a filter built with it isn't compatible with RedisBloom.
+type XXHasher struct{}
+
+func (XXHasher) Sum64(data []byte) uint64 {
+	return hashing.XXHash{}.Sum64(data, 0)
+}
+
+// hasherID returns the id of the hasher of cf in serialized filters.
+func (cf *CuckooFilter) hasherID() uint8 {
+	switch cf.hasher.(type) {
+	case nil, MurmurHasher:
+		return hashing.IDMurmur
+	case XXHasher:
+		return hashing.IDXXHash
+	}
+	return hashing.IDCustom
+}
+
+// setHasherID sets the hasher of cf to the one identified by id. A custom
+// hasher can't be restored: cf must already have one, which is kept.
+func (cf *CuckooFilter) setHasherID(id uint8) error {
+	switch id {
+	case hashing.IDMurmur:
+		cf.hasher = nil
+	case hashing.IDXXHash:
+		cf.hasher = XXHasher{}
+	case hashing.IDCustom:
+		if cf.hasherID() != hashing.IDCustom {
+			return errors.New("filter uses a custom hasher")
+		}
+	default:
+		return errors.New("unknown hasher")
+	}
+	return nil
+}
+
+// sameHasher reports whether cf and other hash items the same way. Custom
+// hashers must be equal values of a comparable type.
+func (cf *CuckooFilter) sameHasher(other *CuckooFilter) bool {
+	id := cf.hasherID()
+	if id != other.hasherID() {
+		return false
+	}
+	if id != hashing.IDCustom {
+		return true
+	}
+	t := reflect.TypeOf(cf.hasher)
+	return t == reflect.TypeOf(other.hasher) && t.Comparable() && cf.hasher == other.hasher
 }
 
 type params struct {
@@ -39,9 +139,15 @@ type params struct {
 	fp fingerprint
 }
 
-func buildParams(data []byte) params {
-	hash := murmur.MurmurHash64A(data, 0)
-	fp := fingerprint(hash%255 + 1)
+func (cf *CuckooFilter) buildParams(data []byte) params {
+	var hash uint64
+	if cf.hasher == nil {
+		hash = MurmurHasher{}.Sum64(data)
+	} else {
+		hash = cf.hasher.Sum64(data)
+	}
+	// Fingerprints are in [1, 2^(8*fpSize) - 1], 0 marks an empty slot.
+	fp := fingerprint(hash%(1<<(8*cf.fpSize)-1) + 1)
 	return params{
 		h1: cuckooHash(hash),
 		h2: altHash(fp, cuckooHash(hash)),
@@ -61,84 +167,137 @@ func altHash(fp fingerprint, index cuckooHash) cuckooHash {
 
 // methods of bucket
 
-func makeBucket(size uint16) bucket {
-	return bucket{
-		slots: make([]fingerprint, size),
+func (b bucket) size() int {
+	return len(b.slots) / int(b.fpSize)
+}
+
+func (b bucket) get(i int) fingerprint {
+	if b.fpSize == 1 {
+		return fingerprint(b.slots[i])
+	}
+	return fingerprint(binary.LittleEndian.Uint16(b.slots[2*i:]))
+}
+
+func (b bucket) set(i int, fp fingerprint) {
+	if b.fpSize == 1 {
+		b.slots[i] = byte(fp)
+		return
 	}
+	binary.LittleEndian.PutUint16(b.slots[2*i:], uint16(fp))
+}
+
+// swap stores fp in the i-th slot and returns the fingerprint it replaced.
+func (b bucket) swap(i int, fp fingerprint) fingerprint {
+	old := b.get(i)
+	b.set(i, fp)
+	return old
 }
 
-func (b *bucket) find(fp fingerprint) bool {
-	for _, v := range b.slots {
-		if v == fp {
+func (b bucket) find(fp fingerprint) bool {
+	for i := 0; i < b.size(); i++ {
+		if b.get(i) == fp {
 			return true
 		}
 	}
 	return false
 }
 
-func (b *bucket) delete(fp fingerprint) bool {
-	for i, v := range b.slots {
-		if v == fp {
-			b.slots[i] = nullFp
+func (b bucket) delete(fp fingerprint) bool {
+	for i := 0; i < b.size(); i++ {
+		if b.get(i) == fp {
+			b.set(i, nullFp)
 			return true
 		}
 	}
 	return false
 }
 
-func (b *bucket) count(fp fingerprint) uint16 {
+func (b bucket) deleteAll(fp fingerprint) uint16 {
+	res := uint16(0)
+	for i := 0; i < b.size(); i++ {
+		if b.get(i) == fp {
+			b.set(i, nullFp)
+			res++
+		}
+	}
+	return res
+}
+
+func (b bucket) count(fp fingerprint) uint16 {
 	res := uint16(0)
-	for _, v := range b.slots {
-		if v == fp {
+	for i := 0; i < b.size(); i++ {
+		if b.get(i) == fp {
 			res++
 		}
 	}
 	return res
 }
 
-func (b *bucket) findAvailableSlot() (*fingerprint, bool) {
-	for i := range b.slots {
-		if b.slots[i] == nullFp {
-			return &b.slots[i], true
+func (b bucket) findAvailableSlot() (int, bool) {
+	for i := 0; i < b.size(); i++ {
+		if b.get(i) == nullFp {
+			return i, true
 		}
 	}
-	return nil, false
+	return 0, false
 }
 
 // methods of subCF
 
-func (s *subCF) bucketIndex(hash cuckooHash) uint32 {
-	return uint32((uint64(hash) % s.bucketNum))
+// bucket returns the i-th bucket, which shares its slots with the sub-filter.
+func (s *subCF) bucket(i uint64) bucket {
+	size := uint64(s.bucketSize) * uint64(s.fpSize)
+	return bucket{
+		slots:  s.data[i*size : (i+1)*size : (i+1)*size],
+		fpSize: s.fpSize,
+	}
+}
+
+func (s *subCF) bucketIndex(hash cuckooHash) uint64 {
+	return uint64(hash) % s.bucketNum
 }
 
 func (s *subCF) find(params params) bool {
 	p1, p2 := s.bucketIndex(params.h1), s.bucketIndex(params.h2)
-	return s.buckets[p1].find(params.fp) || s.buckets[p2].find(params.fp)
+	return s.bucket(p1).find(params.fp) || s.bucket(p2).find(params.fp)
 }
 
 func (s *subCF) delete(params params) bool {
 	p1, p2 := s.bucketIndex(params.h1), s.bucketIndex(params.h2)
-	return s.buckets[p1].delete(params.fp) || s.buckets[p2].delete(params.fp)
+	return s.bucket(p1).delete(params.fp) || s.bucket(p2).delete(params.fp)
+}
+
+func (s *subCF) deleteAll(params params) uint16 {
+	p1, p2 := s.bucketIndex(params.h1), s.bucketIndex(params.h2)
+	return s.bucket(p1).deleteAll(params.fp) + s.bucket(p2).deleteAll(params.fp)
 }
 
 func (s *subCF) count(params params) uint16 {
 	p1, p2 := s.bucketIndex(params.h1), s.bucketIndex(params.h2)
-	return s.buckets[p1].count(params.fp) + s.buckets[p2].count(params.fp)
+	if p1 == p2 {
+		return s.bucket(p1).count(params.fp)
+	}
+	return s.bucket(p1).count(params.fp) + s.bucket(p2).count(params.fp)
 }
 
-func (s *subCF) findAvailableSlot(params params) (*fingerprint, bool) {
+func (s *subCF) findAvailableSlot(params params) (bucket, int, bool) {
 	p1, p2 := s.bucketIndex(params.h1), s.bucketIndex(params.h2)
-	for _, p := range []uint32{p1, p2} {
-		if slot, ok := s.buckets[p].findAvailableSlot(); ok {
-			return slot, true
+	for _, p := range []uint64{p1, p2} {
+		b := s.bucket(p)
+		if slot, ok := b.findAvailableSlot(); ok {
+			return b, slot, true
 		}
 	}
-	return nil, false
+	return bucket{}, 0, false
 }
 
 // methods of CuckooFilter
 
+// next2N returns the smallest power of 2 that is >= n, and 1 for 0.
 func next2N(n uint64) uint64 {
+	if n == 0 {
+		return 1
+	}
 	n--
 	n |= n >> 1
 	n |= n >> 2
@@ -160,6 +319,7 @@ func next2N(n uint64) uint64 {
  *  a higher bucket size value improves the fill rate but also causes a higher error rate and slightly slower performance.
  *  error_rate = (bucket_size * hash_function_num) / 2 ^ fingerprint_size = (bucket_size * 2) / 256
  *  so when bucket size is 1, error rate = 0.78% is the minimal false positive rate we can achieve.
+ *  use New16 for a lower error rate, or NewForFPR to derive the bucket size from a target rate.
  *
  * @expansion
  *  the scaling factor.
@@ -168,118 +328,499 @@ func next2N(n uint64) uint64 {
  * @maxIter
  *  the number of attempts to find a slot for the incoming fingerprint.
  *  its default value is 20.
+ *
+ * The parameters aren't validated: if the first sub-filter can't be allocated,
+ * e.g. because capacity is too large, the filter has none, and every insert
+ * fails with MemAllocFailed. NewChecked returns an error instead.
  */
 func New(capacity uint64, bucketSize uint16, maxIter uint16, expansion uint16) *CuckooFilter {
+	cf, _ := newFilter(capacity, bucketSize, maxIter, expansion, 1, nil, nil)
+	return cf
+}
+
+// NewWithHasher is like New, but the filter hashes items with h instead of
+// MurmurHash64A, e.g. XXHasher, or a keyed hash to resist inputs crafted to
+// force evictions. As with New, the filter can't hold any item if its first
+// sub-filter can't be allocated.
+//
+// Serialized filters record whether they use MurmurHasher, XXHasher or another
+// hasher, and decoding restores the first two. Other hashers can't be
+// serialized: a filter built with one must be decoded into a filter created by
+// NewWithHasher with the same hasher.
+func NewWithHasher(capacity uint64, bucketSize uint16, maxIter uint16, expansion uint16, h Hasher) *CuckooFilter {
+	cf, _ := newFilter(capacity, bucketSize, maxIter, expansion, 1, h, nil)
+	return cf
+}
+
+// NewWithPool is like New, but the fingerprint storage of the sub-filters is
+// taken from pool, and given back to it when a sub-filter is dropped by Reset,
+// compaction or Rebuild, or when the filter is released.
+// Filters that are created and discarded often, or that grow and shrink, can
+// then reuse memory instead of leaving it to the garbage collector.
+// Like the hasher, the pool is kept by decoding and isn't serialized.
+// As with New, the filter can't hold any item if the pool can't provide its
+// first sub-filter.
+func NewWithPool(capacity uint64, bucketSize uint16, maxIter uint16, expansion uint16, pool Pool) *CuckooFilter {
+	cf, _ := newFilter(capacity, bucketSize, maxIter, expansion, 1, nil, pool)
+	return cf
+}
+
+// NewChecked is like New, but it validates the parameters and returns an
+// error instead of a filter that can't work.
+func NewChecked(capacity uint64, bucketSize uint16, maxIter uint16, expansion uint16) (*CuckooFilter, error) {
+	if err := checkParams(capacity, bucketSize, maxIter, expansion, 1); err != nil {
+		return nil, err
+	}
+	return newFilter(capacity, bucketSize, maxIter, expansion, 1, nil, nil)
+}
+
+// maxSubFilterSize bounds the size in bytes of a sub-filter: the runtime
+// can't allocate more than 2^48 bytes on 64-bit platforms, and panics if asked.
+const maxSubFilterSize = min(math.MaxInt, 1<<47)
+
+func checkParams(capacity uint64, bucketSize uint16, maxIter uint16, expansion uint16, fpSize uint8) error {
+	if capacity == 0 {
+		return errors.New("capacity must be positive")
+	}
+	if bucketSize == 0 {
+		return errors.New("bucketSize must be positive")
+	}
+	if maxIter == 0 {
+		return errors.New("maxIter must be positive")
+	}
+	if expansion > 1<<15 {
+		return fmt.Errorf("expansion must be at most %d", 1<<15)
+	}
+	bucketNum := capacity / uint64(bucketSize)
+	if bucketNum > 1<<63 {
+		return errors.New("capacity is too large")
+	}
+	if hi, size := bits.Mul64(next2N(bucketNum), uint64(bucketSize)*uint64(fpSize)); hi != 0 || size > maxSubFilterSize {
+		return errors.New("capacity is too large")
+	}
+	// The filter must be able to grow at least once.
+	if hi, _ := bits.Mul64(next2N(bucketNum), next2N(uint64(expansion))); hi != 0 {
+		return errors.New("capacity is too large for the expansion")
+	}
+	return nil
+}
+
+// New16 is like New, but the filter stores 16-bit fingerprints.
+// It takes twice the memory of New for an error rate 256 times lower:
+// error_rate = (bucket_size * 2) / 65536.
+// As with New, the filter can't hold any item if its first sub-filter can't be
+// allocated.
+func New16(capacity uint64, bucketSize uint16, maxIter uint16, expansion uint16) *CuckooFilter {
+	cf, _ := newFilter(capacity, bucketSize, maxIter, expansion, 2, nil, nil)
+	return cf
+}
+
+// maxFPRBucketSize bounds the bucket size chosen by NewForFPR: larger buckets
+// fill better, but lookups get slower for a false positive rate that's only
+// worth it for 8-bit fingerprints anyway.
+const maxFPRBucketSize = 4
+
+// NewForFPR returns a filter of the given capacity whose false positive rate
+// is at most targetFPR, with the default maxIter and expansion of New.
+// It picks the largest bucket size, up to 4, that meets the target with 8-bit
+// fingerprints, from error_rate = (bucket_size * 2) / 256 (see New). Below
+// 0.78%, which 8-bit fingerprints can't achieve, it uses 16-bit ones, down to
+// a rate of 2 / 65536 with a bucket size of 1; lower targets get that rate.
+//
+// The rate is that of a single sub-filter: each time the filter grows, the
+// rate of the new sub-filter adds up. As with New, the filter can't hold any
+// item if its first sub-filter can't be allocated.
+func NewForFPR(capacity uint64, targetFPR float64) *CuckooFilter {
+	bucketSize, fpSize := bucketSizeForFPR(targetFPR)
+	cf, _ := newFilter(capacity, bucketSize, defaultMaxIter, defaultExpansion, fpSize, nil, nil)
+	return cf
+}
+
+// bucketSizeForFPR returns the bucket size and fingerprint size of NewForFPR.
+func bucketSizeForFPR(targetFPR float64) (uint16, uint8) {
+	for fpSize := uint8(1); fpSize <= 2; fpSize++ {
+		// The largest bucket size b with b*2 / 2^(8*fpSize) <= targetFPR.
+		b := math.Floor(targetFPR * float64(uint64(1)<<(8*fpSize)) / 2)
+		if b >= 1 {
+			return uint16(min(b, maxFPRBucketSize)), fpSize
+		}
+	}
+	return 1, 2
+}
+
+// newFilter returns a filter with its first sub-filter, or an error if it
+// can't be allocated, along with a filter that has none.
+func newFilter(capacity uint64, bucketSize uint16, maxIter uint16, expansion uint16, fpSize uint8, h Hasher, pool Pool) (*CuckooFilter, error) {
+	if bucketSize == 0 {
+		return &CuckooFilter{maxIter: maxIter, fpSize: fpSize, hasher: h, pool: pool},
+			errors.New("bucketSize must be positive")
+	}
 	filter := &CuckooFilter{
-		expansion:  uint16(next2N(uint64(expansion))),
+		expansion:  0,
 		bucketSize: bucketSize,
 		maxIter:    maxIter,
 		bucketNum:  next2N(capacity / uint64(bucketSize)),
 		filterNum:  0,
+		fpSize:     fpSize,
+		hasher:     h,
+		pool:       pool,
 	}
-	if filter.bucketNum == 0 {
-		filter.bucketNum = 1
+	// An expansion of 0 means the filter never grows.
+	if expansion > 0 {
+		filter.expansion = uint16(next2N(uint64(expansion)))
 	}
-	filter.grow()
-	return filter
+	if !filter.grow() {
+		return filter, errors.New("first sub-filter can't be allocated")
+	}
+	return filter, nil
 }
 
-func (cf *CuckooFilter) grow() {
-	growth := math.Pow(float64(cf.expansion), float64(cf.filterNum))
+// bucketNumOf returns the number of buckets of the filterIx-th sub-filter,
+// and false if it overflows.
+func (cf *CuckooFilter) bucketNumOf(filterIx uint16) (uint64, bool) {
+	n := cf.bucketNum
+	if filterIx == 0 || cf.expansion == 1 {
+		return n, true
+	}
+	for i := uint16(0); i < filterIx; i++ {
+		hi, lo := bits.Mul64(n, uint64(cf.expansion))
+		if hi != 0 {
+			return 0, false
+		}
+		n = lo
+	}
+	return n, true
+}
+
+// grow adds a sub-filter. It returns false and leaves the filter unchanged
+// if the size of the new sub-filter overflows, or the pool can't provide it.
+func (cf *CuckooFilter) grow() bool {
+	if cf.filterNum == math.MaxUint16 {
+		return false
+	}
+	bucketNum, ok := cf.bucketNumOf(cf.filterNum)
+	if !ok {
+		return false
+	}
+	hi, size := bits.Mul64(bucketNum, uint64(cf.bucketSize)*uint64(cf.fpSize))
+	if hi != 0 || size > maxSubFilterSize {
+		return false
+	}
 
 	curFilter := subCF{
 		bucketSize: cf.bucketSize,
-		bucketNum:  cf.bucketNum * uint64(growth),
+		bucketNum:  bucketNum,
+		fpSize:     cf.fpSize,
 	}
-	curFilter.buckets = make([]bucket, curFilter.bucketNum)
-	for i := range curFilter.buckets {
-		curFilter.buckets[i] = makeBucket(curFilter.bucketSize)
+	if cf.pool != nil {
+		curFilter.data = cf.pool.Get(int(size))
+		if len(curFilter.data) != int(size) {
+			return false
+		}
+	} else {
+		curFilter.data = make([]byte, size)
 	}
 
 	cf.filters = append(cf.filters, curFilter)
 	cf.filterNum++
+	return true
 }
 
-type cuckooInsertStatus int8
+// Reserve adds sub-filters up front until inserting expectedItems items
+// doesn't grow the filter, as the same inserts would by growing it, so that a
+// bulk load of a known size doesn't grow the filter on the way. The filter
+// ends up as if those inserts had grown it: lookups go through every
+// sub-filter, and compaction may free the reserved ones that are still empty.
+//
+// Inserts can't fill every slot before evictions fail, so Reserve sizes the
+// filter for the share of slots they fill with the bucket size of the filter
+// and the default maxIter: 50% with buckets of 1 slot, 65% with 2, 80% with 4
+// and 85% with 8 or more, a margin below what was measured. A much lower
+// maxIter fills fewer slots, and may still grow the filter. An error is
+// returned, and the filter is left unchanged, if it can't grow that much.
+func (cf *CuckooFilter) Reserve(expectedItems uint64) error {
+	need := math.Ceil(float64(expectedItems) / reserveLoad(cf.bucketSize))
+	if need >= math.MaxUint64 {
+		return errors.New("too many items to reserve")
+	}
+	slots, want := cf.Capacity(), uint64(need)
+	if slots >= want {
+		return nil
+	}
+	if cf.filterNum == 0 || cf.expansion == 0 {
+		return errors.New("filter can't grow")
+	}
+	filterNum := cf.filterNum
+	for slots < want {
+		if !cf.grow() {
+			cf.release(cf.filters[filterNum:])
+			cf.filters = cf.filters[:filterNum]
+			cf.filterNum = filterNum
+			return errors.New("too many items to reserve")
+		}
+		slots += cf.filters[cf.filterNum-1].slotNum()
+	}
+	return nil
+}
+
+// reserveLoad returns the share of the slots of a filter with bucketSize
+// slots per bucket that Reserve expects inserts to fill before they grow it.
+func reserveLoad(bucketSize uint16) float64 {
+	switch {
+	case bucketSize >= 8:
+		return 0.85
+	case bucketSize >= 4:
+		return 0.8
+	case bucketSize >= 2:
+		return 0.65
+	}
+	return 0.5
+}
+
+// CuckooStatus is the outcome of an insert, as RedisBloom's CuckooInsertStatus.
+type CuckooStatus int8
 
 const (
-	cuckooInserted       cuckooInsertStatus = 1
-	cuckooAlreadyExist   cuckooInsertStatus = 2
-	cuckooNospace        cuckooInsertStatus = 3
-	cuckooMemAllocFailed cuckooInsertStatus = 4
+	// Inserted means the item was added.
+	Inserted CuckooStatus = 1
+	// AlreadyExist means the item was found and not added again. It's only
+	// defined for parity with RedisBloom: inserts always add a new copy.
+	AlreadyExist CuckooStatus = 2
+	// NoSpace means the filter is full and isn't allowed to grow.
+	NoSpace CuckooStatus = 3
+	// MemAllocFailed means the filter is full and growing it would overflow.
+	MemAllocFailed CuckooStatus = 4
 )
 
-func (cf *CuckooFilter) evictAndInsert(params params) cuckooInsertStatus {
-	curFilter := &cf.filters[cf.filterNum-1]
+func (s CuckooStatus) String() string {
+	switch s {
+	case Inserted:
+		return "Inserted"
+	case AlreadyExist:
+		return "AlreadyExist"
+	case NoSpace:
+		return "NoSpace"
+	case MemAllocFailed:
+		return "MemAllocFailed"
+	}
+	return fmt.Sprintf("CuckooStatus(%d)", int8(s))
+}
+
+// SetMaxIter sets the number of evictions tried before an insert gives up on
+// the current sub-filters and grows the filter.
+// More iterations make inserts into a nearly full filter slower, but let it
+// reach a higher load factor, so it grows less often and uses less memory.
+// Fewer iterations keep inserts fast at the cost of growing earlier.
+func (cf *CuckooFilter) SetMaxIter(maxIter uint16) {
+	cf.maxIter = maxIter
+}
+
+// SetAutoMaxIter enables or disables the auto-tuning of maxIter.
+// When enabled, maxIter is the number of evictions tried while the filter is
+// at most half full, and it's scaled linearly up to 4 times as much when the
+// filter is full: evictions are cheap and rarely needed while there's room,
+// and worth more attempts when the alternative is to grow.
+// Like the random eviction, this setting isn't serialized.
+func (cf *CuckooFilter) SetAutoMaxIter(enabled bool) {
+	cf.autoMaxIter = enabled
+}
+
+// evictIter returns the number of evictions to try before growing.
+func (cf *CuckooFilter) evictIter() int {
+	if !cf.autoMaxIter {
+		return int(cf.maxIter)
+	}
+	scale := 1 + 6*max(cf.LoadFactor()-0.5, 0)
+	return min(int(float64(cf.maxIter)*scale), math.MaxUint16)
+}
+
+// SetRandomEviction makes evictions pick the victim slot at random, as in the
+// cuckoo filter paper, instead of cycling through the slots of a bucket.
+// It lets the filter reach a higher load factor before growing, mostly with
+// small buckets: with a bucket size of 2 and maxIter 100, a filter that can't
+// grow fills up to about 85% of its slots instead of 72%.
+// The random numbers come from a PRNG seeded with seed, so a sequence of
+// operations always yields the same filter for the same seed, slot by slot.
+// Like the hashes of items, the PRNG is implemented here rather than taken
+// from math/rand, so this holds across Go versions and platforms, e.g. to
+// replay the evictions that led to a bug or to compare with golden files.
+// The PRNG isn't serialized: a decoded filter evicts without randomness until
+// SetRandomEviction is called again.
+func (cf *CuckooFilter) SetRandomEviction(seed uint64) {
+	cf.randomEvict = true
+	cf.seed = seed
+	cf.rngState = seed
+}
+
+// EvictionSeed returns the seed passed to SetRandomEviction, and false if
+// victims aren't picked at random.
+func (cf *CuckooFilter) EvictionSeed() (uint64, bool) {
+	return cf.seed, cf.randomEvict
+}
+
+// nextRand returns the next number of the splitmix64 sequence.
+func (cf *CuckooFilter) nextRand() uint64 {
+	cf.rngState += 0x9e3779b97f4a7c15
+	z := cf.rngState
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (cf *CuckooFilter) evictAndInsert(filterIx uint16, params params) CuckooStatus {
+	curFilter := &cf.filters[filterIx]
 	fp := params.fp
 	victimIx := uint32(0)
+	maxIter := cf.evictIter()
+	victims := make([]uint32, 0, maxIter)
 	p := uint64(params.h1) % curFilter.bucketNum
 
-	for i := 0; i < int(cf.maxIter); i++ {
-		bucket := &curFilter.buckets[p]
-		bucket.slots[victimIx], fp = fp, bucket.slots[victimIx]
+	for i := 0; i < maxIter; i++ {
+		if cf.randomEvict {
+			victimIx = uint32(cf.nextRand() % uint64(cf.bucketSize))
+		}
+		victims = append(victims, victimIx)
+		fp = curFilter.bucket(p).swap(int(victimIx), fp)
+		// The victim can only move to its alternate bucket.
 		p = uint64(altHash(fp, cuckooHash(p))) % curFilter.bucketNum
-		if slot, ok := bucket.findAvailableSlot(); ok {
-			*slot = fp
-			return cuckooInserted
+		if slot, ok := curFilter.bucket(p).findAvailableSlot(); ok {
+			curFilter.bucket(p).set(slot, fp)
+			return Inserted
 		}
 		victimIx = (victimIx + 1) % uint32(cf.bucketSize)
 	}
 
 	// If weren't able to insert, we roll back and try to insert new element in new filter.
-	for i := 0; i < int(cf.maxIter); i++ {
-		victimIx = (victimIx + uint32(cf.bucketSize) - 1) % uint32(cf.bucketSize)
+	// Each step undoes one swap above in reverse order: bucketNum is a power of 2,
+	// so altHash on the victim's fingerprint leads back to the bucket it was taken from.
+	for i := len(victims) - 1; i >= 0; i-- {
 		p = uint64(altHash(fp, cuckooHash(p))) % curFilter.bucketNum
-		bucket := &curFilter.buckets[p]
-		bucket.slots[victimIx], fp = fp, bucket.slots[victimIx]
+		fp = curFilter.bucket(p).swap(int(victims[i]), fp)
 	}
 
-	return cuckooNospace
+	return NoSpace
 }
 
-func (cf *CuckooFilter) insertFp(params params) cuckooInsertStatus {
+func (cf *CuckooFilter) insertFp(params params) CuckooStatus {
+	// The first sub-filter couldn't be allocated.
+	if cf.filterNum == 0 {
+		return MemAllocFailed
+	}
 	for i := int(cf.filterNum) - 1; i >= 0; i-- {
-		if slot, ok := cf.filters[i].findAvailableSlot(params); ok {
-			*slot = params.fp
+		if b, slot, ok := cf.filters[i].findAvailableSlot(params); ok {
+			b.set(slot, params.fp)
 			cf.itemNum++
-			return cuckooInserted
+			return Inserted
 		}
 	}
 
 	// No space, time to evict.
-	if cf.evictAndInsert(params) == cuckooInserted {
+	if cf.evictAndInsert(cf.filterNum-1, params) == Inserted {
 		cf.itemNum++
-		return cuckooInserted
+		return Inserted
 	}
 
 	if cf.expansion == 0 {
-		return cuckooNospace
+		return NoSpace
 	}
 
-	cf.grow()
+	if !cf.grow() {
+		return MemAllocFailed
+	}
 	return cf.insertFp(params)
 }
 
 func (cf *CuckooFilter) Insert(data []byte) bool {
-	status := cf.insertFp(buildParams(data))
-	return status == cuckooInserted || status == cuckooAlreadyExist
+	status := cf.insertFp(cf.buildParams(data))
+	return status == Inserted || status == AlreadyExist
 }
 
-func (cf *CuckooFilter) Delete(data []byte) bool {
-	params := buildParams(data)
+// InsertWithStatus is like Insert, but tells why an item couldn't be added:
+// NoSpace when the filter doesn't grow (expansion 0) and is full, which
+// callers may want to handle as back-pressure, or MemAllocFailed when it
+// can't grow any further.
+func (cf *CuckooFilter) InsertWithStatus(data []byte) CuckooStatus {
+	return cf.insertFp(cf.buildParams(data))
+}
+
+// ErrFilterFull is returned by TryInsert when there's no room left for an item
+// and the filter can't grow: its expansion is 0, or growing would overflow.
+var ErrFilterFull = errors.New("filter is full")
+
+// TryInsert is like Insert, but returns ErrFilterFull instead of false when the
+// item couldn't be added, so that callers can tell a full filter apart from
+// other failures.
+func (cf *CuckooFilter) TryInsert(data []byte) (bool, error) {
+	switch status := cf.insertFp(cf.buildParams(data)); status {
+	case Inserted, AlreadyExist:
+		return true, nil
+	case NoSpace, MemAllocFailed:
+		return false, ErrFilterFull
+	default:
+		return false, fmt.Errorf("unexpected insert status %v", status)
+	}
+}
+
+// InsertNX inserts data only if it's not in the filter yet, like RedisBloom's
+// CF.ADDNX. It returns true if data was inserted.
+// Because of false positives, a new item may be reported as already present.
+func (cf *CuckooFilter) InsertNX(data []byte) bool {
+	params := cf.buildParams(data)
+	if cf.existFp(params) {
+		return false
+	}
+	return cf.insertFp(params) == Inserted
+}
+
+func (cf *CuckooFilter) deleteFp(params params) bool {
 	for i := int(cf.filterNum) - 1; i >= 0; i-- {
 		if cf.filters[i].delete(params) {
 			cf.itemNum--
 			cf.deleteNum++
-			if cf.filterNum > 1 && float64(cf.deleteNum) > float64(cf.itemNum)*0.1 {
-				cf.compact(false)
-			}
 			return true
 		}
 	}
 	return false
 }
 
+// compactIfNeeded compacts the filter once enough items have been deleted.
+func (cf *CuckooFilter) compactIfNeeded() {
+	if cf.filterNum > 1 && float64(cf.deleteNum) > float64(cf.itemNum)*0.1 {
+		cf.compact(false)
+	}
+}
+
+func (cf *CuckooFilter) Delete(data []byte) bool {
+	if cf.deleteFp(cf.buildParams(data)) {
+		cf.compactIfNeeded()
+		return true
+	}
+	return false
+}
+
+// DeleteAll removes every occurrence of data from the filter and returns how
+// many were removed.
+func (cf *CuckooFilter) DeleteAll(data []byte) uint64 {
+	params := cf.buildParams(data)
+	res := uint64(0)
+	for i := range cf.filters {
+		res += uint64(cf.filters[i].deleteAll(params))
+	}
+	if res > 0 {
+		cf.itemNum -= res
+		cf.deleteNum += res
+		cf.compactIfNeeded()
+	}
+	return res
+}
+
+// CheckAndDelete removes data if it's in the filter and reports whether it was.
+// It replaces Exist followed by Delete: the fingerprint is looked up and
+// removed in a single traversal of the sub-filters, which is what Delete does.
+func (cf *CuckooFilter) CheckAndDelete(data []byte) bool {
+	return cf.Delete(data)
+}
+
 func (cf *CuckooFilter) existFp(params params) bool {
 	for i := range cf.filters {
 		if cf.filters[i].find(params) {
@@ -290,11 +831,11 @@ func (cf *CuckooFilter) existFp(params params) bool {
 }
 
 func (cf *CuckooFilter) Exist(data []byte) bool {
-	return cf.existFp(buildParams(data))
+	return cf.existFp(cf.buildParams(data))
 }
 
 func (cf *CuckooFilter) Count(data []byte) uint64 {
-	params := buildParams(data)
+	params := cf.buildParams(data)
 	res := uint64(0)
 	for i := range cf.filters {
 		res += uint64(cf.filters[i].count(params))
@@ -309,45 +850,61 @@ const (
 )
 
 // Attempt to move a fingerprint from one bucket to another filter.
-func (cf *CuckooFilter) relocateSlot(bucket *bucket, filterIx uint16, bIx int, sIx int) int {
-	if bucket.slots[sIx] == nullFp {
+func (cf *CuckooFilter) relocateSlot(bucket bucket, filterIx uint16, bIx int, sIx int) int {
+	if bucket.get(sIx) == nullFp {
 		return relocEmpty
 	}
 
 	params := params{
 		h1: cuckooHash(bIx),
-		fp: bucket.slots[sIx],
+		fp: bucket.get(sIx),
 	}
 	params.h2 = altHash(params.fp, params.h1)
 
 	// Look at all the prior filters.
 	for i := 0; i < int(filterIx); i++ {
-		if slot, ok := cf.filters[i].findAvailableSlot(params); ok {
-			*slot = params.fp
-			bucket.slots[sIx] = nullFp
+		if b, slot, ok := cf.filters[i].findAvailableSlot(params); ok {
+			b.set(slot, params.fp)
+			bucket.set(sIx, nullFp)
 			return relocOk
 		}
 	}
 	return relocFail
 }
 
-func (cf *CuckooFilter) compactSingle(filterIx uint16) int {
+// compactBucket attempts to move the fingerprints of a bucket to older filters.
+func (cf *CuckooFilter) compactBucket(filterIx uint16, bIx int) int {
 	curFilter := &cf.filters[filterIx]
 	rv := relocOk
-
-	for bIx := 0; bIx < int(curFilter.bucketNum); bIx++ {
-		for sIx := 0; sIx < int(curFilter.bucketSize); sIx++ {
-			if cf.relocateSlot(&curFilter.buckets[bIx], filterIx, bIx, sIx) == relocFail {
-				rv = relocFail
-			}
+	for sIx := 0; sIx < int(curFilter.bucketSize); sIx++ {
+		if cf.relocateSlot(curFilter.bucket(uint64(bIx)), filterIx, bIx, sIx) == relocFail {
+			rv = relocFail
 		}
 	}
+	return rv
+}
 
-	// we free a filter only if it is the latest one
-	if rv == relocOk && filterIx == cf.filterNum-1 {
+// freeIfLast frees the filter once it has been emptied, if it is the latest one.
+func (cf *CuckooFilter) freeIfLast(filterIx uint16) {
+	if filterIx == cf.filterNum-1 {
+		cf.release(cf.filters[filterIx:])
 		cf.filters = cf.filters[:cf.filterNum-1]
 		cf.filterNum--
 	}
+}
+
+func (cf *CuckooFilter) compactSingle(filterIx uint16) int {
+	rv := relocOk
+	for bIx := 0; bIx < int(cf.filters[filterIx].bucketNum); bIx++ {
+		if cf.compactBucket(filterIx, bIx) == relocFail {
+			rv = relocFail
+		}
+	}
+
+	// we free a filter only if it is the latest one
+	if rv == relocOk {
+		cf.freeIfLast(filterIx)
+	}
 
 	return rv
 }
@@ -356,10 +913,302 @@ func (cf *CuckooFilter) compactSingle(filterIx uint16) int {
 // `cont` determines whether to continue iteration on other filters once a filter cannot be freed
 // and therefore following filter cannot be freed either.
 func (cf *CuckooFilter) compact(cont bool) {
-	for i := cf.filterNum - 1; i >= 1; i-- {
-		if cf.compactSingle(i) == relocFail && !cont {
+	cf.compactCursor = compactCursor{}
+	for i := int(cf.filterNum) - 1; i >= 1; i-- {
+		if cf.compactSingle(uint16(i)) == relocFail && !cont {
 			break
 		}
 	}
 	cf.deleteNum = 0
 }
+
+// Compact moves items to older sub-filters wherever possible and frees the
+// trailing sub-filters that end up empty.
+// Delete compacts the filter on its own once enough items have been deleted,
+// this forces a full pass regardless. It's O(total slots), so it should be run
+// off the hot path, e.g. from a periodic maintenance job.
+func (cf *CuckooFilter) Compact() {
+	cf.compact(true)
+}
+
+// compactCursor is the position of an incremental compaction pass.
+type compactCursor struct {
+	active   bool
+	filterIx uint16
+	bucketIx uint64
+	// failed is set once a fingerprint of the filter couldn't be moved.
+	failed bool
+	// adds is itemNum + deleteNum when the filter was started: it only
+	// changes if items were inserted since, or the filter was reset.
+	adds uint64
+}
+
+// CompactStep does the work of Compact incrementally: each call goes through
+// at most maxBuckets buckets, picking up where the previous one stopped, so a
+// large filter can be compacted a little at a time, e.g. on every tick of a
+// background loop. It returns true while the pass isn't finished; the next
+// call after it returned false starts a new pass.
+//
+// The filter can be used between steps. A trailing sub-filter is only freed if
+// no item was inserted while it was being compacted, since an insert may put a
+// fingerprint in a bucket the pass already went through.
+func (cf *CuckooFilter) CompactStep(maxBuckets int) bool {
+	cur := &cf.compactCursor
+	if !cur.active || cur.filterIx >= cf.filterNum {
+		if cf.filterNum <= 1 {
+			*cur = compactCursor{}
+			return false
+		}
+		*cur = compactCursor{active: true, filterIx: cf.filterNum - 1, adds: cf.itemNum + cf.deleteNum}
+	}
+
+	for n := max(maxBuckets, 1); n > 0 && cur.filterIx >= 1; n-- {
+		curFilter := &cf.filters[cur.filterIx]
+		if cur.bucketIx < curFilter.bucketNum {
+			if cf.compactBucket(cur.filterIx, int(cur.bucketIx)) == relocFail {
+				cur.failed = true
+			}
+			cur.bucketIx++
+		}
+		if cur.bucketIx < curFilter.bucketNum {
+			continue
+		}
+
+		if !cur.failed && cur.adds == cf.itemNum+cf.deleteNum {
+			cf.freeIfLast(cur.filterIx)
+		}
+		cur.filterIx--
+		cur.bucketIx = 0
+		cur.failed = false
+		cur.adds = cf.itemNum + cf.deleteNum
+	}
+
+	if cur.filterIx >= 1 {
+		return true
+	}
+	*cur = compactCursor{}
+	cf.deleteNum = 0
+	return false
+}
+
+// Rebuild moves all fingerprints to a fresh set of sub-filters sized for the
+// current number of items, dropping the space left by deletions.
+// Unlike Compact, which only frees trailing sub-filters that end up empty,
+// it can shrink the first sub-filter and so the whole filter, and later growth
+// starts from the smaller size.
+//
+// As with Merge, a fingerprint can't be moved to a sub-filter with more
+// buckets than the one it lives in, so the first sub-filter never gets larger
+// than it is. If the fingerprints can't be placed even then, an error is
+// returned and cf is left unchanged.
+func (cf *CuckooFilter) Rebuild() error {
+	if cf.filterNum == 0 {
+		return nil
+	}
+
+	bucketNum := next2N((cf.itemNum + uint64(cf.bucketSize) - 1) / uint64(cf.bucketSize))
+	for ; bucketNum <= cf.bucketNum; bucketNum *= 2 {
+		if res, ok := cf.rebuild(bucketNum); ok {
+			cf.release(cf.filters)
+			*cf = *res
+			return nil
+		}
+	}
+	return errors.New("not enough space to rebuild")
+}
+
+// rebuild returns a copy of cf whose first sub-filter has bucketNum buckets.
+func (cf *CuckooFilter) rebuild(bucketNum uint64) (*CuckooFilter, bool) {
+	res := *cf
+	res.bucketNum = bucketNum
+	res.filters = nil
+	res.filterNum = 0
+	res.itemNum = 0
+	res.deleteNum = 0
+	if !res.grow() {
+		return nil, false
+	}
+	if !res.mergeFrom(cf) {
+		res.release(res.filters)
+		return nil, false
+	}
+	return &res, true
+}
+
+// release gives the storage of filters back to the pool, if any, and clears
+// them. They must not be used afterwards.
+func (cf *CuckooFilter) release(filters []subCF) {
+	if cf.pool != nil {
+		for i := range filters {
+			cf.pool.Put(filters[i].data)
+		}
+	}
+	clear(filters)
+}
+
+// Release drops all sub-filters, giving their storage back to the pool the
+// filter was created with. The filter must not be used afterwards.
+func (cf *CuckooFilter) Release() {
+	cf.release(cf.filters)
+	*cf = CuckooFilter{}
+}
+
+// Reset removes all items from the filter.
+// Sub-filters added by growing are dropped, the first one is cleared in place,
+// so the filter ends up as if it was just created with the same parameters.
+func (cf *CuckooFilter) Reset() {
+	if cf.filterNum == 0 {
+		return
+	}
+
+	cf.release(cf.filters[1:])
+	cf.filters = cf.filters[:1]
+	cf.filterNum = 1
+	clear(cf.filters[0].data)
+	cf.itemNum = 0
+	cf.deleteNum = 0
+}
+
+// Copy returns a deep copy of the filter that shares no memory with it.
+// The copy of a filter created by NewMmap lives on the heap.
+func (cf *CuckooFilter) Copy() *CuckooFilter {
+	res := *cf
+	if _, ok := cf.pool.(mmapStorage); ok {
+		res.pool = nil
+	}
+	res.filters = make([]subCF, len(cf.filters))
+	for i := range cf.filters {
+		res.filters[i] = cf.filters[i]
+		res.filters[i].data = slices.Clone(cf.filters[i].data)
+	}
+	return &res
+}
+
+// Equal reports whether both filters have the same configuration, counters
+// and fingerprints, slot by slot.
+func (cf *CuckooFilter) Equal(other *CuckooFilter) bool {
+	if cf.bucketNum != other.bucketNum || cf.bucketSize != other.bucketSize ||
+		cf.itemNum != other.itemNum || cf.deleteNum != other.deleteNum ||
+		cf.maxIter != other.maxIter || cf.expansion != other.expansion ||
+		cf.filterNum != other.filterNum || cf.fpSize != other.fpSize ||
+		len(cf.filters) != len(other.filters) {
+		return false
+	}
+	for i := range cf.filters {
+		if cf.filters[i].bucketNum != other.filters[i].bucketNum ||
+			!slices.Equal(cf.filters[i].data, other.filters[i].data) {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge inserts all fingerprints of other into cf, growing cf as needed.
+// Both filters must use fingerprints of the same size and the same hasher.
+//
+// A stored fingerprint only carries the bits of its hash needed to index
+// the sub-filter it lives in, so it can only be moved to sub-filters of cf
+// that have no more buckets than its source sub-filter. This requires both
+// filters to have the same bucket size and expansion, and cf to have no more
+// buckets than other. With expansion > 1 grown sub-filters are larger than
+// the ones fingerprints come from, so the merge relies on free space in the
+// existing sub-filters. If a fingerprint can't be placed even after growing,
+// an error is returned and cf is left unchanged.
+func (cf *CuckooFilter) Merge(other *CuckooFilter) error {
+	if cf.bucketSize != other.bucketSize || cf.expansion != other.expansion ||
+		cf.fpSize != other.fpSize {
+		return errors.New("incompatible filters")
+	}
+	if !cf.sameHasher(other) {
+		return errors.New("filters use different hashers")
+	}
+	if cf.bucketNum > other.bucketNum {
+		return errors.New("filter has more buckets than the merged one")
+	}
+
+	res, ok := cf.clone()
+	if !ok {
+		return errors.New("not enough storage to merge")
+	}
+	if !res.mergeFrom(other) {
+		res.release(res.filters)
+		return errors.New("not enough space to merge")
+	}
+	cf.release(cf.filters)
+	*cf = *res
+	return nil
+}
+
+// clone is like Copy, but the sub-filters of the copy are taken from the pool
+// of cf, if any. It returns false if the pool can't provide them.
+func (cf *CuckooFilter) clone() (*CuckooFilter, bool) {
+	if cf.pool == nil {
+		return cf.Copy(), true
+	}
+	res := *cf
+	res.filters = make([]subCF, len(cf.filters))
+	for i := range cf.filters {
+		data := cf.pool.Get(len(cf.filters[i].data))
+		if len(data) != len(cf.filters[i].data) {
+			res.release(res.filters[:i])
+			return nil, false
+		}
+		copy(data, cf.filters[i].data)
+		res.filters[i] = cf.filters[i]
+		res.filters[i].data = data
+	}
+	return &res, true
+}
+
+// mergeFrom inserts all fingerprints of other into cf.
+func (cf *CuckooFilter) mergeFrom(other *CuckooFilter) bool {
+	for i := range other.filters {
+		src := &other.filters[i]
+		for bIx := uint64(0); bIx < src.bucketNum; bIx++ {
+			for sIx := 0; sIx < int(src.bucketSize); sIx++ {
+				fp := src.bucket(bIx).get(sIx)
+				if fp == nullFp {
+					continue
+				}
+				params := params{h1: cuckooHash(bIx), fp: fp}
+				params.h2 = altHash(params.fp, params.h1)
+				if !cf.mergeFp(params, src.bucketNum) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// mergeFp inserts a fingerprint whose hashes are only known modulo bucketNum,
+// using the sub-filters that have at most bucketNum buckets.
+func (cf *CuckooFilter) mergeFp(params params, bucketNum uint64) bool {
+	for {
+		last := -1
+		for i := int(cf.filterNum) - 1; i >= 0; i-- {
+			if cf.filters[i].bucketNum > bucketNum {
+				continue
+			}
+			if last < 0 {
+				last = i
+			}
+			if b, slot, ok := cf.filters[i].findAvailableSlot(params); ok {
+				b.set(slot, params.fp)
+				cf.itemNum++
+				return true
+			}
+		}
+
+		if last >= 0 && cf.evictAndInsert(uint16(last), params) == Inserted {
+			cf.itemNum++
+			return true
+		}
+		if cf.expansion == 0 {
+			return false
+		}
+		if n, ok := cf.bucketNumOf(cf.filterNum); !ok || n > bucketNum || !cf.grow() {
+			return false
+		}
+	}
+}
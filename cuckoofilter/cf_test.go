@@ -1,14 +1,14 @@
 package cuckoofilter
 
 import (
+	"hash/fnv"
+	"math"
 	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-const defaultBucketSize = 2
-
 func TestBasicOps(t *testing.T) {
 	cf := New(50, defaultBucketSize, 20, 1)
 	assert.Equal(t, cf.itemNum, uint64(0))
@@ -140,3 +140,761 @@ func TestBucketSize(t *testing.T) {
 		assert.Equal(t, cf.filterNum, ExpectedFilterNum[i])
 	}
 }
+
+func TestReset(t *testing.T) {
+	cap := 10000
+	cf := New(uint64(cap/8), defaultBucketSize, 50, 2)
+	fill(cf, cap)
+	assert.True(t, cf.Delete([]byte("0")))
+	assert.Greater(t, cf.filterNum, uint16(1))
+
+	first := &cf.filters[0].data[0]
+	cf.Reset()
+	assert.Equal(t, New(uint64(cap/8), defaultBucketSize, 50, 2), cf)
+	assert.Same(t, first, &cf.filters[0].data[0])
+	for i := 0; i < cap; i++ {
+		assert.False(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+
+	fill(cf, cap)
+	assert.Equal(t, cf.itemNum, uint64(cap))
+}
+
+func TestCopy(t *testing.T) {
+	cap := 1000
+	cf := New(uint64(cap/4), defaultBucketSize, 20, 1)
+	fill(cf, cap)
+	snapshot, _ := cf.MarshalBinary()
+
+	cp := cf.Copy()
+	assert.True(t, cf.Equal(cp))
+
+	for i := 0; i < cap; i++ {
+		assert.True(t, cp.Delete([]byte(strconv.Itoa(i))))
+	}
+	for i := cap; i < 3*cap; i++ {
+		assert.True(t, cp.Insert([]byte(strconv.Itoa(i))))
+	}
+
+	data, _ := cf.MarshalBinary()
+	assert.Equal(t, snapshot, data)
+	for i := 0; i < cap; i++ {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	cap := 5000
+	// Without expansion sub-filters have the same size, so growing always
+	// makes room. With expansion the filters need room in their first
+	// sub-filters, since fingerprints can't move to larger sub-filters.
+	for _, c := range []struct{ capacity, expansion uint16 }{{1000, 1}, {20000, 2}} {
+		cf1 := New(uint64(c.capacity), defaultBucketSize, 20, c.expansion)
+		cf2 := New(uint64(c.capacity), defaultBucketSize, 20, c.expansion)
+		for i := 0; i < cap; i++ {
+			assert.True(t, cf1.Insert([]byte(strconv.Itoa(i))))
+			assert.True(t, cf2.Insert([]byte(strconv.Itoa(cap+i))))
+		}
+
+		assert.NoError(t, cf1.Merge(cf2))
+		assert.Equal(t, uint64(2*cap), cf1.itemNum)
+		for i := 0; i < 2*cap; i++ {
+			assert.True(t, cf1.Exist([]byte(strconv.Itoa(i))))
+		}
+	}
+}
+
+func TestMergeIncompatible(t *testing.T) {
+	cf := New(1000, defaultBucketSize, 20, 1)
+	assert.Error(t, cf.Merge(New(1000, 4, 20, 1)))
+	assert.Error(t, cf.Merge(New(1000, defaultBucketSize, 20, 2)))
+	assert.Error(t, cf.Merge(New(100, defaultBucketSize, 20, 1)))
+	assert.Error(t, cf.Merge(NewWithHasher(1000, defaultBucketSize, 20, 1, XXHasher{})))
+
+	// Custom hashers must be the same value.
+	var calls1, calls2 int
+	h := fnvHasher{calls: &calls1}
+	custom := NewWithHasher(1000, defaultBucketSize, 20, 1, h)
+	assert.NoError(t, custom.Merge(NewWithHasher(1000, defaultBucketSize, 20, 1, h)))
+	assert.Error(t, custom.Merge(NewWithHasher(1000, defaultBucketSize, 20, 1, fnvHasher{calls: &calls2})))
+	assert.Error(t, custom.Merge(cf))
+
+	// Hashers that can't be compared are never the same.
+	seeded := NewWithHasher(1000, defaultBucketSize, 20, 1, seededHasher{1})
+	assert.Error(t, seeded.Merge(NewWithHasher(1000, defaultBucketSize, 20, 1, seededHasher{1})))
+
+	// Without expansion the merge can run out of space.
+	small := New(100, defaultBucketSize, 20, 0)
+	big := New(1000, defaultBucketSize, 20, 0)
+	fill(small, 100)
+	fill(big, 1000)
+	snapshot := small.Copy()
+	assert.Error(t, small.Merge(big))
+	assert.Equal(t, snapshot, small)
+}
+
+func TestInsertWithStatus(t *testing.T) {
+	cf := New(64, defaultBucketSize, 20, 0)
+	status := Inserted
+	i := 0
+	for ; status == Inserted; i++ {
+		status = cf.InsertWithStatus([]byte(strconv.Itoa(i)))
+	}
+	assert.Equal(t, NoSpace, status)
+	assert.Equal(t, uint64(i-1), cf.itemNum)
+	assert.Equal(t, "NoSpace", status.String())
+
+	cf = New(64, defaultBucketSize, 20, 1)
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, Inserted, cf.InsertWithStatus([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, "CuckooStatus(0)", CuckooStatus(0).String())
+}
+
+func TestTryInsert(t *testing.T) {
+	cf := New(64, defaultBucketSize, 20, 0)
+	var err error
+	ok := true
+	i := 0
+	for ; ok; i++ {
+		ok, err = cf.TryInsert([]byte(strconv.Itoa(i)))
+	}
+	assert.ErrorIs(t, err, ErrFilterFull)
+	assert.Equal(t, uint64(i-1), cf.itemNum)
+
+	cf = New(64, defaultBucketSize, 20, 1)
+	for i := 0; i < 1000; i++ {
+		ok, err := cf.TryInsert([]byte(strconv.Itoa(i)))
+		assert.True(t, ok)
+		assert.NoError(t, err)
+	}
+}
+
+func TestInsertNX(t *testing.T) {
+	cf := New(1000, defaultBucketSize, 20, 1)
+	k1 := []byte("key1")
+
+	assert.True(t, cf.InsertNX(k1))
+	assert.False(t, cf.InsertNX(k1))
+	assert.Equal(t, uint64(1), cf.Count(k1))
+	assert.Equal(t, uint64(1), cf.itemNum)
+
+	assert.True(t, cf.Insert(k1))
+	assert.False(t, cf.InsertNX(k1))
+	assert.Equal(t, uint64(2), cf.Count(k1))
+
+	assert.True(t, cf.Delete(k1))
+	assert.True(t, cf.Delete(k1))
+	assert.True(t, cf.InsertNX(k1))
+}
+
+func TestCompact(t *testing.T) {
+	cap := 10000
+	cf := New(uint64(cap/8), defaultBucketSize, 50, 2)
+	fill(cf, cap)
+	filterNum := cf.filterNum
+
+	// Too few deletions to trigger compaction on their own.
+	for i := cap / 10; i < cap; i += 20 {
+		assert.True(t, cf.Delete([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, filterNum, cf.filterNum)
+	assert.NotEqual(t, uint64(0), cf.deleteNum)
+
+	cf.Compact()
+	assert.Equal(t, uint64(0), cf.deleteNum)
+	assert.Equal(t, filterNum, cf.filterNum)
+
+	for i := cap / 10; i < cap; i++ {
+		if i%20 != 0 {
+			assert.True(t, cf.Delete([]byte(strconv.Itoa(i))))
+		}
+	}
+	cf.Compact()
+	assert.Equal(t, uint16(1), cf.filterNum)
+	for i := 0; i < cap/10; i++ {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+
+	(&CuckooFilter{}).Compact()
+}
+
+func TestCompactStep(t *testing.T) {
+	cap := 10000
+	cf := New(uint64(cap/8), defaultBucketSize, 50, 2)
+	fill(cf, cap)
+	assert.Greater(t, cf.filterNum, uint16(1))
+
+	// Delete without compacting on the way.
+	for i := cap / 10; i < cap; i++ {
+		assert.True(t, cf.deleteFp(cf.buildParams([]byte(strconv.Itoa(i)))))
+	}
+	want := cf.Copy()
+	want.Compact()
+
+	steps := 1
+	for cf.CompactStep(64) {
+		steps++
+	}
+	assert.Greater(t, steps, 1)
+	assert.Equal(t, uint16(1), cf.filterNum)
+	assert.Equal(t, uint64(0), cf.deleteNum)
+	assert.True(t, want.Equal(cf))
+	for i := 0; i < cap/10; i++ {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+	assert.False(t, cf.CompactStep(64))
+
+	// A sub-filter that got an insert during the pass isn't freed.
+	cf = New(uint64(cap/8), defaultBucketSize, 50, 2)
+	fill(cf, cap)
+	filterNum := cf.filterNum
+	for i := cap / 10; i < cap; i++ {
+		assert.True(t, cf.deleteFp(cf.buildParams([]byte(strconv.Itoa(i)))))
+	}
+	assert.True(t, cf.CompactStep(1))
+	assert.True(t, cf.Insert([]byte("new")))
+	for cf.CompactStep(1 << 20) {
+	}
+	assert.Equal(t, filterNum, cf.filterNum)
+	assert.True(t, cf.Exist([]byte("new")))
+	for cf.CompactStep(1 << 20) {
+	}
+	assert.Equal(t, uint16(1), cf.filterNum)
+	assert.True(t, cf.Exist([]byte("new")))
+
+	// Reset in the middle of a pass ends it.
+	cf = New(uint64(cap/8), defaultBucketSize, 50, 2)
+	fill(cf, cap)
+	assert.True(t, cf.CompactStep(1))
+	cf.Reset()
+	assert.False(t, cf.CompactStep(1))
+
+	assert.False(t, (&CuckooFilter{}).CompactStep(1))
+}
+
+func TestRebuild(t *testing.T) {
+	cap := 10000
+	cf := New(uint64(cap/8), defaultBucketSize, 50, 1)
+	fill(cf, cap)
+	filterNum := cf.filterNum
+
+	// Keep every 10th item, spread across all sub-filters.
+	for i := 0; i < cap; i++ {
+		if i%10 != 0 {
+			assert.True(t, cf.Delete([]byte(strconv.Itoa(i))))
+		}
+	}
+	compacted := cf.Copy()
+	compacted.Compact()
+
+	assert.NoError(t, cf.Rebuild())
+	assert.Equal(t, uint64(cap/10), cf.itemNum)
+	assert.Equal(t, uint64(0), cf.deleteNum)
+	assert.Less(t, cf.Capacity(), compacted.Capacity())
+	assert.Less(t, cf.bucketNum, uint64(cap/16))
+	assert.Less(t, cf.filterNum, filterNum)
+	for i := 0; i < cap; i += 10 {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+
+	// The rebuilt filter keeps working and grows from its new size.
+	for i := 0; i < cap; i++ {
+		if i%10 != 0 {
+			assert.True(t, cf.Insert([]byte(strconv.Itoa(i))))
+		}
+	}
+	for i := 0; i < cap; i++ {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+
+	empty := New(1000, defaultBucketSize, 20, 0)
+	assert.NoError(t, empty.Rebuild())
+	assert.Equal(t, uint64(1), empty.bucketNum)
+	assert.NoError(t, (&CuckooFilter{}).Rebuild())
+}
+
+func TestEqual(t *testing.T) {
+	cf1 := New(1000, defaultBucketSize, 20, 1)
+	cf2 := New(1000, defaultBucketSize, 20, 1)
+	assert.True(t, cf1.Equal(cf2))
+	assert.False(t, cf1.Equal(New(1000, defaultBucketSize, 10, 1)))
+	assert.False(t, cf1.Equal(New(1000, 4, 20, 1)))
+
+	fill(cf1, 3000)
+	fill(cf2, 3000)
+	assert.True(t, cf1.Equal(cf2))
+	assert.True(t, cf2.Equal(cf1))
+
+	cp := cf2.Copy()
+	cp.filters[0].data[0] ^= 1
+	assert.False(t, cf2.Equal(cp))
+
+	k := []byte("key")
+	cf1.Insert(k)
+	cf2.Insert(k)
+	assert.True(t, cf1.Equal(cf2))
+	assert.True(t, cf1.Delete(k))
+	assert.False(t, cf1.Equal(cf2))
+}
+
+func TestCheckAndDelete(t *testing.T) {
+	cap := 1000
+	cf := New(uint64(cap), defaultBucketSize, 20, 1)
+	fill(cf, cap)
+	for i := 0; i < cap; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.True(t, cf.CheckAndDelete(k))
+	}
+	assert.Equal(t, uint64(0), cf.itemNum)
+	assert.False(t, cf.CheckAndDelete([]byte("0")))
+}
+
+func TestDeleteAll(t *testing.T) {
+	cf := New(100, defaultBucketSize, 20, 1)
+	fill(cf, 100)
+	k := []byte("key")
+	for i := 0; i < 10; i++ {
+		assert.True(t, cf.Insert(k))
+	}
+	assert.Equal(t, uint64(10), cf.Count(k))
+	assert.Greater(t, cf.filterNum, uint16(1))
+
+	assert.Equal(t, uint64(10), cf.DeleteAll(k))
+	assert.Equal(t, uint64(0), cf.Count(k))
+	assert.Equal(t, uint64(100), cf.itemNum)
+	assert.Equal(t, uint64(0), cf.DeleteAll(k))
+	for i := 0; i < 100; i++ {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+}
+
+func TestCountSameBucket(t *testing.T) {
+	// With a single bucket both hashes of every item map to it.
+	cf := New(1, defaultBucketSize, 20, 0)
+	assert.Equal(t, uint64(1), cf.bucketNum)
+
+	k := []byte("key")
+	assert.True(t, cf.Insert(k))
+	assert.Equal(t, uint64(1), cf.Count(k))
+	assert.True(t, cf.Insert(k))
+	assert.Equal(t, uint64(2), cf.Count(k))
+	assert.False(t, cf.Insert(k))
+	assert.Equal(t, uint64(2), cf.DeleteAll(k))
+	assert.Equal(t, uint64(0), cf.Count(k))
+}
+
+func TestNext2N(t *testing.T) {
+	assert.Equal(t, uint64(1), next2N(0))
+	assert.Equal(t, uint64(1), next2N(1))
+	assert.Equal(t, uint64(2), next2N(2))
+	assert.Equal(t, uint64(4), next2N(3))
+	assert.Equal(t, uint64(1024), next2N(1000))
+	assert.Equal(t, uint64(1)<<63, next2N(1<<63))
+}
+
+func TestSmallParameters(t *testing.T) {
+	for _, c := range []struct {
+		capacity   uint64
+		bucketSize uint16
+	}{{0, defaultBucketSize}, {1, 4}, {3, 4}} {
+		cf := New(c.capacity, c.bucketSize, 20, 1)
+		assert.Equal(t, uint64(1), cf.bucketNum)
+		fill(cf, 100)
+		for i := 0; i < 100; i++ {
+			assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+		}
+	}
+
+	cf := New(4, defaultBucketSize, 20, 0)
+	assert.Equal(t, uint16(0), cf.expansion)
+	cf = New(4, defaultBucketSize, 20, 3)
+	assert.Equal(t, uint16(4), cf.expansion)
+}
+
+func TestEvictionRollback(t *testing.T) {
+	cf := New(1000, defaultBucketSize, 20, 0)
+	failures := 0
+	var inserted [][]byte
+	for i := 0; i < 2000; i++ {
+		k := []byte(strconv.Itoa(i))
+		snapshot := cf.Copy()
+		if cf.Insert(k) {
+			inserted = append(inserted, k)
+		} else {
+			assert.True(t, cf.Equal(snapshot))
+			failures++
+		}
+	}
+	assert.Greater(t, failures, 0)
+	assert.Equal(t, uint64(len(inserted)), cf.itemNum)
+	for _, k := range inserted {
+		assert.True(t, cf.Exist(k))
+	}
+}
+
+func TestFingerprint16(t *testing.T) {
+	cap := 10000
+	cf8 := New(uint64(cap), 4, 50, 1)
+	cf16 := New16(uint64(cap), 4, 50, 1)
+	fill(cf8, cap)
+	fill(cf16, cap)
+	assert.Equal(t, uint64(cap), cf16.itemNum)
+	assert.Equal(t, 2*cf8.filters[0].dataSize(), cf16.filters[0].dataSize())
+
+	fp8, fp16 := 0, 0
+	for i := cap; i < 11*cap; i++ {
+		k := []byte(strconv.Itoa(i))
+		if cf8.Exist(k) {
+			fp8++
+		}
+		if cf16.Exist(k) {
+			fp16++
+		}
+	}
+	// error_rate = 4 * 2 / 256 and 4 * 2 / 65536 at full load.
+	assert.Greater(t, fp8, 1000)
+	assert.Less(t, fp16, 30)
+
+	for i := 0; i < cap; i++ {
+		assert.True(t, cf16.Delete([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, uint64(0), cf16.itemNum)
+}
+
+type fnvHasher struct {
+	calls *int
+}
+
+func (h fnvHasher) Sum64(data []byte) uint64 {
+	*h.calls++
+	f := fnv.New64a()
+	f.Write(data)
+	return f.Sum64()
+}
+
+// seededHasher isn't comparable.
+type seededHasher []byte
+
+func (h seededHasher) Sum64(data []byte) uint64 {
+	f := fnv.New64a()
+	f.Write(h)
+	f.Write(data)
+	return f.Sum64()
+}
+
+func TestHasher(t *testing.T) {
+	calls := 0
+	h := fnvHasher{calls: &calls}
+	cf := NewWithHasher(1000, defaultBucketSize, 20, 1, h)
+	fill(cf, 1000)
+	assert.Equal(t, 1000, calls)
+	for i := 0; i < 1000; i++ {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, 2000, calls)
+
+	// The fingerprints differ from the ones of the default hasher.
+	def := New(1000, defaultBucketSize, 20, 1)
+	fill(def, 1000)
+	assert.NotEqual(t, cf.filters[0].data, def.filters[0].data)
+
+	// Decoding keeps the hasher of the receiver.
+	data, err := cf.MarshalBinary()
+	assert.NoError(t, err)
+	loaded := NewWithHasher(1, 1, 1, 0, h)
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.True(t, cf.Equal(loaded))
+	for i := 0; i < 1000; i++ {
+		assert.True(t, loaded.Delete([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, uint64(0), loaded.itemNum)
+}
+
+func TestRandomEviction(t *testing.T) {
+	fillUp := func(cf *CuckooFilter) int {
+		i := 0
+		for cf.Insert([]byte(strconv.Itoa(i))) {
+			i++
+		}
+		return i
+	}
+
+	cf := New(1<<12, 2, 100, 0)
+	_, ok := cf.EvictionSeed()
+	assert.False(t, ok)
+	n := fillUp(cf)
+
+	random := New(1<<12, 2, 100, 0)
+	random.SetRandomEviction(42)
+	seed, ok := random.EvictionSeed()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(42), seed)
+	m := fillUp(random)
+	assert.Greater(t, random.LoadFactor(), cf.LoadFactor()+0.05)
+
+	// Failed evictions are rolled back.
+	assert.Equal(t, uint64(m), random.itemNum)
+	for i := 0; i < m; i++ {
+		assert.True(t, random.Exist([]byte(strconv.Itoa(i))))
+	}
+	assert.Less(t, n, m)
+
+	// The same seed yields the same filter.
+	again := New(1<<12, 2, 100, 0)
+	again.SetRandomEviction(42)
+	assert.Equal(t, m, fillUp(again))
+	assert.True(t, random.Equal(again))
+
+	other := New(1<<12, 2, 100, 0)
+	other.SetRandomEviction(43)
+	fillUp(other)
+	assert.False(t, random.Equal(other))
+}
+
+func TestSetMaxIter(t *testing.T) {
+	cf := New(1<<12, 4, 20, 0)
+	cf.SetMaxIter(100)
+	assert.Equal(t, uint16(100), cf.Info().MaxIter)
+	assert.Equal(t, 100, cf.evictIter())
+
+	cf.SetMaxIter(20)
+	cf.SetAutoMaxIter(true)
+	assert.Equal(t, 20, cf.evictIter())
+	fill(cf, 1<<11)
+	assert.Equal(t, 20, cf.evictIter())
+	for i := 1 << 11; i < 3<<10; i++ {
+		cf.Insert([]byte(strconv.Itoa(i)))
+	}
+	assert.Equal(t, 0.75, cf.LoadFactor())
+	assert.Equal(t, 50, cf.evictIter())
+
+	// With auto-tuning the filter fills up further before it runs out of space.
+	fillUp := func(cf *CuckooFilter) {
+		for i := 0; cf.Insert([]byte(strconv.Itoa(i))); i++ {
+		}
+	}
+	fixed := New(1<<12, 4, 20, 0)
+	fillUp(fixed)
+	auto := New(1<<12, 4, 20, 0)
+	auto.SetAutoMaxIter(true)
+	fillUp(auto)
+	assert.Greater(t, auto.LoadFactor(), fixed.LoadFactor())
+
+	auto.SetAutoMaxIter(false)
+	assert.Equal(t, 20, auto.evictIter())
+}
+
+func TestNewChecked(t *testing.T) {
+	cf, err := NewChecked(1000, 4, 20, 2)
+	assert.NoError(t, err)
+	assert.True(t, cf.Equal(New(1000, 4, 20, 2)))
+
+	_, err = NewChecked(1000, 4, 20, 0)
+	assert.NoError(t, err)
+
+	for _, p := range []struct {
+		capacity   uint64
+		bucketSize uint16
+		maxIter    uint16
+		expansion  uint16
+	}{
+		{0, 4, 20, 1},
+		{1000, 0, 20, 1},
+		{1000, 4, 0, 1},
+		{1000, 4, 20, 40000},
+		{math.MaxUint64, 1, 20, 1},
+		{1 << 60, 1, 20, 16},
+		{1 << 62, 1, 20, 0},
+		{1 << 48, 4, 20, 0},
+	} {
+		cf, err := NewChecked(p.capacity, p.bucketSize, p.maxIter, p.expansion)
+		assert.Error(t, err, p)
+		assert.Nil(t, cf)
+	}
+}
+
+func TestNewTooLarge(t *testing.T) {
+	cf := New(1<<62, 1, 20, 0)
+	assert.Equal(t, uint16(0), cf.filterNum)
+
+	// A filter without sub-filters can't hold items, but doesn't panic.
+	for _, cf := range []*CuckooFilter{cf, New(1<<62, 1, 20, 2), New(1000, 0, 20, 1)} {
+		assert.False(t, cf.Insert([]byte("a")))
+		assert.Equal(t, MemAllocFailed, cf.InsertWithStatus([]byte("a")))
+		assert.False(t, cf.Exist([]byte("a")))
+		assert.False(t, cf.Delete([]byte("a")))
+		assert.Error(t, cf.Reserve(100))
+		assert.False(t, cf.CompactStep(10))
+		cf.Compact()
+		assert.NoError(t, cf.Rebuild())
+		cf.Reset()
+		assert.Equal(t, uint16(0), cf.filterNum)
+	}
+	_, err := NewWithOptions(1<<62, WithBucketSize(1))
+	assert.Error(t, err)
+	_, err = newFilter(1<<62, 1, 20, 0, 1, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestGrowOverflow(t *testing.T) {
+	cf := New(8, 2, 20, 1<<15)
+	n, ok := cf.bucketNumOf(4)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1<<62), n)
+	_, ok = cf.bucketNumOf(5)
+	assert.False(t, ok)
+
+	// Pretend the next sub-filter is too large to be addressed.
+	cf = New(8, 2, 20, 4)
+	cf.bucketNum = 1 << 62
+	i := 0
+	for cf.insertFp(cf.buildParams([]byte(strconv.Itoa(i)))) == Inserted {
+		i++
+	}
+	assert.Equal(t, MemAllocFailed, cf.insertFp(cf.buildParams([]byte(strconv.Itoa(i)))))
+	assert.False(t, cf.Insert([]byte(strconv.Itoa(i))))
+	assert.Equal(t, uint16(1), cf.filterNum)
+	assert.Equal(t, uint64(i), cf.itemNum)
+}
+
+func TestReserve(t *testing.T) {
+	cf := New(1000, defaultBucketSize, 20, 2)
+	assert.NoError(t, cf.Reserve(500))
+	assert.Equal(t, uint16(1), cf.filterNum)
+
+	assert.NoError(t, cf.Reserve(20000))
+	assert.GreaterOrEqual(t, cf.Capacity(), uint64(20000))
+	filterNum := cf.filterNum
+	assert.Equal(t, uint16(5), filterNum)
+	for i := 0; i < 20000; i++ {
+		assert.Equal(t, Inserted, cf.InsertWithStatus([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, filterNum, cf.filterNum)
+	for i := 0; i < 20000; i++ {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+
+	// Growing the same way leads to the same sub-filters.
+	grown := New(1000, defaultBucketSize, 20, 2)
+	fill(grown, 16000)
+	assert.Equal(t, grown.filterNum, cf.filterNum)
+	assert.Equal(t, grown.Capacity(), cf.Capacity())
+
+	// Loading the reserved number of items never grows the filter.
+	for _, bucketSize := range []uint16{1, 2, 3, 4, 8} {
+		for _, expansion := range []uint16{1, 2, 4} {
+			for _, n := range []int{100, 3000, 50000} {
+				cf := New(1000, bucketSize, defaultMaxIter, expansion)
+				assert.NoError(t, cf.Reserve(uint64(n)))
+				filterNum := cf.filterNum
+				fill(cf, n)
+				assert.Equal(t, filterNum, cf.filterNum, "bucketSize %d, expansion %d, %d items", bucketSize, expansion, n)
+			}
+		}
+	}
+
+	cf = New(1000, defaultBucketSize, 20, 0)
+	assert.Error(t, cf.Reserve(2000))
+	assert.Equal(t, uint16(1), cf.filterNum)
+
+	cf = New(8, 2, 20, 4)
+	cf.bucketNum = 1 << 62
+	assert.Error(t, cf.Reserve(math.MaxUint64))
+	assert.Equal(t, uint16(1), cf.filterNum)
+	assert.Len(t, cf.filters, 1)
+
+	assert.Error(t, (&CuckooFilter{}).Reserve(1))
+}
+
+// benchFilter returns a filter of 10M items and keys of which about half were
+// inserted.
+func benchFilter() (*CuckooFilter, [][]byte) {
+	const n = 10_000_000
+	cf := New(n, 4, 20, 1)
+	fill(cf, n)
+	keys := make([][]byte, 1<<16)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i * (2*n/len(keys) + 1)))
+	}
+	return cf, keys
+}
+
+func BenchmarkExist(b *testing.B) {
+	cf, keys := benchFilter()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cf.Exist(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	cf, keys := benchFilter()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cf.Count(keys[i%len(keys)])
+	}
+}
+
+func TestNewForFPR(t *testing.T) {
+	for _, c := range []struct {
+		fpr        float64
+		bucketSize uint16
+		fpSize     uint8
+	}{
+		{0.5, 4, 1},
+		{0.03125, 4, 1},
+		{0.02, 2, 1},
+		{1.0 / 128, 1, 1},
+		{0.005, 4, 2},
+		{0.0001, 3, 2},
+		{1e-9, 1, 2},
+		{0, 1, 2},
+		{math.NaN(), 1, 2},
+	} {
+		cf := NewForFPR(1000, c.fpr)
+		assert.Equal(t, c.bucketSize, cf.bucketSize, c.fpr)
+		assert.Equal(t, c.fpSize, cf.fpSize, c.fpr)
+		assert.Equal(t, uint16(defaultMaxIter), cf.maxIter)
+		assert.GreaterOrEqual(t, cf.bucketNum*uint64(cf.bucketSize), uint64(1000))
+	}
+
+	// The measured rate of a filter that doesn't grow meets the target.
+	cf := NewForFPR(10000, 0.01)
+	fill(cf, 4000)
+	assert.Equal(t, uint16(1), cf.filterNum)
+	fp := 0
+	for i := 0; i < 100000; i++ {
+		if cf.Exist([]byte("absent" + strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	assert.Less(t, float64(fp)/100000, 0.01)
+}
+
+func TestEmptyItem(t *testing.T) {
+	cf := New(1000, defaultBucketSize, 20, 1)
+	// Pin the hash of the empty item, which dumps depend on.
+	assert.Equal(t, params{h1: 0, h2: altHash(1, 0), fp: 1}, cf.buildParams(nil))
+	assert.Equal(t, cf.buildParams(nil), cf.buildParams([]byte{}))
+
+	assert.False(t, cf.Exist(nil))
+	assert.True(t, cf.Insert(nil))
+	assert.True(t, cf.Exist([]byte{}))
+	assert.Equal(t, fingerprint(1), cf.filters[0].bucket(0).get(0))
+	assert.True(t, cf.Insert([]byte{}))
+	assert.Equal(t, uint64(2), cf.Count(nil))
+	assert.True(t, cf.Delete([]byte{}))
+	assert.Equal(t, uint64(1), cf.Count([]byte{}))
+	assert.True(t, cf.Delete(nil))
+	assert.False(t, cf.Exist(nil))
+	assert.Equal(t, uint64(0), cf.itemNum)
+
+	// The empty item doesn't match others.
+	cf.Insert(nil)
+	fill(cf, 100)
+	for i := 0; i < 100; i++ {
+		assert.True(t, cf.Exist([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, uint64(1), cf.Count(nil))
+}
@@ -0,0 +1,174 @@
+package cuckoofilter
+
+import (
+	"io"
+	"sync"
+)
+
+// ConcurrentCuckooFilter is a CuckooFilter safe for concurrent use.
+//
+// Lookups (Exist, Count, the info and encoding methods) take a read lock and
+// run in parallel. Everything that may change the filter takes the write lock:
+// an insert can evict fingerprints across buckets or grow the filter, and a
+// delete can compact it, both of which rewrite filters and filterNum.
+// Writers are therefore serialized and block all readers while they run;
+// an insert that grows the filter holds the lock while the new sub-filter is
+// allocated. For write-heavy workloads, see ShardedCuckooFilter.
+//
+// The Hasher of the filter, if any, must be safe for concurrent use.
+type ConcurrentCuckooFilter struct {
+	mu sync.RWMutex
+	cf *CuckooFilter
+}
+
+// NewConcurrent wraps cf. It must not be used directly afterwards.
+func NewConcurrent(cf *CuckooFilter) *ConcurrentCuckooFilter {
+	return &ConcurrentCuckooFilter{cf: cf}
+}
+
+func (c *ConcurrentCuckooFilter) Insert(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.Insert(data)
+}
+
+func (c *ConcurrentCuckooFilter) InsertNX(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.InsertNX(data)
+}
+
+func (c *ConcurrentCuckooFilter) InsertMany(items [][]byte) []bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.InsertMany(items)
+}
+
+func (c *ConcurrentCuckooFilter) Delete(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.Delete(data)
+}
+
+func (c *ConcurrentCuckooFilter) DeleteAll(data []byte) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.DeleteAll(data)
+}
+
+func (c *ConcurrentCuckooFilter) DeleteMany(items [][]byte) []bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.DeleteMany(items)
+}
+
+func (c *ConcurrentCuckooFilter) CheckAndDelete(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.CheckAndDelete(data)
+}
+
+func (c *ConcurrentCuckooFilter) Exist(data []byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.Exist(data)
+}
+
+func (c *ConcurrentCuckooFilter) ExistMany(items [][]byte) []bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.ExistMany(items)
+}
+
+func (c *ConcurrentCuckooFilter) Count(data []byte) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.Count(data)
+}
+
+func (c *ConcurrentCuckooFilter) Compact() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cf.Compact()
+}
+
+// CompactStep holds the lock for a single step only, so that a large filter
+// can be compacted without blocking other callers for the whole pass.
+func (c *ConcurrentCuckooFilter) CompactStep(maxBuckets int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.CompactStep(maxBuckets)
+}
+
+func (c *ConcurrentCuckooFilter) Reserve(expectedItems uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.Reserve(expectedItems)
+}
+
+func (c *ConcurrentCuckooFilter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cf.Reset()
+}
+
+// Snapshot returns a deep copy of the wrapped filter.
+func (c *ConcurrentCuckooFilter) Snapshot() *CuckooFilter {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.Copy()
+}
+
+func (c *ConcurrentCuckooFilter) Info() CuckooInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.Info()
+}
+
+func (c *ConcurrentCuckooFilter) LoadFactor() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.LoadFactor()
+}
+
+func (c *ConcurrentCuckooFilter) String() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.String()
+}
+
+func (c *ConcurrentCuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.WriteTo(w)
+}
+
+func (c *ConcurrentCuckooFilter) ReadFrom(r io.Reader) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.ReadFrom(r)
+}
+
+func (c *ConcurrentCuckooFilter) MarshalBinary() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.MarshalBinary()
+}
+
+func (c *ConcurrentCuckooFilter) UnmarshalBinary(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.UnmarshalBinary(data)
+}
+
+func (c *ConcurrentCuckooFilter) ScanDump(iter uint64) (uint64, []byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cf.ScanDump(iter)
+}
+
+func (c *ConcurrentCuckooFilter) LoadChunk(iter uint64, chunk []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cf.LoadChunk(iter, chunk)
+}
@@ -0,0 +1,50 @@
+package cuckoofilter
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrent(t *testing.T) {
+	const workers, n = 8, 2000
+	c := NewConcurrent(New(1000, defaultBucketSize, 20, 2))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w * n; i < (w+1)*n; i++ {
+				k := []byte(strconv.Itoa(i))
+				assert.True(t, c.Insert(k))
+				assert.True(t, c.Exist(k))
+				c.Count(k)
+				c.LoadFactor()
+			}
+		}()
+	}
+	wg.Wait()
+
+	info := c.Info()
+	assert.Equal(t, uint64(workers*n), info.ItemNum)
+	assert.Greater(t, info.FilterNum, uint16(1))
+	for i := 0; i < workers*n; i++ {
+		assert.True(t, c.Exist([]byte(strconv.Itoa(i))))
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w * n; i < (w+1)*n; i++ {
+				assert.True(t, c.Delete([]byte(strconv.Itoa(i))))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(0), c.Info().ItemNum)
+	assert.True(t, c.Snapshot().Equal(c.cf))
+}
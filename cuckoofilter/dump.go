@@ -0,0 +1,659 @@
+package cuckoofilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/fukua95/pds/internal/hashing"
+)
+
+// The dump format mirrors RedisBloom's CF.SCANDUMP / CF.LOADCHUNK:
+// the first chunk is a packed little-endian header, the following chunks are
+// raw slices of the sub-filters' fingerprint data laid out one after another.
+//
+//	numItems   uint64
+//	numBuckets uint64
+//	numDeletes uint64
+//	numFilters uint64
+//	bucketSize uint16
+//	maxIter    uint16
+//	expansion  uint16
+//
+// RedisBloom only has 8-bit fingerprints and MurmurHash64A, other filters
+// extend the header with the fingerprint size in bytes and the id of the
+// hasher (see hashing.IDMurmur). The extended header is always used by the
+// other formats.
+//
+//	fpSize     uint8
+//	hasherID   uint8
+//
+// Dumps made before the hasher id was added end after fpSize, and were all
+// hashed with MurmurHash64A.
+const (
+	headerSize    = 38
+	fpHeaderSize  = headerSize + 1
+	extHeaderSize = headerSize + 2
+)
+
+// maxChunkSize bounds the size of a single chunk returned by ScanDump.
+const maxChunkSize = 16 * 1024 * 1024
+
+// encodeHeader returns the extended header.
+func (cf *CuckooFilter) encodeHeader() []byte {
+	buf := make([]byte, extHeaderSize)
+	binary.LittleEndian.PutUint64(buf[0:], cf.itemNum)
+	binary.LittleEndian.PutUint64(buf[8:], cf.bucketNum)
+	binary.LittleEndian.PutUint64(buf[16:], cf.deleteNum)
+	binary.LittleEndian.PutUint64(buf[24:], uint64(cf.filterNum))
+	binary.LittleEndian.PutUint16(buf[32:], cf.bucketSize)
+	binary.LittleEndian.PutUint16(buf[34:], cf.maxIter)
+	binary.LittleEndian.PutUint16(buf[36:], cf.expansion)
+	buf[38] = cf.fpSize
+	buf[39] = cf.hasherID()
+	return buf
+}
+
+// maxDecodeSize bounds the size of the fingerprint data of a filter decoded
+// from a header alone, by LoadChunk or by ReadFrom into a filter with a pool,
+// before the data itself is at hand: a corrupt or hostile header mustn't be
+// able to exhaust memory, which the runtime can't recover from.
+const maxDecodeSize = 1 << 36
+
+// decodeHeader returns a filter with the configuration described by the
+// header and empty sub-filters, with the hasher and pool of cf.
+// The RedisBloom header, the extended one and the one without hasher id are
+// accepted. See restore for maxSize and lazy.
+func (cf *CuckooFilter) decodeHeader(buf []byte, maxSize uint64, lazy bool) (*CuckooFilter, error) {
+	if len(buf) != headerSize && len(buf) != fpHeaderSize && len(buf) != extHeaderSize {
+		return nil, errors.New("invalid header size")
+	}
+	filterNum := binary.LittleEndian.Uint64(buf[24:])
+	h := CuckooFilter{
+		itemNum:    binary.LittleEndian.Uint64(buf[0:]),
+		bucketNum:  binary.LittleEndian.Uint64(buf[8:]),
+		deleteNum:  binary.LittleEndian.Uint64(buf[16:]),
+		bucketSize: binary.LittleEndian.Uint16(buf[32:]),
+		maxIter:    binary.LittleEndian.Uint16(buf[34:]),
+		expansion:  binary.LittleEndian.Uint16(buf[36:]),
+		fpSize:     1,
+	}
+	hasherID := hashing.IDMurmur
+	if len(buf) >= fpHeaderSize {
+		h.fpSize = buf[38]
+	}
+	if len(buf) == extHeaderSize {
+		hasherID = buf[39]
+	}
+	return cf.restore(h, filterNum, hasherID, maxSize, lazy)
+}
+
+// restore returns a filter with the configuration and counters of h, the
+// hasher identified by hasherID and filterNum empty sub-filters. The hasher
+// and pool of cf are kept, and cf itself is left unchanged.
+//
+// The header is rejected if the fingerprint data of the sub-filters would take
+// more than maxSize bytes. If lazy is set and cf has no pool, the sub-filters
+// are created without their data, which the caller allocates as it reads it.
+func (cf *CuckooFilter) restore(h CuckooFilter, filterNum uint64, hasherID uint8, maxSize uint64, lazy bool) (*CuckooFilter, error) {
+	if h.bucketNum == 0 || h.bucketSize == 0 || filterNum == 0 || filterNum > math.MaxUint16 {
+		return nil, errors.New("invalid header")
+	}
+	// Alternate buckets are only found back if the number of buckets of every
+	// sub-filter is a power of 2.
+	if bits.OnesCount64(h.bucketNum) != 1 || (h.expansion != 0 && bits.OnesCount16(h.expansion) != 1) {
+		return nil, errors.New("invalid header: sizes must be powers of 2")
+	}
+	if h.expansion == 0 && filterNum > 1 {
+		return nil, errors.New("invalid header")
+	}
+	if h.fpSize != 1 && h.fpSize != 2 {
+		return nil, errors.New("invalid fingerprint size")
+	}
+
+	res := &CuckooFilter{
+		bucketNum:  h.bucketNum,
+		bucketSize: h.bucketSize,
+		itemNum:    h.itemNum,
+		deleteNum:  h.deleteNum,
+		maxIter:    h.maxIter,
+		expansion:  h.expansion,
+		fpSize:     h.fpSize,
+		hasher:     cf.hasher,
+		pool:       cf.pool,
+	}
+	if size, ok := res.dataSizeOf(filterNum); !ok || size > maxSize {
+		return nil, errors.New("invalid header: filter too large")
+	}
+	if err := res.setHasherID(hasherID); err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < filterNum; i++ {
+		if lazy && res.pool == nil {
+			bucketNum, _ := res.bucketNumOf(uint16(i))
+			res.filters = append(res.filters, subCF{bucketNum: bucketNum, bucketSize: res.bucketSize, fpSize: res.fpSize})
+			res.filterNum++
+			continue
+		}
+		if !res.grow() {
+			res.release(res.filters)
+			return nil, errors.New("invalid header")
+		}
+	}
+	return res, nil
+}
+
+// dataSizeOf returns the size in bytes of the fingerprint data of the first
+// filterNum sub-filters of cf, and false if it overflows.
+func (cf *CuckooFilter) dataSizeOf(filterNum uint64) (uint64, bool) {
+	total := uint64(0)
+	bucketNum := cf.bucketNum
+	for i := uint64(0); i < filterNum; i++ {
+		if i > 0 {
+			hi, lo := bits.Mul64(bucketNum, uint64(cf.expansion))
+			if hi != 0 {
+				return 0, false
+			}
+			bucketNum = lo
+		}
+		hi, size := bits.Mul64(bucketNum, uint64(cf.bucketSize)*uint64(cf.fpSize))
+		if hi != 0 || size > math.MaxInt {
+			return 0, false
+		}
+		var carry uint64
+		if total, carry = bits.Add64(total, size, 0); carry != 0 || total > math.MaxInt {
+			return 0, false
+		}
+	}
+	return total, true
+}
+
+// readAt copies the fingerprint data starting at byte offset `off` into buf,
+// returning the number of bytes copied.
+func (s *subCF) readAt(buf []byte, off uint64) int {
+	return copy(buf, s.data[off:])
+}
+
+// writeAt copies buf into the fingerprint data starting at byte offset `off`,
+// returning the number of bytes copied.
+func (s *subCF) writeAt(buf []byte, off uint64) int {
+	return copy(s.data[off:], buf)
+}
+
+// slotNum returns the number of fingerprint slots.
+func (s *subCF) slotNum() uint64 {
+	return s.bucketNum * uint64(s.bucketSize)
+}
+
+// dataSize returns the size of the fingerprint data in bytes.
+func (s *subCF) dataSize() uint64 {
+	return s.slotNum() * uint64(s.fpSize)
+}
+
+// ScanDump returns the filter in chunks, following the semantics of
+// RedisBloom's CF.SCANDUMP.
+// Start with iter 0, which returns the header, and keep calling with the
+// returned nextIter. The dump is complete when nextIter is 0.
+// The filter must not be modified between calls.
+func (cf *CuckooFilter) ScanDump(iter uint64) (nextIter uint64, chunk []byte, err error) {
+	return cf.scanDump(iter, maxChunkSize)
+}
+
+func (cf *CuckooFilter) scanDump(iter uint64, limit uint64) (uint64, []byte, error) {
+	if iter == 0 {
+		header := cf.encodeHeader()
+		if cf.fpSize == 1 && cf.hasherID() == hashing.IDMurmur {
+			header = header[:headerSize]
+		}
+		return 1, header, nil
+	}
+
+	// Find the sub-filter the position falls in.
+	offset := iter - 1
+	for i := range cf.filters {
+		size := cf.filters[i].dataSize()
+		if offset >= size {
+			offset -= size
+			continue
+		}
+		chunk := make([]byte, min(limit, size-offset))
+		cf.filters[i].readAt(chunk, offset)
+		return iter + uint64(len(chunk)), chunk, nil
+	}
+	return 0, nil, nil
+}
+
+// LoadChunk restores a filter from the chunks produced by ScanDump,
+// following the semantics of RedisBloom's CF.LOADCHUNK.
+// Chunks must be passed in order together with the iterator ScanDump returned
+// alongside them; the first one (iter 1) resets cf to the dumped header.
+// cf is left unchanged if the header is invalid, or describes more than
+// 64 GiB of fingerprints.
+func (cf *CuckooFilter) LoadChunk(iter uint64, chunk []byte) error {
+	if iter == 1 {
+		res, err := cf.decodeHeader(chunk, maxDecodeSize, false)
+		if err != nil {
+			return err
+		}
+		cf.release(cf.filters)
+		*cf = *res
+		return nil
+	}
+	if cf.filterNum == 0 {
+		return errors.New("header chunk must be loaded first")
+	}
+	if iter < uint64(len(chunk))+1 {
+		return errors.New("invalid iterator")
+	}
+
+	offset := iter - uint64(len(chunk)) - 1
+	for i := range cf.filters {
+		size := cf.filters[i].dataSize()
+		if offset >= size {
+			offset -= size
+			continue
+		}
+		if uint64(len(chunk)) > size-offset {
+			return errors.New("chunk exceeds filter size")
+		}
+		cf.filters[i].writeAt(chunk, offset)
+		return nil
+	}
+	return errors.New("invalid iterator")
+}
+
+// binaryVersion is the version of the MarshalBinary format:
+// a version byte followed by the extended header, all fingerprint data and
+// a little-endian CRC32 (IEEE) of everything before it.
+//
+// Version 3 dumps, whose header has no hasher id, are still read.
+const (
+	binaryVersion   = 4
+	binaryVersionV3 = 3
+)
+
+const checksumSize = 4
+
+// streamBufSize is the size of the buffer used by WriteTo and ReadFrom.
+const streamBufSize = 64 * 1024
+
+// ErrCorruptData is returned when decoding data whose checksum doesn't match.
+var ErrCorruptData = errors.New("corrupt data")
+
+func (cf *CuckooFilter) binarySize() int {
+	size := 1 + extHeaderSize + checksumSize
+	for i := range cf.filters {
+		size += int(cf.filters[i].dataSize())
+	}
+	return size
+}
+
+// WriteTo implements io.WriterTo.
+// It writes the same format as MarshalBinary, one sub-filter after another,
+// without materializing the whole encoding in memory.
+func (cf *CuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	total := int64(0)
+	crc := crc32.NewIEEE()
+	write := func(p []byte) error {
+		crc.Write(p)
+		n, err := w.Write(p)
+		total += int64(n)
+		return err
+	}
+
+	if err := write(append([]byte{binaryVersion}, cf.encodeHeader()...)); err != nil {
+		return total, err
+	}
+
+	buf := make([]byte, streamBufSize)
+	for i := range cf.filters {
+		size := cf.filters[i].dataSize()
+		for off := uint64(0); off < size; {
+			chunk := buf[:min(uint64(len(buf)), size-off)]
+			cf.filters[i].readAt(chunk, off)
+			if err := write(chunk); err != nil {
+				return total, err
+			}
+			off += uint64(len(chunk))
+		}
+	}
+
+	n, err := w.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32()))
+	total += int64(n)
+	return total, err
+}
+
+// ReadFrom implements io.ReaderFrom.
+// It reads a filter written by WriteTo or MarshalBinary, consuming exactly
+// the bytes of the encoding, and replaces cf with it.
+// ErrCorruptData is returned if the data doesn't match its checksum.
+func (cf *CuckooFilter) ReadFrom(r io.Reader) (int64, error) {
+	return cf.readFrom(r, maxDecodeSize)
+}
+
+// readFrom is ReadFrom for a filter whose fingerprint data takes at most
+// maxSize bytes, if cf has a pool. Without a pool, the sub-filters are
+// allocated as their data is read, so that a corrupt header can't allocate
+// more memory than r holds.
+func (cf *CuckooFilter) readFrom(r io.Reader, maxSize uint64) (int64, error) {
+	if cf.pool == nil {
+		maxSize = math.MaxInt
+	}
+	total := int64(0)
+	crc := crc32.NewIEEE()
+	read := func(p []byte) error {
+		n, err := io.ReadFull(r, p)
+		crc.Write(p[:n])
+		total += int64(n)
+		return err
+	}
+
+	version := make([]byte, 1)
+	if err := read(version); err != nil {
+		return total, err
+	}
+	var head []byte
+	switch version[0] {
+	case binaryVersion:
+		head = make([]byte, extHeaderSize)
+	case binaryVersionV3:
+		head = make([]byte, fpHeaderSize)
+	default:
+		return total, errors.New("unsupported version")
+	}
+	if err := read(head); err != nil {
+		return total, err
+	}
+
+	res, err := cf.decodeHeader(head, maxSize, true)
+	if err != nil {
+		return total, fmt.Errorf("%w: %v", ErrCorruptData, err)
+	}
+
+	buf := make([]byte, streamBufSize)
+	for i := range res.filters {
+		f := &res.filters[i]
+		size := f.dataSize()
+		for off := uint64(0); off < size; {
+			chunk := buf[:min(uint64(len(buf)), size-off)]
+			if err := read(chunk); err != nil {
+				res.release(res.filters)
+				return total, err
+			}
+			if uint64(len(f.data)) < size {
+				f.data = append(f.data, chunk...)
+			} else {
+				f.writeAt(chunk, off)
+			}
+			off += uint64(len(chunk))
+		}
+	}
+
+	sum := crc.Sum32()
+	tail := make([]byte, checksumSize)
+	if err := read(tail); err != nil {
+		res.release(res.filters)
+		return total, err
+	}
+	if binary.LittleEndian.Uint32(tail) != sum {
+		res.release(res.filters)
+		return total, ErrCorruptData
+	}
+
+	cf.release(cf.filters)
+	*cf = *res
+	return total, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (cf *CuckooFilter) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, cf.binarySize()))
+	if _, err := cf.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The checksum is verified before anything is decoded, and ErrCorruptData is
+// returned if it doesn't match.
+func (cf *CuckooFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 1+fpHeaderSize+checksumSize {
+		return errors.New("data too short")
+	}
+	payload, tail := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(tail) {
+		return ErrCorruptData
+	}
+
+	res := CuckooFilter{hasher: cf.hasher, pool: cf.pool}
+	r := bytes.NewReader(data)
+	if _, err := res.readFrom(r, uint64(len(data))); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		res.release(res.filters)
+		return errors.New("trailing data")
+	}
+
+	cf.release(cf.filters)
+	*cf = res
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder.
+// A zero-value filter is encoded as empty data.
+func (cf *CuckooFilter) GobEncode() ([]byte, error) {
+	if cf.filterNum == 0 {
+		return []byte{}, nil
+	}
+	return cf.MarshalBinary()
+}
+
+// GobDecode implements gob.GobDecoder.
+// Empty data decodes to a filter with the default parameters.
+func (cf *CuckooFilter) GobDecode(data []byte) error {
+	if len(data) == 0 {
+		res, err := newFilter(defaultCapacity, defaultBucketSize, defaultMaxIter, defaultExpansion, 1, cf.hasher, cf.pool)
+		if err != nil {
+			return err
+		}
+		cf.release(cf.filters)
+		*cf = *res
+		return nil
+	}
+	return cf.UnmarshalBinary(data)
+}
+
+// compactVersion is the version of the MarshalCompact format. It never
+// overlaps binaryVersion so the two formats can't be mistaken for each other.
+//
+// After the version byte and the extended header, each sub-filter is encoded as
+// a bitmap of occupied slots followed by the fingerprints of those slots.
+// Like the binary format, it ends with a CRC32 of everything before it.
+//
+// As with the binary format, version 0x83 dumps have no hasher id.
+const (
+	compactVersion   = 0x84
+	compactVersionV3 = 0x83
+)
+
+// MarshalCompact encodes the filter in a format that skips empty slots.
+// It is slower than MarshalBinary but much smaller for sparsely filled filters.
+func (cf *CuckooFilter) MarshalCompact() ([]byte, error) {
+	buf := append([]byte{compactVersion}, cf.encodeHeader()...)
+	for i := range cf.filters {
+		f := &cf.filters[i]
+		bitmap := make([]byte, (f.slotNum()+7)/8)
+		var fps []byte
+
+		off := uint64(0)
+		for bIx := uint64(0); bIx < f.bucketNum; bIx++ {
+			b := f.bucket(bIx)
+			for sIx := 0; sIx < b.size(); sIx++ {
+				if b.get(sIx) != nullFp {
+					bitmap[off/8] |= 1 << (off % 8)
+					fps = append(fps, b.slots[sIx*int(b.fpSize):(sIx+1)*int(b.fpSize)]...)
+				}
+				off++
+			}
+		}
+		buf = append(buf, bitmap...)
+		buf = append(buf, fps...)
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), nil
+}
+
+// UnmarshalCompact decodes a filter encoded by MarshalCompact.
+func (cf *CuckooFilter) UnmarshalCompact(data []byte) error {
+	if len(data) < 1+fpHeaderSize+checksumSize {
+		return errors.New("data too short")
+	}
+	size := extHeaderSize
+	switch data[0] {
+	case compactVersion:
+	case compactVersionV3:
+		size = fpHeaderSize
+	default:
+		return errors.New("unsupported version")
+	}
+	if len(data) < 1+size+checksumSize {
+		return errors.New("data too short")
+	}
+	data, tail := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(tail) {
+		return ErrCorruptData
+	}
+
+	// Every slot takes a bit of the bitmap, and at most 2 bytes.
+	res, err := cf.decodeHeader(data[1:1+size], 16*uint64(len(data)), false)
+	if err != nil {
+		return err
+	}
+	if err := res.decodeCompact(data[1+size:]); err != nil {
+		res.release(res.filters)
+		return err
+	}
+
+	cf.release(cf.filters)
+	*cf = *res
+	return nil
+}
+
+// decodeCompact decodes the sub-filters of MarshalCompact into cf.
+func (cf *CuckooFilter) decodeCompact(data []byte) error {
+	for i := range cf.filters {
+		f := &cf.filters[i]
+		bitmapSize := (f.slotNum() + 7) / 8
+		if uint64(len(data)) < bitmapSize {
+			return errors.New("data too short")
+		}
+		bitmap, fps := data[:bitmapSize], data[bitmapSize:]
+
+		off := uint64(0)
+		for bIx := uint64(0); bIx < f.bucketNum; bIx++ {
+			b := f.bucket(bIx)
+			for sIx := 0; sIx < b.size(); sIx++ {
+				if bitmap[off/8]&(1<<(off%8)) != 0 {
+					if len(fps) < int(b.fpSize) {
+						return errors.New("data too short")
+					}
+					copy(b.slots[sIx*int(b.fpSize):], fps[:b.fpSize])
+					fps = fps[b.fpSize:]
+					if b.get(sIx) == nullFp {
+						return errors.New("invalid fingerprint data")
+					}
+				}
+				off++
+			}
+		}
+		data = fps
+	}
+	if len(data) != 0 {
+		return errors.New("trailing data")
+	}
+	return nil
+}
+
+type jsonSubFilter struct {
+	BucketNum uint64 `json:"bucketNum"`
+	Data      []byte `json:"data"`
+}
+
+type jsonFilter struct {
+	BucketNum  uint64          `json:"bucketNum"`
+	BucketSize uint16          `json:"bucketSize"`
+	FpSize     uint8           `json:"fingerprintSize"`
+	MaxIter    uint16          `json:"maxIter"`
+	Expansion  uint16          `json:"expansion"`
+	ItemNum    uint64          `json:"itemNum"`
+	DeleteNum  uint64          `json:"deleteNum"`
+	HasherID   uint8           `json:"hasherId,omitempty"`
+	Filters    []jsonSubFilter `json:"filters"`
+}
+
+// MarshalJSON implements json.Marshaler.
+// The fingerprint data of each sub-filter is base64 encoded.
+func (cf *CuckooFilter) MarshalJSON() ([]byte, error) {
+	v := jsonFilter{
+		BucketNum:  cf.bucketNum,
+		BucketSize: cf.bucketSize,
+		FpSize:     cf.fpSize,
+		MaxIter:    cf.maxIter,
+		Expansion:  cf.expansion,
+		ItemNum:    cf.itemNum,
+		DeleteNum:  cf.deleteNum,
+		HasherID:   cf.hasherID(),
+		Filters:    make([]jsonSubFilter, len(cf.filters)),
+	}
+	for i := range cf.filters {
+		data := make([]byte, cf.filters[i].dataSize())
+		cf.filters[i].readAt(data, 0)
+		v.Filters[i] = jsonSubFilter{BucketNum: cf.filters[i].bucketNum, Data: data}
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (cf *CuckooFilter) UnmarshalJSON(data []byte) error {
+	var v jsonFilter
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	h := CuckooFilter{
+		bucketNum:  v.BucketNum,
+		bucketSize: v.BucketSize,
+		fpSize:     max(v.FpSize, 1),
+		itemNum:    v.ItemNum,
+		deleteNum:  v.DeleteNum,
+		maxIter:    v.MaxIter,
+		expansion:  v.Expansion,
+	}
+	size := uint64(0)
+	for i := range v.Filters {
+		size += uint64(len(v.Filters[i].Data))
+	}
+	res, err := cf.restore(h, uint64(len(v.Filters)), v.HasherID, size, false)
+	if err != nil {
+		return err
+	}
+	for i := range res.filters {
+		if v.Filters[i].BucketNum != res.filters[i].bucketNum ||
+			uint64(len(v.Filters[i].Data)) != res.filters[i].dataSize() {
+			res.release(res.filters)
+			return errors.New("sub-filter size mismatch")
+		}
+		res.filters[i].writeAt(v.Filters[i].Data, 0)
+	}
+
+	cf.release(cf.filters)
+	*cf = *res
+	return nil
+}
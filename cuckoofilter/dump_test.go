@@ -0,0 +1,324 @@
+package cuckoofilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanDumpLoadChunk(t *testing.T) {
+	cap := 1000
+	cf := New(uint64(cap/4), defaultBucketSize, 20, 1)
+	fill(cf, cap)
+	assert.Greater(t, cf.filterNum, uint16(1))
+
+	iter, header, err := cf.ScanDump(0)
+	assert.NoError(t, err)
+	assert.Equal(t, iter, uint64(1))
+	assert.Equal(t, len(header), headerSize)
+
+	loaded := &CuckooFilter{}
+	assert.NoError(t, loaded.LoadChunk(iter, header))
+
+	// Use a small chunk limit so that chunks span buckets and sub-filters.
+	chunks := 0
+	for {
+		var chunk []byte
+		iter, chunk, err = cf.scanDump(iter, 100)
+		assert.NoError(t, err)
+		if iter == 0 {
+			break
+		}
+		assert.LessOrEqual(t, len(chunk), 100)
+		assert.NoError(t, loaded.LoadChunk(iter, chunk))
+		chunks++
+	}
+	assert.Greater(t, chunks, int(cf.filterNum))
+
+	assert.Equal(t, cf.itemNum, loaded.itemNum)
+	assert.Equal(t, cf.filterNum, loaded.filterNum)
+	assert.Equal(t, cf.filters, loaded.filters)
+	for i := 0; i < cap; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.Equal(t, cf.Count(k), loaded.Count(k))
+	}
+}
+
+func TestLoadChunkInvalid(t *testing.T) {
+	cf := &CuckooFilter{}
+	assert.Error(t, cf.LoadChunk(1, []byte{1, 2, 3}))
+	assert.Error(t, cf.LoadChunk(2, []byte{1}))
+
+	src := New(10, defaultBucketSize, 20, 1)
+	_, header, _ := src.ScanDump(0)
+	assert.NoError(t, cf.LoadChunk(1, header))
+	assert.Error(t, cf.LoadChunk(1000, []byte{1}))
+	assert.Error(t, cf.LoadChunk(2, make([]byte, 100)))
+}
+
+func TestDecodeInvalidHeader(t *testing.T) {
+	src := New(1000, defaultBucketSize, 20, 2)
+	fill(src, 500)
+	header := src.encodeHeader()
+	withHeader := func(f func(h []byte)) []byte {
+		h := bytes.Clone(header)
+		f(h)
+		return h
+	}
+	// binary returns the MarshalBinary encoding of an empty filter with the
+	// header, with a valid checksum.
+	binaryOf := func(h []byte) []byte {
+		data := append([]byte{binaryVersion}, h...)
+		return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+	}
+
+	for _, h := range [][]byte{
+		withHeader(func(h []byte) { binary.LittleEndian.PutUint64(h[8:], 3) }),
+		withHeader(func(h []byte) { binary.LittleEndian.PutUint16(h[36:], 3) }),
+		withHeader(func(h []byte) { binary.LittleEndian.PutUint64(h[8:], 1<<40) }),
+		withHeader(func(h []byte) { binary.LittleEndian.PutUint64(h[24:], 100) }),
+	} {
+		cf := src.Copy()
+		assert.Error(t, cf.LoadChunk(1, h))
+		assert.True(t, src.Equal(cf))
+		assert.Error(t, cf.UnmarshalBinary(binaryOf(h)))
+		assert.True(t, src.Equal(cf))
+		_, err := cf.ReadFrom(bytes.NewReader(binaryOf(h)))
+		assert.Error(t, err)
+		assert.True(t, src.Equal(cf))
+	}
+
+	// A bucket count that's not a power of 2 would lose items.
+	var v map[string]any
+	data, _ := json.Marshal(src)
+	assert.NoError(t, json.Unmarshal(data, &v))
+	v["bucketNum"] = 3
+	data, _ = json.Marshal(v)
+	cf := src.Copy()
+	assert.Error(t, json.Unmarshal(data, cf))
+	assert.True(t, src.Equal(cf))
+
+	// The header is valid, but the data of the sub-filters is missing: the
+	// stream ends before they are allocated.
+	h := withHeader(func(h []byte) { binary.LittleEndian.PutUint64(h[8:], 1<<40) })
+	_, err := cf.ReadFrom(bytes.NewReader(append([]byte{binaryVersion}, h...)))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestMarshalBinary(t *testing.T) {
+	cap := 1000
+	cf := New(uint64(cap/4), defaultBucketSize, 20, 1)
+	fill(cf, cap)
+
+	data, err := cf.MarshalBinary()
+	assert.NoError(t, err)
+	loaded := &CuckooFilter{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, cf, loaded)
+
+	assert.Error(t, loaded.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, loaded.UnmarshalBinary(append(data, 0)))
+}
+
+func TestGob(t *testing.T) {
+	type holder struct {
+		Name   string
+		Filter *CuckooFilter
+	}
+
+	cf := New(100, defaultBucketSize, 20, 1)
+	fill(cf, 500)
+
+	var buf bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&buf).Encode(holder{Name: "cf", Filter: cf}))
+	var h holder
+	assert.NoError(t, gob.NewDecoder(&buf).Decode(&h))
+	assert.Equal(t, "cf", h.Name)
+	assert.Equal(t, cf, h.Filter)
+
+	// A zero-value filter decodes to a usable one.
+	buf.Reset()
+	assert.NoError(t, gob.NewEncoder(&buf).Encode(holder{Filter: &CuckooFilter{}}))
+	h = holder{}
+	assert.NoError(t, gob.NewDecoder(&buf).Decode(&h))
+	assert.True(t, h.Filter.Insert([]byte("key")))
+	assert.True(t, h.Filter.Exist([]byte("key")))
+}
+
+func TestJSON(t *testing.T) {
+	cf := New(100, defaultBucketSize, 20, 1)
+	fill(cf, 500)
+
+	data, err := json.Marshal(cf)
+	assert.NoError(t, err)
+	loaded := &CuckooFilter{}
+	assert.NoError(t, json.Unmarshal(data, loaded))
+	assert.Equal(t, cf, loaded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"bucketNum":64,"bucketSize":2,"filters":[{"bucketNum":64,"data":"AAAA"}]}`), loaded))
+	assert.Error(t, json.Unmarshal([]byte(`{"bucketNum":64,"bucketSize":2,"filters":[]}`), loaded))
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	cap := 100000
+	cf := New(uint64(cap/4), defaultBucketSize, 20, 1)
+	fill(cf, cap)
+
+	var buf bytes.Buffer
+	n, err := cf.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, n, int64(buf.Len()))
+	data, _ := cf.MarshalBinary()
+	assert.Equal(t, data, buf.Bytes())
+
+	// Filters can be streamed back to back.
+	_, err = cf.WriteTo(&buf)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		loaded := &CuckooFilter{}
+		m, err := loaded.ReadFrom(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, n, m)
+		assert.Equal(t, cf, loaded)
+	}
+
+	loaded := &CuckooFilter{}
+	_, err = loaded.ReadFrom(bytes.NewReader(data[:len(data)/2]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestMarshalCompact(t *testing.T) {
+	cf := New(10000, defaultBucketSize, 20, 1)
+	fill(cf, 500)
+	assert.True(t, cf.Delete([]byte("0")))
+
+	data, err := cf.MarshalCompact()
+	assert.NoError(t, err)
+	raw, _ := cf.MarshalBinary()
+	assert.Less(t, len(data), len(raw)/4)
+
+	loaded := &CuckooFilter{}
+	assert.NoError(t, loaded.UnmarshalCompact(data))
+	assert.Equal(t, cf, loaded)
+
+	assert.Error(t, loaded.UnmarshalCompact(raw))
+	assert.Error(t, loaded.UnmarshalBinary(data))
+	assert.Error(t, loaded.UnmarshalCompact(data[:len(data)-1]))
+	assert.Error(t, loaded.UnmarshalCompact(append(data, 1)))
+
+	data[len(data)/2] ^= 0x10
+	assert.ErrorIs(t, loaded.UnmarshalCompact(data), ErrCorruptData)
+}
+
+func TestChecksum(t *testing.T) {
+	cf := New(100, defaultBucketSize, 20, 1)
+	fill(cf, 500)
+	data, err := cf.MarshalBinary()
+	assert.NoError(t, err)
+
+	for _, pos := range []int{1, 10, headerSize + 1, len(data) / 2, len(data) - 1} {
+		corrupted := bytes.Clone(data)
+		corrupted[pos] ^= 0x01
+		loaded := &CuckooFilter{}
+		assert.ErrorIs(t, loaded.UnmarshalBinary(corrupted), ErrCorruptData)
+		assert.Equal(t, &CuckooFilter{}, loaded)
+	}
+}
+
+func TestDumpFingerprint16(t *testing.T) {
+	cf := New16(100, defaultBucketSize, 20, 2)
+	fill(cf, 500)
+
+	data, err := cf.MarshalBinary()
+	assert.NoError(t, err)
+	loaded := &CuckooFilter{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.True(t, cf.Equal(loaded))
+
+	data, err = cf.MarshalCompact()
+	assert.NoError(t, err)
+	loaded = &CuckooFilter{}
+	assert.NoError(t, loaded.UnmarshalCompact(data))
+	assert.True(t, cf.Equal(loaded))
+
+	data, err = json.Marshal(cf)
+	assert.NoError(t, err)
+	loaded = &CuckooFilter{}
+	assert.NoError(t, json.Unmarshal(data, loaded))
+	assert.True(t, cf.Equal(loaded))
+
+	iter, header, err := cf.ScanDump(0)
+	assert.NoError(t, err)
+	assert.Len(t, header, extHeaderSize)
+	loaded = &CuckooFilter{}
+	assert.NoError(t, loaded.LoadChunk(iter, header))
+	for {
+		var chunk []byte
+		iter, chunk, err = cf.scanDump(iter, 33)
+		assert.NoError(t, err)
+		if iter == 0 {
+			break
+		}
+		assert.NoError(t, loaded.LoadChunk(iter, chunk))
+	}
+	assert.True(t, cf.Equal(loaded))
+}
+
+func TestDumpHasher(t *testing.T) {
+	cf := NewWithHasher(1000, 2, 20, 1, XXHasher{})
+	fill(cf, 500)
+	exist := func(loaded *CuckooFilter) {
+		assert.Equal(t, XXHasher{}, loaded.hasher)
+		for i := 0; i < 500; i++ {
+			assert.True(t, loaded.Exist([]byte(strconv.Itoa(i))))
+		}
+	}
+
+	data, err := cf.MarshalBinary()
+	assert.NoError(t, err)
+	loaded := &CuckooFilter{}
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	exist(loaded)
+
+	data, _ = cf.MarshalCompact()
+	loaded = &CuckooFilter{}
+	assert.NoError(t, loaded.UnmarshalCompact(data))
+	exist(loaded)
+
+	data, _ = json.Marshal(cf)
+	loaded = &CuckooFilter{}
+	assert.NoError(t, json.Unmarshal(data, loaded))
+	exist(loaded)
+
+	// Unlike RedisBloom's, the header of a ScanDump carries the hasher.
+	_, header, _ := cf.ScanDump(0)
+	assert.Len(t, header, extHeaderSize)
+	loaded = &CuckooFilter{}
+	assert.NoError(t, loaded.LoadChunk(1, header))
+	assert.Equal(t, XXHasher{}, loaded.hasher)
+
+	// A version 3 dump has no hasher id and was hashed with MurmurHash64A.
+	def := New(1000, 2, 20, 1)
+	fill(def, 500)
+	data, _ = def.MarshalBinary()
+	v3 := append([]byte{binaryVersionV3}, data[1:1+fpHeaderSize]...)
+	v3 = append(v3, data[1+extHeaderSize:len(data)-checksumSize]...)
+	v3 = binary.LittleEndian.AppendUint32(v3, crc32.ChecksumIEEE(v3))
+	assert.NoError(t, loaded.UnmarshalBinary(v3))
+	assert.Nil(t, loaded.hasher)
+	assert.True(t, def.Equal(loaded))
+
+	// A custom hasher must be given by the receiver.
+	calls := 0
+	custom := NewWithHasher(1000, 2, 20, 1, fnvHasher{calls: &calls})
+	data, _ = custom.MarshalBinary()
+	assert.Error(t, (&CuckooFilter{}).UnmarshalBinary(data))
+	assert.NoError(t, NewWithHasher(1, 1, 1, 0, fnvHasher{calls: &calls}).UnmarshalBinary(data))
+}
@@ -0,0 +1,147 @@
+package cuckoofilter
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// CuckooInfo describes a filter, like RedisBloom's CF.INFO.
+type CuckooInfo struct {
+	// Size is the memory used by the filter in bytes.
+	Size uint64
+	// BucketNum is the number of buckets of the first sub-filter.
+	BucketNum  uint64
+	BucketSize uint16
+	FilterNum  uint16
+	ItemNum    uint64
+	// DeleteNum is the number of deletions since the last compaction.
+	DeleteNum uint64
+	MaxIter   uint16
+	Expansion uint16
+}
+
+// Info returns the configuration and counters of the filter.
+// It doesn't scan the buckets.
+func (cf *CuckooFilter) Info() CuckooInfo {
+	return CuckooInfo{
+		Size:       cf.SizeInBytes(),
+		BucketNum:  cf.bucketNum,
+		BucketSize: cf.bucketSize,
+		FilterNum:  cf.filterNum,
+		ItemNum:    cf.itemNum,
+		DeleteNum:  cf.deleteNum,
+		MaxIter:    cf.maxIter,
+		Expansion:  cf.expansion,
+	}
+}
+
+// Capacity returns the number of fingerprint slots across all sub-filters.
+// Unlike the capacity passed to New, it accounts for growth.
+func (cf *CuckooFilter) Capacity() uint64 {
+	slots := uint64(0)
+	for i := range cf.filters {
+		slots += cf.filters[i].slotNum()
+	}
+	return slots
+}
+
+// FreeSlots returns the number of empty fingerprint slots across all sub-filters.
+// It's 0 if the item count exceeds the slots, as a decoded one may.
+func (cf *CuckooFilter) FreeSlots() uint64 {
+	slots := cf.Capacity()
+	if cf.itemNum > slots {
+		return 0
+	}
+	return slots - cf.itemNum
+}
+
+// LoadFactor returns the fraction of slots in use across all sub-filters.
+func (cf *CuckooFilter) LoadFactor() float64 {
+	slots := cf.Capacity()
+	if slots == 0 {
+		return 0
+	}
+	return float64(cf.itemNum) / float64(slots)
+}
+
+// OccupancyHistogram returns the number of buckets with exactly i occupied
+// slots at index i, from 0 to the bucket size, across all sub-filters.
+// Many full buckets next to many empty ones point to long eviction chains,
+// which a larger bucket size or capacity would avoid.
+func (cf *CuckooFilter) OccupancyHistogram() []uint64 {
+	res := make([]uint64, cf.bucketSize+1)
+	for i := range cf.filters {
+		for j := uint64(0); j < cf.filters[i].bucketNum; j++ {
+			b := cf.filters[i].bucket(j)
+			res[cf.bucketSize-b.count(nullFp)]++
+		}
+	}
+	return res
+}
+
+// SizeInBytes returns the memory used by the filter: the filter itself,
+// the sub-filters and their fingerprint slots.
+func (cf *CuckooFilter) SizeInBytes() uint64 {
+	size := uint64(unsafe.Sizeof(*cf))
+	size += uint64(cap(cf.filters)) * uint64(unsafe.Sizeof(subCF{}))
+	for i := range cf.filters {
+		size += cf.filters[i].dataSize()
+	}
+	return size
+}
+
+// stringMaxSlots is the number of slots up to which String dumps the buckets.
+const stringMaxSlots = 256
+
+// String returns the configuration and counters of the filter. For small
+// filters it's followed by the non-empty buckets of every sub-filter, with
+// empty slots printed as "-".
+func (cf *CuckooFilter) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CuckooFilter{bucketNum: %d, bucketSize: %d, maxIter: %d, expansion: %d, "+
+		"filterNum: %d, itemNum: %d, deleteNum: %d, loadFactor: %.3f}",
+		cf.bucketNum, cf.bucketSize, cf.maxIter, cf.expansion,
+		cf.filterNum, cf.itemNum, cf.deleteNum, cf.LoadFactor())
+	if cf.Capacity() > stringMaxSlots {
+		return sb.String()
+	}
+
+	for i := range cf.filters {
+		fmt.Fprintf(&sb, "\nfilter %d:", i)
+		for j := uint64(0); j < cf.filters[i].bucketNum; j++ {
+			b := cf.filters[i].bucket(j)
+			if b.count(nullFp) == cf.bucketSize {
+				continue
+			}
+			fmt.Fprintf(&sb, "\n  %d:", j)
+			for sIx := 0; sIx < b.size(); sIx++ {
+				if fp := b.get(sIx); fp == nullFp {
+					sb.WriteString(" -")
+				} else {
+					fmt.Fprintf(&sb, " %d", fp)
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+// ForEachFingerprint calls fn for each stored fingerprint, with the position
+// of its slot, sub-filter by sub-filter and bucket by bucket, until fn returns
+// false. Fingerprints are uint16 so that those of New16 filters fit; the
+// fingerprints of other filters are below 256.
+// fn must not modify the filter.
+func (cf *CuckooFilter) ForEachFingerprint(fn func(filterIx uint16, bucketIx uint64, slotIx int, fp uint16) bool) {
+	for i := range cf.filters {
+		f := &cf.filters[i]
+		for bIx := uint64(0); bIx < f.bucketNum; bIx++ {
+			b := f.bucket(bIx)
+			for sIx := 0; sIx < b.size(); sIx++ {
+				if fp := b.get(sIx); fp != nullFp && !fn(uint16(i), bIx, sIx, uint16(fp)) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package cuckoofilter
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfo(t *testing.T) {
+	cf := New(1000, defaultBucketSize, 20, 2)
+	info := cf.Info()
+	assert.Equal(t, uint64(512), info.BucketNum)
+	assert.Equal(t, uint16(defaultBucketSize), info.BucketSize)
+	assert.Equal(t, uint16(1), info.FilterNum)
+	assert.Equal(t, uint64(0), info.ItemNum)
+	assert.Equal(t, uint16(20), info.MaxIter)
+	assert.Equal(t, uint16(2), info.Expansion)
+	assert.Greater(t, info.Size, uint64(1024))
+
+	fill(cf, 5000)
+	assert.True(t, cf.Delete([]byte("1")))
+	info2 := cf.Info()
+	assert.Equal(t, uint64(4999), info2.ItemNum)
+	assert.Equal(t, uint64(1), info2.DeleteNum)
+	assert.Greater(t, info2.FilterNum, uint16(1))
+	assert.Greater(t, info2.Size, info.Size)
+}
+
+func TestLoadFactor(t *testing.T) {
+	cf := New(1024, defaultBucketSize, 20, 1)
+	assert.Equal(t, 0.0, cf.LoadFactor())
+
+	fill(cf, 512)
+	assert.Equal(t, 0.5, cf.LoadFactor())
+
+	fill(cf, 2048)
+	assert.Greater(t, cf.filterNum, uint16(1))
+	assert.Equal(t, float64(2560)/float64(1024*int(cf.filterNum)), cf.LoadFactor())
+
+	assert.Equal(t, 0.0, (&CuckooFilter{}).LoadFactor())
+}
+
+func TestCapacity(t *testing.T) {
+	cf := New(1000, 4, 20, 2)
+	assert.Equal(t, uint64(1024), cf.Capacity())
+	assert.Equal(t, uint64(1024), cf.FreeSlots())
+
+	fill(cf, 500)
+	assert.Equal(t, uint16(1), cf.filterNum)
+	assert.Equal(t, uint64(524), cf.FreeSlots())
+
+	fill(cf, 1000)
+	assert.Equal(t, uint16(2), cf.filterNum)
+	assert.Equal(t, uint64(3072), cf.Capacity())
+	assert.Equal(t, uint64(1572), cf.FreeSlots())
+
+	free := uint64(0)
+	for i := range cf.filters {
+		for j := uint64(0); j < cf.filters[i].bucketNum; j++ {
+			free += uint64(cf.filters[i].bucket(j).count(nullFp))
+		}
+	}
+	assert.Equal(t, free, cf.FreeSlots())
+
+	// A decoded item count may exceed the slots.
+	cf.itemNum = cf.Capacity() + 1
+	assert.Equal(t, uint64(0), cf.FreeSlots())
+}
+
+func TestOccupancyHistogram(t *testing.T) {
+	cf := New(1024, 4, 20, 1)
+	assert.Equal(t, []uint64{256, 0, 0, 0, 0}, cf.OccupancyHistogram())
+
+	fill(cf, 600)
+	h := cf.OccupancyHistogram()
+	assert.Len(t, h, 5)
+	buckets, items := uint64(0), uint64(0)
+	for i, n := range h {
+		buckets += n
+		items += uint64(i) * n
+	}
+	assert.Equal(t, uint64(256), buckets)
+	assert.Equal(t, cf.itemNum, items)
+
+	fill(cf, 2000)
+	h = cf.OccupancyHistogram()
+	buckets = 0
+	for _, n := range h {
+		buckets += n
+	}
+	assert.Equal(t, uint64(256)*uint64(cf.filterNum), buckets)
+
+	assert.Equal(t, []uint64{0}, (&CuckooFilter{}).OccupancyHistogram())
+}
+
+func TestSizeInBytes(t *testing.T) {
+	cf := New(1024, defaultBucketSize, 20, 1)
+	// The CuckooFilter, 40 bytes of subCF and 1024 slots.
+	fs := uint64(unsafe.Sizeof(*cf))
+	assert.Equal(t, fs+40+1024, cf.SizeInBytes())
+	assert.Equal(t, cf.SizeInBytes(), cf.Info().Size)
+
+	fill(cf, 4096)
+	n := uint64(cf.filterNum)
+	assert.Greater(t, n, uint64(1))
+	assert.Equal(t, fs+uint64(cap(cf.filters))*40+n*1024, cf.SizeInBytes())
+
+	cf = New16(1024, defaultBucketSize, 20, 1)
+	assert.Equal(t, fs+40+2048, cf.SizeInBytes())
+}
+
+func TestString(t *testing.T) {
+	cf := New(8, defaultBucketSize, 20, 1)
+	assert.Equal(t, "CuckooFilter{bucketNum: 4, bucketSize: 2, maxIter: 20, expansion: 1, "+
+		"filterNum: 1, itemNum: 0, deleteNum: 0, loadFactor: 0.000}\nfilter 0:", cf.String())
+
+	cf.filters[0].bucket(1).set(1, 7)
+	cf.filters[0].bucket(3).set(0, 42)
+	cf.filters[0].bucket(3).set(1, 255)
+	cf.itemNum = 3
+	assert.Equal(t, "CuckooFilter{bucketNum: 4, bucketSize: 2, maxIter: 20, expansion: 1, "+
+		"filterNum: 1, itemNum: 3, deleteNum: 0, loadFactor: 0.375}\nfilter 0:\n  1: - 7\n  3: 42 255", cf.String())
+
+	cf = New(1000, defaultBucketSize, 20, 1)
+	fill(cf, 256)
+	assert.Equal(t, "CuckooFilter{bucketNum: 512, bucketSize: 2, maxIter: 20, expansion: 1, "+
+		"filterNum: 1, itemNum: 256, deleteNum: 0, loadFactor: 0.250}", cf.String())
+}
+
+func TestForEachFingerprint(t *testing.T) {
+	for _, cf := range []*CuckooFilter{New(1000, 2, 20, 2), New16(1000, 2, 20, 2)} {
+		fill(cf, 3000)
+		assert.Greater(t, cf.filterNum, uint16(1))
+
+		visited := uint64(0)
+		cf.ForEachFingerprint(func(filterIx uint16, bucketIx uint64, slotIx int, fp uint16) bool {
+			assert.Equal(t, fingerprint(fp), cf.filters[filterIx].bucket(bucketIx).get(slotIx))
+			assert.NotEqual(t, nullFp, fingerprint(fp))
+			visited++
+			return true
+		})
+		assert.Equal(t, cf.itemNum, visited)
+
+		visited = 0
+		cf.ForEachFingerprint(func(uint16, uint64, int, uint16) bool {
+			visited++
+			return visited < 10
+		})
+		assert.Equal(t, uint64(10), visited)
+	}
+}
@@ -0,0 +1,96 @@
+// Package metrics exports the counters of cuckoo filters as Prometheus metrics.
+package metrics
+
+import (
+	"sync"
+
+	"github.com/fukua95/pds/cuckoofilter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Filter is what the collector reads from a filter. Both *cuckoofilter.CuckooFilter
+// and *cuckoofilter.ConcurrentCuckooFilter implement it; use the latter if the
+// filter is modified while it may be scraped.
+type Filter interface {
+	Info() cuckoofilter.CuckooInfo
+	LoadFactor() float64
+}
+
+var (
+	itemsDesc = prometheus.NewDesc("cuckoofilter_items",
+		"Number of items in the filter.", []string{"name"}, nil)
+	deletedDesc = prometheus.NewDesc("cuckoofilter_deleted_items",
+		"Number of items deleted since the last compaction.", []string{"name"}, nil)
+	filtersDesc = prometheus.NewDesc("cuckoofilter_sub_filters",
+		"Number of sub-filters.", []string{"name"}, nil)
+	loadFactorDesc = prometheus.NewDesc("cuckoofilter_load_factor",
+		"Fraction of slots in use.", []string{"name"}, nil)
+	sizeDesc = prometheus.NewDesc("cuckoofilter_size_bytes",
+		"Memory used by the filter.", []string{"name"}, nil)
+)
+
+// Collector is a prometheus.Collector that exports the counters of a set of
+// filters, labeled by name. The filters are read on every scrape.
+type Collector struct {
+	mu      sync.Mutex
+	filters map[string]Filter
+}
+
+func NewCollector() *Collector {
+	return &Collector{filters: make(map[string]Filter)}
+}
+
+// RegisterCuckoo adds cf to the collector under name, replacing the filter
+// registered under the same name, if any.
+func (c *Collector) RegisterCuckoo(name string, cf Filter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filters[name] = cf
+}
+
+// Unregister removes the filter registered under name.
+func (c *Collector) Unregister(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.filters, name)
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- itemsDesc
+	ch <- deletedDesc
+	ch <- filtersDesc
+	ch <- loadFactorDesc
+	ch <- sizeDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, cf := range c.filters {
+		info := cf.Info()
+		ch <- prometheus.MustNewConstMetric(itemsDesc, prometheus.GaugeValue, float64(info.ItemNum), name)
+		ch <- prometheus.MustNewConstMetric(deletedDesc, prometheus.GaugeValue, float64(info.DeleteNum), name)
+		ch <- prometheus.MustNewConstMetric(filtersDesc, prometheus.GaugeValue, float64(info.FilterNum), name)
+		ch <- prometheus.MustNewConstMetric(loadFactorDesc, prometheus.GaugeValue, cf.LoadFactor(), name)
+		ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, float64(info.Size), name)
+	}
+}
+
+var (
+	defaultCollector     = NewCollector()
+	registerDefaultsOnce sync.Once
+)
+
+// RegisterCuckoo adds cf under name to a collector registered with
+// prometheus.DefaultRegisterer, so it's scraped along with the default metrics.
+func RegisterCuckoo(name string, cf Filter) {
+	registerDefaultsOnce.Do(func() {
+		prometheus.MustRegister(defaultCollector)
+	})
+	defaultCollector.RegisterCuckoo(name, cf)
+}
+
+// UnregisterCuckoo removes the filter added by RegisterCuckoo under name.
+func UnregisterCuckoo(name string) {
+	defaultCollector.Unregister(name)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fukua95/pds/cuckoofilter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	a := cuckoofilter.New(1024, 2, 20, 1)
+	for i := 0; i < 512; i++ {
+		a.Insert([]byte(strconv.Itoa(i)))
+	}
+	a.Delete([]byte("0"))
+	b := cuckoofilter.NewConcurrent(cuckoofilter.New(1024, 2, 20, 1))
+
+	c := NewCollector()
+	c.RegisterCuckoo("a", a)
+	c.RegisterCuckoo("b", b)
+	assert.Equal(t, 10, testutil.CollectAndCount(c))
+
+	expected := `
+# HELP cuckoofilter_items Number of items in the filter.
+# TYPE cuckoofilter_items gauge
+cuckoofilter_items{name="a"} 511
+cuckoofilter_items{name="b"} 0
+# HELP cuckoofilter_deleted_items Number of items deleted since the last compaction.
+# TYPE cuckoofilter_deleted_items gauge
+cuckoofilter_deleted_items{name="a"} 1
+cuckoofilter_deleted_items{name="b"} 0
+# HELP cuckoofilter_sub_filters Number of sub-filters.
+# TYPE cuckoofilter_sub_filters gauge
+cuckoofilter_sub_filters{name="a"} 1
+cuckoofilter_sub_filters{name="b"} 1
+# HELP cuckoofilter_load_factor Fraction of slots in use.
+# TYPE cuckoofilter_load_factor gauge
+cuckoofilter_load_factor{name="a"} 0.4990234375
+cuckoofilter_load_factor{name="b"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"cuckoofilter_items", "cuckoofilter_deleted_items", "cuckoofilter_sub_filters", "cuckoofilter_load_factor"))
+
+	c.Unregister("b")
+	assert.Equal(t, 5, testutil.CollectAndCount(c))
+	expected = fmt.Sprintf(`
+# HELP cuckoofilter_size_bytes Memory used by the filter.
+# TYPE cuckoofilter_size_bytes gauge
+cuckoofilter_size_bytes{name="a"} %d
+`, a.SizeInBytes())
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "cuckoofilter_size_bytes"))
+}
+
+func TestRegisterCuckoo(t *testing.T) {
+	RegisterCuckoo("default", cuckoofilter.New(1024, 2, 20, 1))
+	RegisterCuckoo("other", cuckoofilter.New(1024, 2, 20, 1))
+	defer UnregisterCuckoo("default")
+	defer UnregisterCuckoo("other")
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	found := 0
+	for _, f := range families {
+		if f.GetName() == "cuckoofilter_items" {
+			found = len(f.GetMetric())
+		}
+	}
+	assert.Equal(t, 2, found)
+}
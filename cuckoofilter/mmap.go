@@ -0,0 +1,31 @@
+package cuckoofilter
+
+// mmapStorage is the Pool of a filter created by NewMmap.
+type mmapStorage interface {
+	Pool
+	sync() error
+	close() error
+}
+
+// Sync flushes the sub-filters of a filter created by NewMmap to its file,
+// and returns the last error that kept the filter from growing since the
+// previous Sync, if any. This only bounds how much of the filter the OS keeps
+// dirty in memory: the file can't be reopened as a filter. It does nothing for
+// other filters.
+func (cf *CuckooFilter) Sync() error {
+	if s, ok := cf.pool.(mmapStorage); ok {
+		return s.sync()
+	}
+	return nil
+}
+
+// Close unmaps the sub-filters of a filter created by NewMmap and closes its
+// file; for other filters it's Release. The filter must not be used afterwards.
+func (cf *CuckooFilter) Close() error {
+	s, ok := cf.pool.(mmapStorage)
+	cf.Release()
+	if ok {
+		return s.close()
+	}
+	return nil
+}
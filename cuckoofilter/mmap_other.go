@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cuckoofilter
+
+import "errors"
+
+// NewMmap is only supported on unix platforms.
+func NewMmap(path string, capacity uint64, opts ...Option) (*CuckooFilter, error) {
+	return nil, errors.New("memory-mapped filters aren't supported on this platform")
+}
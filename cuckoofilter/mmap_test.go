@@ -0,0 +1,109 @@
+//go:build unix
+
+package cuckoofilter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMmap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter")
+	cf, err := NewMmap(path, 1000, WithBucketSize(4), WithSeed(1))
+	assert.NoError(t, err)
+	ref, _ := NewWithOptions(1000, WithBucketSize(4), WithSeed(1))
+
+	// Growing maps new regions at the end of the file.
+	fill(cf, 10000)
+	fill(ref, 10000)
+	assert.Greater(t, cf.filterNum, uint16(1))
+	assert.True(t, ref.Equal(cf))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, info.Size(), int64(cf.Capacity()))
+	assert.Len(t, cf.pool.(*mmapPool).mapped, int(cf.filterNum))
+
+	assert.NoError(t, cf.Sync())
+
+	// Errors of growing are reported by the next Sync only.
+	cf.pool.(*mmapPool).err = errors.New("no space left on device")
+	assert.Error(t, cf.Sync())
+	assert.NoError(t, cf.Sync())
+	data, err := cf.MarshalBinary()
+	assert.NoError(t, err)
+
+	// Copies live on the heap.
+	cp := cf.Copy()
+	assert.Nil(t, cp.pool)
+	assert.True(t, cp.Equal(cf))
+
+	// Freed sub-filters are unmapped, and the file shrinks.
+	pool := cf.pool.(*mmapPool)
+	cf.Reset()
+	assert.Len(t, pool.mapped, 1)
+	info, err = os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, pool.size, info.Size())
+	assert.Less(t, info.Size(), int64(ref.Capacity()))
+
+	// Decoding and merging replace the mapped sub-filters.
+	assert.NoError(t, cf.UnmarshalBinary(data))
+	assert.True(t, ref.Equal(cf))
+	assert.Same(t, pool, cf.pool)
+	assert.Len(t, pool.mapped, int(cf.filterNum))
+	cf.Reset()
+	other, _ := NewWithOptions(1000, WithBucketSize(4), WithSeed(1))
+	fill(other, 1000)
+	assert.NoError(t, cf.Merge(other))
+	assert.Same(t, pool, cf.pool)
+	assert.Len(t, pool.mapped, int(cf.filterNum))
+
+	assert.NoError(t, cf.Close())
+	assert.Empty(t, pool.mapped)
+	assert.Nil(t, pool.Get(16))
+
+	_, err = NewMmap(filepath.Join(t.TempDir(), "missing", "filter"), 1000)
+	assert.Error(t, err)
+	_, err = NewMmap(path, 0)
+	assert.Error(t, err)
+
+	// Close is Release for other filters.
+	assert.NoError(t, New(1000, 2, 20, 1).Close())
+	assert.NoError(t, New(1000, 2, 20, 1).Sync())
+}
+
+func TestMmapPoolReuse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool")
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	p := &mmapPool{file: f, mapped: make(map[*byte]mmapRegion)}
+	defer p.close()
+
+	page := os.Getpagesize()
+	a, b, c := p.Get(page), p.Get(2*page), p.Get(page)
+	assert.Equal(t, int64(4*page), p.size)
+	b[0] = 1
+
+	// A freed region is reused, split and zeroed.
+	p.Put(b)
+	assert.Equal(t, []fileRegion{{int64(page), int64(2 * page)}}, p.free)
+	b = p.Get(16)
+	assert.Equal(t, make([]byte, 16), b)
+	assert.Equal(t, []fileRegion{{int64(2 * page), int64(page)}}, p.free)
+	assert.Equal(t, int64(4*page), p.size)
+
+	// Adjacent free regions are merged, and cut from the end of the file.
+	p.Put(a)
+	p.Put(b)
+	assert.Equal(t, []fileRegion{{0, int64(3 * page)}}, p.free)
+	p.Put(c)
+	assert.Empty(t, p.free)
+	assert.Equal(t, int64(0), p.size)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+}
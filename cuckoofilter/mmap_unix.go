@@ -0,0 +1,195 @@
+//go:build unix
+
+package cuckoofilter
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// NewMmap is like NewWithOptions, but the sub-filters are mapped from the file
+// at path instead of allocated on the heap, so that the OS pages them in and
+// out and the filter can be larger than memory. The file is created, or
+// truncated if it exists. Every sub-filter added by growing, merging or
+// decoding is mapped from a region of the file: one freed by compaction, Reset
+// or a previous merge or decode if one is large enough, otherwise a new one at
+// its end. The file shrinks when the regions at its end are freed.
+//
+// The file is only scratch storage: it holds the slots of the sub-filters,
+// page aligned, without anything that describes them, and can't be reopened
+// or decoded; serialize the filter to keep it. If a region can't be mapped,
+// e.g. because the disk is full, the filter doesn't grow, as when growing
+// would overflow, and Sync reports why.
+//
+// Close must be called once the filter isn't used anymore. Copy and Snapshot
+// return filters on the heap.
+func NewMmap(path string, capacity uint64, opts ...Option) (*CuckooFilter, error) {
+	o, err := newOptions(capacity, opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &mmapPool{file: f, mapped: make(map[*byte]mmapRegion)}
+	cf, err := o.newFilter(capacity, pool)
+	if err != nil {
+		err = errors.Join(err, pool.err)
+		pool.close()
+		return nil, err
+	}
+	return cf, nil
+}
+
+// mmapPool is a Pool that maps the slices it returns from a file. Regions
+// given back by Put are reused by later calls to Get, and the file grows only
+// when none of them is large enough.
+type mmapPool struct {
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	// mapped maps the first byte of every mapping to its region of the file.
+	mapped map[*byte]mmapRegion
+	// free holds the unused regions before the end of the file, sorted by
+	// offset and never adjacent to each other.
+	free []fileRegion
+	// err is the last error of Get since the previous sync.
+	err error
+}
+
+// fileRegion is a page aligned region of the file of an mmapPool.
+type fileRegion struct {
+	off, length int64
+}
+
+// mmapRegion is a region of the file and its mapping, which is rounded up to
+// a page.
+type mmapRegion struct {
+	fileRegion
+	buf []byte
+}
+
+func (p *mmapPool) Get(size int) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil || size <= 0 {
+		return nil
+	}
+
+	// Regions start at a page boundary, as mmap requires of the offset.
+	pageSize := int64(os.Getpagesize())
+	length := (int64(size) + pageSize - 1) / pageSize * pageSize
+	i := slices.IndexFunc(p.free, func(r fileRegion) bool { return r.length >= length })
+	if i < 0 {
+		buf := p.mmap(fileRegion{p.size, length}, true)
+		if buf == nil {
+			return nil
+		}
+		p.size += length
+		return buf[:size]
+	}
+
+	r := fileRegion{p.free[i].off, length}
+	buf := p.mmap(r, false)
+	if buf == nil {
+		return nil
+	}
+	if p.free[i].length == length {
+		p.free = slices.Delete(p.free, i, i+1)
+	} else {
+		p.free[i].off += length
+		p.free[i].length -= length
+	}
+	// The region may hold the fingerprints of a freed sub-filter.
+	clear(buf)
+	return buf[:size]
+}
+
+// mmap maps r, extending the file first if extend is set.
+func (p *mmapPool) mmap(r fileRegion, extend bool) []byte {
+	if extend {
+		if err := p.file.Truncate(r.off + r.length); err != nil {
+			p.err = err
+			return nil
+		}
+	}
+	buf, err := unix.Mmap(int(p.file.Fd()), r.off, int(r.length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		if extend {
+			p.file.Truncate(r.off)
+		}
+		p.err = err
+		return nil
+	}
+	p.mapped[&buf[0]] = mmapRegion{r, buf}
+	return buf
+}
+
+func (p *mmapPool) Put(buf []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(buf) == 0 {
+		return
+	}
+	// Slices that weren't mapped here, e.g. decoded ones, are left to the
+	// garbage collector.
+	m, ok := p.mapped[&buf[0]]
+	if !ok {
+		return
+	}
+	unix.Munmap(m.buf)
+	delete(p.mapped, &buf[0])
+
+	// Merge the region with its free neighbours, and cut it from the file if
+	// it ends up at the end.
+	r := m.fileRegion
+	i, _ := slices.BinarySearchFunc(p.free, r.off, func(f fileRegion, off int64) int { return cmp.Compare(f.off, off) })
+	if i < len(p.free) && r.off+r.length == p.free[i].off {
+		r.length += p.free[i].length
+		p.free = slices.Delete(p.free, i, i+1)
+	}
+	if i > 0 && p.free[i-1].off+p.free[i-1].length == r.off {
+		i--
+		r = fileRegion{p.free[i].off, p.free[i].length + r.length}
+		p.free = slices.Delete(p.free, i, i+1)
+	}
+	if r.off+r.length == p.size && p.file.Truncate(r.off) == nil {
+		p.size = r.off
+		return
+	}
+	p.free = slices.Insert(p.free, i, r)
+}
+
+func (p *mmapPool) sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := []error{p.err}
+	p.err = nil
+	for _, m := range p.mapped {
+		errs = append(errs, unix.Msync(m.buf, unix.MS_SYNC))
+	}
+	return errors.Join(errs...)
+}
+
+func (p *mmapPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	var errs []error
+	for k, m := range p.mapped {
+		errs = append(errs, unix.Munmap(m.buf))
+		delete(p.mapped, k)
+	}
+	errs = append(errs, p.file.Close())
+	p.file = nil
+	return errors.Join(errs...)
+}
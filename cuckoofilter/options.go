@@ -0,0 +1,119 @@
+package cuckoofilter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// An Option configures a filter created by NewWithOptions.
+type Option func(*options) error
+
+type options struct {
+	bucketSize  uint16
+	maxIter     uint16
+	expansion   uint16
+	hasher      Hasher
+	randomEvict bool
+	seed        uint64
+}
+
+// WithBucketSize sets the number of fingerprints per bucket; see New.
+// It defaults to 2.
+func WithBucketSize(bucketSize uint16) Option {
+	return func(o *options) error {
+		if bucketSize == 0 {
+			return errors.New("bucketSize must be positive")
+		}
+		o.bucketSize = bucketSize
+		return nil
+	}
+}
+
+// WithMaxIter sets the number of evictions tried before the filter grows;
+// see New. It defaults to 20.
+func WithMaxIter(maxIter uint16) Option {
+	return func(o *options) error {
+		if maxIter == 0 {
+			return errors.New("maxIter must be positive")
+		}
+		o.maxIter = maxIter
+		return nil
+	}
+}
+
+// WithExpansion sets the scaling factor of the filter; see New. 0 means the
+// filter never grows. It defaults to 1.
+func WithExpansion(expansion uint16) Option {
+	return func(o *options) error {
+		if expansion > 1<<15 {
+			return fmt.Errorf("expansion must be at most %d", 1<<15)
+		}
+		o.expansion = expansion
+		return nil
+	}
+}
+
+// WithHasher sets the hasher of the filter; see NewWithHasher. It defaults to
+// MurmurHasher.
+func WithHasher(h Hasher) Option {
+	return func(o *options) error {
+		if h == nil {
+			return errors.New("hasher must not be nil")
+		}
+		o.hasher = h
+		return nil
+	}
+}
+
+// WithSeed makes evictions pick their victims at random, from a PRNG seeded
+// with seed; see SetRandomEviction. By default, they don't. Either way, the
+// same inserts yield the same filter.
+func WithSeed(seed uint64) Option {
+	return func(o *options) error {
+		o.randomEvict = true
+		o.seed = seed
+		return nil
+	}
+}
+
+// NewWithOptions returns a filter of the given capacity configured by opts,
+// with the defaults of New for the parameters no option sets. It returns an
+// error instead of a filter if an option or the resulting combination of
+// parameters is invalid, as NewChecked does.
+func NewWithOptions(capacity uint64, opts ...Option) (*CuckooFilter, error) {
+	o, err := newOptions(capacity, opts)
+	if err != nil {
+		return nil, err
+	}
+	return o.newFilter(capacity, nil)
+}
+
+// newOptions applies opts over the defaults and validates the result.
+func newOptions(capacity uint64, opts []Option) (*options, error) {
+	o := &options{
+		bucketSize: defaultBucketSize,
+		maxIter:    defaultMaxIter,
+		expansion:  defaultExpansion,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkParams(capacity, o.bucketSize, o.maxIter, o.expansion, 1); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// newFilter returns a filter configured by o, whose storage comes from pool.
+func (o *options) newFilter(capacity uint64, pool Pool) (*CuckooFilter, error) {
+	cf, err := newFilter(capacity, o.bucketSize, o.maxIter, o.expansion, 1, o.hasher, pool)
+	if err != nil {
+		return nil, err
+	}
+	if o.randomEvict {
+		cf.SetRandomEviction(o.seed)
+	}
+	return cf, nil
+}
@@ -0,0 +1,65 @@
+package cuckoofilter
+
+import (
+	"hash/crc32"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	cf, err := NewWithOptions(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, New(1000, defaultBucketSize, defaultMaxIter, defaultExpansion), cf)
+
+	calls := 0
+	h := fnvHasher{calls: &calls}
+	cf, err = NewWithOptions(1000,
+		WithBucketSize(4), WithMaxIter(50), WithExpansion(2), WithHasher(h), WithSeed(7))
+	assert.NoError(t, err)
+	expected := NewWithHasher(1000, 4, 50, 2, h)
+	expected.SetRandomEviction(7)
+	assert.Equal(t, expected, cf)
+	seed, ok := cf.EvictionSeed()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(7), seed)
+
+	// Later options override earlier ones.
+	cf, err = NewWithOptions(1000, WithBucketSize(4), WithBucketSize(1))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(1), cf.bucketSize)
+
+	cf, err = NewWithOptions(1000, WithExpansion(0))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), cf.expansion)
+
+	for _, opts := range [][]Option{
+		{WithBucketSize(0)},
+		{WithMaxIter(0)},
+		{WithExpansion(1<<15 + 1)},
+		{WithHasher(nil)},
+	} {
+		cf, err := NewWithOptions(1000, opts...)
+		assert.Error(t, err)
+		assert.Nil(t, cf)
+	}
+	_, err = NewWithOptions(0)
+	assert.Error(t, err)
+}
+
+func TestWithSeedGolden(t *testing.T) {
+	// The bucket contents only depend on the seed and the inserts: neither the
+	// hashes nor the PRNG depend on the Go version or the platform.
+	cf, err := NewWithOptions(1<<10, WithBucketSize(2), WithMaxIter(100), WithSeed(42))
+	assert.NoError(t, err)
+	for i := 0; i < 3000; i++ {
+		assert.True(t, cf.Insert([]byte(strconv.Itoa(i))))
+	}
+	crc := crc32.NewIEEE()
+	for i := range cf.filters {
+		crc.Write(cf.filters[i].data)
+	}
+	assert.Equal(t, uint16(4), cf.filterNum)
+	assert.Equal(t, uint32(0x83843528), crc.Sum32())
+}
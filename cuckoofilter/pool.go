@@ -0,0 +1,40 @@
+package cuckoofilter
+
+import "sync"
+
+// A Pool provides the fingerprint storage of sub-filters.
+// Get must return a zeroed slice of length size, or nil if it can't, in which
+// case the filter doesn't grow, as when growing would overflow; Put gets back
+// a slice returned by Get once the filter doesn't use it anymore.
+type Pool interface {
+	Get(size int) []byte
+	Put(buf []byte)
+}
+
+// SyncPool is a Pool that keeps released slices in a sync.Pool per size.
+// Its zero value is ready to use, and it's safe for concurrent use, so one
+// SyncPool can be shared by many filters.
+type SyncPool struct {
+	pools sync.Map // int -> *sync.Pool
+}
+
+func (p *SyncPool) pool(size int) *sync.Pool {
+	if v, ok := p.pools.Load(size); ok {
+		return v.(*sync.Pool)
+	}
+	v, _ := p.pools.LoadOrStore(size, &sync.Pool{})
+	return v.(*sync.Pool)
+}
+
+func (p *SyncPool) Get(size int) []byte {
+	if v := p.pool(size).Get(); v != nil {
+		buf := *v.(*[]byte)
+		clear(buf)
+		return buf
+	}
+	return make([]byte, size)
+}
+
+func (p *SyncPool) Put(buf []byte) {
+	p.pool(len(buf)).Put(&buf)
+}
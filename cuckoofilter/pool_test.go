@@ -0,0 +1,76 @@
+package cuckoofilter
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingPool struct {
+	SyncPool
+	gets, puts int
+}
+
+func (p *countingPool) Get(size int) []byte {
+	p.gets++
+	return p.SyncPool.Get(size)
+}
+
+func (p *countingPool) Put(buf []byte) {
+	p.puts++
+	p.SyncPool.Put(buf)
+}
+
+func TestPool(t *testing.T) {
+	pool := &countingPool{}
+	cf := NewWithPool(1000, defaultBucketSize, 20, 1, pool)
+	fill(cf, 5000)
+	assert.Equal(t, int(cf.filterNum), pool.gets)
+	assert.Equal(t, 0, pool.puts)
+
+	filterNum := int(cf.filterNum)
+	cf.Reset()
+	assert.Equal(t, filterNum-1, pool.puts)
+	assert.Equal(t, uint16(1), cf.filterNum)
+
+	// Decoding keeps the pool.
+	fill(cf, 5000)
+	data, err := cf.MarshalBinary()
+	assert.NoError(t, err)
+	gets := pool.gets
+	loaded := NewWithPool(1000, defaultBucketSize, 20, 1, pool)
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, gets+1+int(cf.filterNum), pool.gets)
+	assert.True(t, cf.Equal(loaded))
+
+	// Compaction gives freed sub-filters back.
+	puts := pool.puts
+	for i := 1000; i < 5000; i++ {
+		assert.True(t, cf.Delete([]byte(strconv.Itoa(i))))
+	}
+	cf.Compact()
+	assert.Equal(t, puts+filterNum-int(cf.filterNum), pool.puts)
+
+	puts = pool.puts
+	filterNum = int(cf.filterNum)
+	cf.Release()
+	assert.Equal(t, puts+filterNum, pool.puts)
+	assert.Equal(t, &CuckooFilter{}, cf)
+
+	// Reused storage is cleared.
+	cf = NewWithPool(1000, defaultBucketSize, 20, 1, pool)
+	assert.Equal(t, []uint64{512, 0, 0}, cf.OccupancyHistogram())
+	loaded.Release()
+}
+
+type failingPool struct{ SyncPool }
+
+func (p *failingPool) Get(size int) []byte { return nil }
+
+func TestPoolGetFails(t *testing.T) {
+	cf := NewWithPool(1000, defaultBucketSize, 20, 1, &failingPool{})
+	assert.Equal(t, uint16(0), cf.filterNum)
+	assert.False(t, cf.grow())
+	assert.Empty(t, cf.filters)
+}
@@ -0,0 +1,141 @@
+// Package resp serves cuckoo filters over the Redis protocol (RESP),
+// with the CF.* commands of RedisBloom.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxBulkSize bounds the size of a single argument. It's large enough for the
+// chunks of CF.SCANDUMP.
+const maxBulkSize = 512 << 20
+
+// maxArgs bounds the number of arguments of a command.
+const maxArgs = 1024
+
+var errProtocol = errors.New("protocol error")
+
+// readLine reads a line terminated by CRLF, without the terminator.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errProtocol
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// readInt reads a line holding the integer that follows a type byte.
+func readInt(r *bufio.Reader, typ byte) (int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 2 || line[0] != typ {
+		return 0, errProtocol
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return 0, errProtocol
+	}
+	return n, nil
+}
+
+// readCommand reads a command sent as an array of bulk strings, the way
+// Redis clients send them.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	n, err := readInt(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > maxArgs {
+		return nil, errProtocol
+	}
+
+	args := make([][]byte, n)
+	for i := range args {
+		size, err := readInt(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 || size > maxBulkSize {
+			return nil, errProtocol
+		}
+		arg, err := readBulk(r, size+2)
+		if err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, errProtocol
+		}
+		args[i] = arg[:size]
+	}
+	return args, nil
+}
+
+// readBulk reads n bytes in chunks, so that the length announced by a client
+// can't allocate more memory than it actually sends.
+func readBulk(r *bufio.Reader, n int) ([]byte, error) {
+	var buf []byte
+	for n > 0 {
+		chunk := min(n, 1<<20)
+		start := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		n -= chunk
+	}
+	return buf, nil
+}
+
+// writer writes RESP replies.
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func (w writer) error(s string) {
+	fmt.Fprintf(w, "-%s\r\n", s)
+}
+
+func (w writer) int(n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func (w writer) bool(b bool) {
+	if b {
+		w.int(1)
+	} else {
+		w.int(0)
+	}
+}
+
+func (w writer) bulk(b []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w writer) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w writer) array(n int) {
+	fmt.Fprintf(w, "*%d\r\n", n)
+}
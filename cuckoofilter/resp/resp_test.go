@@ -0,0 +1,50 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCommand(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*2\r\n$4\r\nPING\r\n$0\r\n\r\n*1\r\n$3\r\nfoo\r\n"))
+	args, err := readCommand(r)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("PING"), {}}, args)
+	args, err = readCommand(r)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("foo")}, args)
+
+	for _, in := range []string{
+		"PING\r\n",
+		"*0\r\n",
+		"*1\r\n$3\r\nfoobar\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\n$3\r\nfoo\r\n",
+		"*x\r\n",
+	} {
+		_, err := readCommand(bufio.NewReader(strings.NewReader(in)))
+		assert.ErrorIs(t, err, errProtocol, in)
+	}
+
+	// A large announced length isn't allocated before the data arrives.
+	_, err = readCommand(bufio.NewReader(strings.NewReader("*1\r\n$536870912\r\nfoo")))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := writer{bufio.NewWriter(&buf)}
+	w.array(5)
+	w.simple("OK")
+	w.error("ERR oops")
+	w.bool(true)
+	w.bulk([]byte("a\r\nb"))
+	w.null()
+	w.Flush()
+	assert.Equal(t, "*5\r\n+OK\r\n-ERR oops\r\n:1\r\n$4\r\na\r\nb\r\n$-1\r\n", buf.String())
+}
@@ -0,0 +1,329 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fukua95/pds/cuckoofilter"
+)
+
+// Parameters of the filters CF.ADD creates, and of CF.RESERVE unless given.
+const (
+	defaultCapacity   = 1024
+	defaultBucketSize = 2
+	defaultMaxIter    = 20
+	defaultExpansion  = 1
+)
+
+// maxCapacity bounds the capacity of CF.RESERVE, so that a client can't make
+// the server allocate more memory than it has.
+const maxCapacity = 1 << 32
+
+// Server holds named cuckoo filters and serves the CF.* commands on them:
+// CF.RESERVE, CF.ADD, CF.ADDNX, CF.EXISTS, CF.DEL, CF.COUNT, CF.INFO,
+// CF.SCANDUMP and CF.LOADCHUNK, with the arguments and replies of RedisBloom.
+// PING and QUIT are supported too, so that generic clients can connect.
+type Server struct {
+	mu      sync.Mutex
+	filters map[string]*cuckoofilter.ConcurrentCuckooFilter
+}
+
+func NewServer() *Server {
+	return &Server{filters: make(map[string]*cuckoofilter.ConcurrentCuckooFilter)}
+}
+
+// Serve accepts connections on l and serves each one in its own goroutine.
+// It returns when Accept fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves the commands sent on conn until the client disconnects or
+// sends QUIT, then closes conn.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if errors.Is(err, errProtocol) {
+				w.error("ERR Protocol error")
+				w.Flush()
+			}
+			return err
+		}
+
+		quit := strings.EqualFold(string(args[0]), "QUIT")
+		if quit {
+			w.simple("OK")
+		} else {
+			s.safeExec(w, args)
+		}
+		// Pipelined commands are answered together.
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// filter returns the filter named key, or nil if it doesn't exist.
+func (s *Server) filter(key []byte) *cuckoofilter.ConcurrentCuckooFilter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filters[string(key)]
+}
+
+// filterOrCreate returns the filter named key, creating it with create if it
+// doesn't exist.
+func (s *Server) filterOrCreate(key []byte, create func() *cuckoofilter.CuckooFilter) *cuckoofilter.ConcurrentCuckooFilter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cf, ok := s.filters[string(key)]
+	if !ok {
+		cf = cuckoofilter.NewConcurrent(create())
+		s.filters[string(key)] = cf
+	}
+	return cf
+}
+
+func defaultFilter() *cuckoofilter.CuckooFilter {
+	return cuckoofilter.New(defaultCapacity, defaultBucketSize, defaultMaxIter, defaultExpansion)
+}
+
+func parseUint(arg []byte, bits int) (uint64, bool) {
+	n, err := strconv.ParseUint(string(arg), 10, bits)
+	return n, err == nil
+}
+
+// arity is the number of arguments of each command, including its name.
+var arity = map[string]int{
+	"PING":         1,
+	"CF.RESERVE":   3,
+	"CF.ADD":       3,
+	"CF.ADDNX":     3,
+	"CF.EXISTS":    3,
+	"CF.DEL":       3,
+	"CF.COUNT":     3,
+	"CF.INFO":      2,
+	"CF.SCANDUMP":  3,
+	"CF.LOADCHUNK": 4,
+}
+
+// safeExec runs a command, replying with an error if it panics, so that a
+// command can't bring down the server.
+func (s *Server) safeExec(w writer, args [][]byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			w.error(fmt.Sprintf("ERR %v", r))
+		}
+	}()
+	s.exec(w, args)
+}
+
+func (s *Server) exec(w writer, args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	n, ok := arity[name]
+	if !ok {
+		w.error("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+	// Only CF.RESERVE takes optional arguments, in pairs.
+	if len(args) < n || (name != "CF.RESERVE" && len(args) > n) || (name == "CF.RESERVE" && (len(args)-n)%2 != 0) {
+		w.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+
+	switch name {
+	case "PING":
+		w.simple("PONG")
+	case "CF.RESERVE":
+		s.reserve(w, args)
+	case "CF.ADD":
+		cf := s.filterOrCreate(args[1], defaultFilter)
+		if !cf.Insert(args[2]) {
+			w.error("ERR Filter is full")
+			return
+		}
+		w.int(1)
+	case "CF.ADDNX":
+		cf := s.filterOrCreate(args[1], defaultFilter)
+		w.bool(cf.InsertNX(args[2]))
+	case "CF.EXISTS":
+		cf := s.filter(args[1])
+		w.bool(cf != nil && cf.Exist(args[2]))
+	case "CF.DEL":
+		cf := s.filter(args[1])
+		if cf == nil {
+			w.error("ERR not found")
+			return
+		}
+		w.bool(cf.Delete(args[2]))
+	case "CF.COUNT":
+		count := uint64(0)
+		if cf := s.filter(args[1]); cf != nil {
+			count = cf.Count(args[2])
+		}
+		w.int(int64(count))
+	case "CF.INFO":
+		s.info(w, args)
+	case "CF.SCANDUMP":
+		s.scanDump(w, args)
+	case "CF.LOADCHUNK":
+		s.loadChunk(w, args)
+	}
+}
+
+// reserve handles CF.RESERVE key capacity [BUCKETSIZE n] [MAXITERATIONS n] [EXPANSION n].
+func (s *Server) reserve(w writer, args [][]byte) {
+	capacity, ok := parseUint(args[2], 64)
+	if !ok || capacity == 0 || capacity > maxCapacity {
+		w.error("ERR Bad capacity")
+		return
+	}
+	bucketSize, maxIter, expansion := uint64(defaultBucketSize), uint64(defaultMaxIter), uint64(defaultExpansion)
+	for i := 3; i < len(args); i += 2 {
+		n, ok := parseUint(args[i+1], 16)
+		switch strings.ToUpper(string(args[i])) {
+		case "BUCKETSIZE":
+			if !ok || n == 0 {
+				w.error("ERR Bad bucket size")
+				return
+			}
+			bucketSize = n
+		case "MAXITERATIONS":
+			if !ok || n == 0 {
+				w.error("ERR Bad max iterations")
+				return
+			}
+			maxIter = n
+		case "EXPANSION":
+			if !ok {
+				w.error("ERR Bad expansion")
+				return
+			}
+			expansion = n
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	cf, err := cuckoofilter.NewChecked(capacity, uint16(bucketSize), uint16(maxIter), uint16(expansion))
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.filters[string(args[1])]; ok {
+		w.error("ERR item exists")
+		return
+	}
+	s.filters[string(args[1])] = cuckoofilter.NewConcurrent(cf)
+	w.simple("OK")
+}
+
+func (s *Server) info(w writer, args [][]byte) {
+	cf := s.filter(args[1])
+	if cf == nil {
+		w.error("ERR not found")
+		return
+	}
+	info := cf.Info()
+	fields := []struct {
+		name  string
+		value uint64
+	}{
+		{"Size", info.Size},
+		{"Number of buckets", info.BucketNum},
+		{"Number of filters", uint64(info.FilterNum)},
+		{"Number of items inserted", info.ItemNum},
+		{"Number of items deleted", info.DeleteNum},
+		{"Bucket size", uint64(info.BucketSize)},
+		{"Expansion rate", uint64(info.Expansion)},
+		{"Max iterations", uint64(info.MaxIter)},
+	}
+	w.array(2 * len(fields))
+	for _, f := range fields {
+		w.simple(f.name)
+		w.int(int64(f.value))
+	}
+}
+
+func (s *Server) scanDump(w writer, args [][]byte) {
+	iter, ok := parseUint(args[2], 64)
+	if !ok {
+		w.error("ERR invalid iterator")
+		return
+	}
+	cf := s.filter(args[1])
+	if cf == nil {
+		w.error("ERR not found")
+		return
+	}
+	next, chunk, err := cf.ScanDump(iter)
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+	w.array(2)
+	w.int(int64(next))
+	if next == 0 {
+		w.null()
+	} else {
+		w.bulk(chunk)
+	}
+}
+
+func (s *Server) loadChunk(w writer, args [][]byte) {
+	iter, ok := parseUint(args[2], 64)
+	if !ok {
+		w.error("ERR invalid iterator")
+		return
+	}
+	if iter == 1 {
+		// The header chunk creates the filter, or replaces an existing one,
+		// only if it's valid.
+		cf := &cuckoofilter.CuckooFilter{}
+		if err := cf.LoadChunk(iter, args[3]); err != nil {
+			w.error("ERR " + err.Error())
+			return
+		}
+		s.mu.Lock()
+		s.filters[string(args[1])] = cuckoofilter.NewConcurrent(cf)
+		s.mu.Unlock()
+		w.simple("OK")
+		return
+	}
+	cf := s.filter(args[1])
+	if cf == nil {
+		w.error("ERR not found")
+		return
+	}
+	if err := cf.LoadChunk(iter, args[3]); err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+	w.simple("OK")
+}
@@ -0,0 +1,150 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fukua95/pds/cuckoofilter"
+	"github.com/stretchr/testify/assert"
+)
+
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newClient(s *Server) *client {
+	conn, server := net.Pipe()
+	go s.ServeConn(server)
+	return &client{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns its reply, with arrays flattened to their
+// elements separated by spaces.
+func (c *client) do(args ...string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+	c.conn.Write([]byte(sb.String()))
+	return c.reply()
+}
+
+func (c *client) reply() string {
+	line, _ := c.r.ReadString('\n')
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		elems := make([]string, n)
+		for i := range elems {
+			elems[i] = c.reply()
+		}
+		return strings.Join(elems, " ")
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "(nil)"
+		}
+		buf := make([]byte, n+2)
+		io.ReadFull(c.r, buf)
+		return string(buf[:n])
+	}
+	return line
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	c := newClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "+PONG", c.do("PING"))
+	assert.Equal(t, "-ERR unknown command 'FOO'", c.do("FOO"))
+	assert.Equal(t, "-ERR wrong number of arguments for 'cf.add' command", c.do("CF.ADD", "cf"))
+
+	assert.Equal(t, "+OK", c.do("CF.RESERVE", "cf", "1000", "BUCKETSIZE", "4", "EXPANSION", "2"))
+	assert.Equal(t, "-ERR item exists", c.do("cf.reserve", "cf", "1000"))
+	assert.Equal(t, "-ERR Bad capacity", c.do("CF.RESERVE", "other", "0"))
+	assert.Equal(t, "-ERR Bad bucket size", c.do("CF.RESERVE", "other", "10", "BUCKETSIZE", "x"))
+	assert.Equal(t, "-ERR syntax error", c.do("CF.RESERVE", "other", "10", "FOO", "1"))
+	assert.Equal(t, "-ERR Bad capacity", c.do("CF.RESERVE", "other", "4611686018427387904"))
+
+	assert.Equal(t, ":1", c.do("CF.ADD", "cf", "a"))
+	assert.Equal(t, ":0", c.do("CF.ADDNX", "cf", "a"))
+	assert.Equal(t, ":1", c.do("CF.ADDNX", "cf", "b"))
+	assert.Equal(t, ":1", c.do("CF.ADD", "cf", "a"))
+	assert.Equal(t, ":1", c.do("CF.EXISTS", "cf", "a"))
+	assert.Equal(t, ":0", c.do("CF.EXISTS", "cf", "c"))
+	assert.Equal(t, ":0", c.do("CF.EXISTS", "missing", "a"))
+	assert.Equal(t, ":2", c.do("CF.COUNT", "cf", "a"))
+	assert.Equal(t, ":0", c.do("CF.COUNT", "missing", "a"))
+	assert.Equal(t, ":1", c.do("CF.DEL", "cf", "a"))
+	assert.Equal(t, ":0", c.do("CF.DEL", "cf", "c"))
+	assert.Equal(t, "-ERR not found", c.do("CF.DEL", "missing", "a"))
+
+	info := c.do("CF.INFO", "cf")
+	assert.Contains(t, info, "+Number of buckets :256 +Number of filters :1 "+
+		"+Number of items inserted :2 +Number of items deleted :1 "+
+		"+Bucket size :4 +Expansion rate :2 +Max iterations :20")
+	assert.Equal(t, "-ERR not found", c.do("CF.INFO", "missing"))
+
+	// CF.ADD creates a filter with the default parameters.
+	assert.Equal(t, ":1", c.do("CF.ADD", "auto", "a"))
+	assert.Contains(t, c.do("CF.INFO", "auto"), "+Number of buckets :512")
+}
+
+func TestServerDump(t *testing.T) {
+	s := NewServer()
+	c := newClient(s)
+	defer c.conn.Close()
+
+	for i := 0; i < 2000; i++ {
+		assert.Equal(t, ":1", c.do("CF.ADD", "src", strconv.Itoa(i)))
+	}
+
+	// Dump src and load it into dst, as redis-cli scripts do.
+	iter := "0"
+	for {
+		reply := c.do("CF.SCANDUMP", "src", iter)
+		next, data, _ := strings.Cut(reply, " ")
+		if next == ":0" {
+			assert.Equal(t, "(nil)", data)
+			break
+		}
+		iter = next[1:]
+		assert.Equal(t, "+OK", c.do("CF.LOADCHUNK", "dst", iter, data))
+	}
+
+	for i := 0; i < 2000; i++ {
+		assert.Equal(t, ":1", c.do("CF.EXISTS", "dst", strconv.Itoa(i)))
+	}
+	assert.Equal(t, s.filter([]byte("src")).Info(), s.filter([]byte("dst")).Info())
+
+	assert.Equal(t, "-ERR not found", c.do("CF.LOADCHUNK", "missing", "10", "x"))
+
+	// An invalid header doesn't create or replace a filter.
+	assert.Contains(t, c.do("CF.LOADCHUNK", "bad", "1", "xyz"), "-ERR")
+	assert.Equal(t, ":0", c.do("CF.EXISTS", "bad", "a"))
+	assert.Equal(t, "-ERR not found", c.do("CF.INFO", "bad"))
+	assert.Contains(t, c.do("CF.LOADCHUNK", "dst", "1", "xyz"), "-ERR")
+	assert.Equal(t, ":1", c.do("CF.EXISTS", "dst", "0"))
+	assert.Equal(t, "-ERR invalid iterator", c.do("CF.SCANDUMP", "src", "x"))
+	assert.Equal(t, "+OK", c.do("QUIT"))
+}
+
+func TestServerPanic(t *testing.T) {
+	s := NewServer()
+	c := newClient(s)
+	defer c.conn.Close()
+
+	// A command that panics gets an error, and the connection is kept.
+	s.filters["zero"] = cuckoofilter.NewConcurrent(&cuckoofilter.CuckooFilter{})
+	assert.Equal(t, "-ERR runtime error: integer divide by zero", c.do("CF.EXISTS", "zero", "a"))
+	assert.Equal(t, "+PONG", c.do("PING"))
+}
@@ -0,0 +1,120 @@
+package cuckoofilter
+
+import (
+	"math"
+
+	"github.com/fukua95/pds/internal/hashing"
+)
+
+// shardSeed seeds the hash that picks the shard of an item. It differs from
+// the seed of MurmurHasher so the shard doesn't depend on the bucket indexes.
+const shardSeed = 0x9747b28c
+
+// ShardedCuckooFilter partitions items across independent filters, each with
+// its own lock, so that writers of unrelated items don't wait on each other.
+// Each item is always routed to the same shard, by a hash of the item.
+//
+// Every shard grows, compacts and fills up on its own: compaction never moves
+// fingerprints across shards, and a shard may need to grow while others still
+// have room, so the filter uses somewhat more memory than a single one.
+type ShardedCuckooFilter struct {
+	shards []*ConcurrentCuckooFilter
+}
+
+// NewSharded returns a filter of shardNum shards that share the capacity.
+// The other parameters are those of New and apply to every shard.
+func NewSharded(shardNum int, capacity uint64, bucketSize uint16, maxIter uint16, expansion uint16) *ShardedCuckooFilter {
+	shardNum = max(shardNum, 1)
+	s := &ShardedCuckooFilter{shards: make([]*ConcurrentCuckooFilter, shardNum)}
+	for i := range s.shards {
+		s.shards[i] = NewConcurrent(New(capacity/uint64(shardNum), bucketSize, maxIter, expansion))
+	}
+	return s
+}
+
+func (s *ShardedCuckooFilter) shard(data []byte) *ConcurrentCuckooFilter {
+	return s.shards[hashing.Sum64(data, shardSeed)%uint64(len(s.shards))]
+}
+
+func (s *ShardedCuckooFilter) Insert(data []byte) bool {
+	return s.shard(data).Insert(data)
+}
+
+func (s *ShardedCuckooFilter) InsertNX(data []byte) bool {
+	return s.shard(data).InsertNX(data)
+}
+
+func (s *ShardedCuckooFilter) Delete(data []byte) bool {
+	return s.shard(data).Delete(data)
+}
+
+func (s *ShardedCuckooFilter) DeleteAll(data []byte) uint64 {
+	return s.shard(data).DeleteAll(data)
+}
+
+func (s *ShardedCuckooFilter) CheckAndDelete(data []byte) bool {
+	return s.shard(data).CheckAndDelete(data)
+}
+
+func (s *ShardedCuckooFilter) Exist(data []byte) bool {
+	return s.shard(data).Exist(data)
+}
+
+func (s *ShardedCuckooFilter) Count(data []byte) uint64 {
+	return s.shard(data).Count(data)
+}
+
+// Compact compacts every shard, one after the other.
+func (s *ShardedCuckooFilter) Compact() {
+	for _, sh := range s.shards {
+		sh.Compact()
+	}
+}
+
+// Reset removes all items from every shard.
+func (s *ShardedCuckooFilter) Reset() {
+	for _, sh := range s.shards {
+		sh.Reset()
+	}
+}
+
+// ShardNum returns the number of shards.
+func (s *ShardedCuckooFilter) ShardNum() int {
+	return len(s.shards)
+}
+
+// Info sums the sizes and counters of the shards: BucketNum is the total
+// number of buckets of their first sub-filters, and FilterNum the total
+// number of sub-filters, capped at math.MaxUint16. The shards are read one
+// after the other, so the result isn't a consistent snapshot while items are
+// inserted or deleted.
+func (s *ShardedCuckooFilter) Info() CuckooInfo {
+	var res CuckooInfo
+	for _, sh := range s.shards {
+		info := sh.Info()
+		res.Size += info.Size
+		res.BucketNum += info.BucketNum
+		res.FilterNum = uint16(min(uint32(res.FilterNum)+uint32(info.FilterNum), math.MaxUint16))
+		res.ItemNum += info.ItemNum
+		res.DeleteNum += info.DeleteNum
+		res.BucketSize = info.BucketSize
+		res.MaxIter = info.MaxIter
+		res.Expansion = info.Expansion
+	}
+	return res
+}
+
+// LoadFactor returns the fraction of slots in use across all shards.
+func (s *ShardedCuckooFilter) LoadFactor() float64 {
+	items, slots := uint64(0), uint64(0)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		items += sh.cf.itemNum
+		slots += sh.cf.Capacity()
+		sh.mu.RUnlock()
+	}
+	if slots == 0 {
+		return 0
+	}
+	return float64(items) / float64(slots)
+}
@@ -0,0 +1,61 @@
+package cuckoofilter
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharded(t *testing.T) {
+	const workers, n = 8, 5000
+	s := NewSharded(4, 8000, 4, 20, 1)
+	assert.Equal(t, 4, s.ShardNum())
+	info := s.Info()
+	assert.Equal(t, uint64(2048), info.BucketNum)
+	assert.Equal(t, uint16(4), info.FilterNum)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w * n; i < (w+1)*n; i++ {
+				k := []byte(strconv.Itoa(i))
+				assert.True(t, s.Insert(k))
+				assert.True(t, s.Exist(k))
+			}
+		}()
+	}
+	wg.Wait()
+
+	info = s.Info()
+	assert.Equal(t, uint64(workers*n), info.ItemNum)
+	assert.Greater(t, info.FilterNum, uint16(4))
+	assert.Equal(t, uint16(4), info.BucketSize)
+	assert.Greater(t, s.LoadFactor(), 0.5)
+
+	// The number of sub-filters saturates.
+	s.shards[0].cf.filterNum = math.MaxUint16
+	assert.Equal(t, uint16(math.MaxUint16), s.Info().FilterNum)
+	s.shards[0].cf.filterNum = uint16(len(s.shards[0].cf.filters))
+
+	// Items are spread across the shards.
+	for _, sh := range s.shards {
+		assert.Greater(t, sh.Info().ItemNum, uint64(workers*n/8))
+	}
+
+	for i := 0; i < workers*n; i++ {
+		k := []byte(strconv.Itoa(i))
+		assert.GreaterOrEqual(t, s.Count(k), uint64(1))
+		assert.True(t, s.Delete(k))
+	}
+	assert.Equal(t, uint64(0), s.Info().ItemNum)
+
+	s.Insert([]byte("key"))
+	s.Reset()
+	assert.False(t, s.Exist([]byte("key")))
+	assert.Equal(t, 0.0, s.LoadFactor())
+}
@@ -0,0 +1,63 @@
+package cuckoofilter
+
+// fpKeys counts the items of the filter by the bits of their hash it stores:
+// the fingerprint and the pair of buckets the item can live in, reduced to
+// the first bucketNum buckets. bucketNum must be a power of 2 no larger than
+// any sub-filter.
+func (cf *CuckooFilter) fpKeys(bucketNum uint64) map[uint64]uint64 {
+	keys := make(map[uint64]uint64, cf.itemNum)
+	for i := range cf.filters {
+		f := &cf.filters[i]
+		for bIx := uint64(0); bIx < f.bucketNum; bIx++ {
+			b := f.bucket(bIx)
+			for sIx := 0; sIx < b.size(); sIx++ {
+				fp := b.get(sIx)
+				if fp == nullFp {
+					continue
+				}
+				p1 := bIx % bucketNum
+				p2 := uint64(altHash(fp, cuckooHash(p1))) % bucketNum
+				keys[min(p1, p2)<<16|uint64(fp)]++
+			}
+		}
+	}
+	return keys
+}
+
+// Similarity estimates the Jaccard index of the sets of items of both filters,
+// i.e. the number of items in both divided by the number of items in either.
+// Two empty filters are similar with 1.
+//
+// Both filters must use the same hasher and fingerprint size, and custom
+// hashers must be equal values; 0 is returned for incompatible filters, as
+// their fingerprints can't be compared. Items are compared by their
+// fingerprint and buckets, which different items share by chance: with 8-bit
+// fingerprints and n buckets in the smaller first sub-filter, two given items
+// collide with a probability of about 1/(127*n). The expected number of such
+// collisions is subtracted from the shared items, but it remains a source of
+// noise once the filters hold more than a few times n items. It's also skewed
+// by duplicates, which count as separate items.
+//
+// It takes memory proportional to the number of items of both filters.
+func (cf *CuckooFilter) Similarity(other *CuckooFilter) float64 {
+	if cf.fpSize != other.fpSize || !cf.sameHasher(other) {
+		return 0
+	}
+	if cf.itemNum == 0 && other.itemNum == 0 {
+		return 1
+	}
+
+	bucketNum := min(cf.bucketNum, other.bucketNum)
+	keys, otherKeys := cf.fpKeys(bucketNum), other.fpKeys(bucketNum)
+	shared := 0.0
+	for k, n := range keys {
+		shared += float64(min(n, otherKeys[k]))
+	}
+
+	// The number of keys an item can map to: a fingerprint and an unordered
+	// pair of buckets.
+	keySpace := float64(bucketNum) * float64(uint64(1)<<(8*cf.fpSize)-1) / 2
+	a, b := float64(cf.itemNum), float64(other.itemNum)
+	shared = max(shared-(a-shared)*(b-shared)/keySpace, 0)
+	return shared / (a + b - shared)
+}
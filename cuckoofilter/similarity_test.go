@@ -0,0 +1,38 @@
+package cuckoofilter
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimilarity(t *testing.T) {
+	filled := func(from, to int) *CuckooFilter {
+		cf := New(4096, 4, 20, 2)
+		for i := from; i < to; i++ {
+			cf.Insert([]byte(strconv.Itoa(i)))
+		}
+		return cf
+	}
+
+	a := filled(0, 10000)
+	assert.InDelta(t, 1.0, a.Similarity(filled(0, 10000)), 0.01)
+	assert.InDelta(t, 1.0/3, a.Similarity(filled(5000, 15000)), 0.03)
+	assert.InDelta(t, 1.0/3, filled(5000, 15000).Similarity(a), 0.03)
+	assert.InDelta(t, 0.0, a.Similarity(filled(10000, 20000)), 0.03)
+	assert.InDelta(t, 0.1, a.Similarity(filled(0, 1000)), 0.01)
+
+	// Filters of different sizes.
+	b := New(4000, 4, 20, 2)
+	for i := 5000; i < 15000; i++ {
+		b.Insert([]byte(strconv.Itoa(i)))
+	}
+	assert.InDelta(t, 1.0/3, a.Similarity(b), 0.05)
+
+	assert.Equal(t, 1.0, New(10, 4, 20, 1).Similarity(New(100, 2, 20, 1)))
+
+	// Incompatible filters.
+	assert.Equal(t, 0.0, a.Similarity(New16(4096, 4, 20, 2)))
+	assert.Equal(t, 0.0, a.Similarity(NewWithHasher(4096, 4, 20, 2, XXHasher{})))
+}
@@ -0,0 +1,90 @@
+package cuckoofilter
+
+import "encoding/binary"
+
+// Key is the set of types a TypedFilter can hold.
+type Key interface {
+	string | []byte |
+		int | int8 | int16 | int32 | int64 |
+		uint | uint8 | uint16 | uint32 | uint64
+}
+
+// TypedFilter is a CuckooFilter of values of type K, which it encodes to bytes
+// before hashing them.
+//
+// Strings and byte slices are hashed as their bytes. Integers are hashed as
+// 8 bytes little-endian, signed ones sign-extended, so an integer value hashes
+// the same whatever its type: int32(7) and uint64(7) are the same item, as
+// are int8(-1) and int64(-1). A string and a byte slice with the same bytes
+// are the same item too.
+type TypedFilter[K Key] struct {
+	cf *CuckooFilter
+}
+
+// NewTyped wraps cf, which may already hold items inserted as bytes.
+func NewTyped[K Key](cf *CuckooFilter) *TypedFilter[K] {
+	return &TypedFilter[K]{cf: cf}
+}
+
+// Filter returns the wrapped filter.
+func (f *TypedFilter[K]) Filter() *CuckooFilter {
+	return f.cf
+}
+
+// encodeKey returns the bytes k is hashed as. buf is used for integers.
+func encodeKey[K Key](k K, buf *[8]byte) []byte {
+	var v uint64
+	switch k := any(k).(type) {
+	case string:
+		return []byte(k)
+	case []byte:
+		return k
+	case int:
+		v = uint64(k)
+	case int8:
+		v = uint64(k)
+	case int16:
+		v = uint64(k)
+	case int32:
+		v = uint64(k)
+	case int64:
+		v = uint64(k)
+	case uint:
+		v = uint64(k)
+	case uint8:
+		v = uint64(k)
+	case uint16:
+		v = uint64(k)
+	case uint32:
+		v = uint64(k)
+	case uint64:
+		v = k
+	}
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return buf[:]
+}
+
+func (f *TypedFilter[K]) Insert(k K) bool {
+	var buf [8]byte
+	return f.cf.Insert(encodeKey(k, &buf))
+}
+
+func (f *TypedFilter[K]) InsertNX(k K) bool {
+	var buf [8]byte
+	return f.cf.InsertNX(encodeKey(k, &buf))
+}
+
+func (f *TypedFilter[K]) Exist(k K) bool {
+	var buf [8]byte
+	return f.cf.Exist(encodeKey(k, &buf))
+}
+
+func (f *TypedFilter[K]) Count(k K) uint64 {
+	var buf [8]byte
+	return f.cf.Count(encodeKey(k, &buf))
+}
+
+func (f *TypedFilter[K]) Delete(k K) bool {
+	var buf [8]byte
+	return f.cf.Delete(encodeKey(k, &buf))
+}
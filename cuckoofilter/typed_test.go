@@ -0,0 +1,34 @@
+package cuckoofilter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedFilter(t *testing.T) {
+	ints := NewTyped[int](New(1000, defaultBucketSize, 20, 1))
+	for i := -500; i < 500; i++ {
+		assert.True(t, ints.Insert(i))
+	}
+	for i := -500; i < 500; i++ {
+		assert.True(t, ints.Exist(i))
+	}
+	assert.False(t, ints.InsertNX(7))
+	assert.True(t, ints.Delete(7))
+	assert.Equal(t, uint64(999), ints.Filter().itemNum)
+
+	// The same value hashes the same whatever its type.
+	u64 := NewTyped[uint64](ints.Filter())
+	assert.True(t, u64.Exist(499))
+	i8 := NewTyped[int8](ints.Filter())
+	assert.True(t, i8.Exist(-1))
+	assert.Equal(t, ints.Count(-1), i8.Count(-1))
+
+	strs := NewTyped[string](New(1000, defaultBucketSize, 20, 1))
+	assert.True(t, strs.Insert("key"))
+	assert.True(t, strs.Exist("key"))
+	assert.True(t, strs.Filter().Exist([]byte("key")))
+	assert.True(t, NewTyped[[]byte](strs.Filter()).Exist([]byte("key")))
+	assert.False(t, strs.Exist("other"))
+}
@@ -0,0 +1,120 @@
+package hyperloglog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// The binary format starts with binaryMagic and binaryVersion, followed by the
+// precision and a flags byte. A dense HLL follows with its registers packed in
+// 6 bits each, 4 in 3 bytes, big-endian; a sparse one with its entries, as a
+// little-endian uint32 count followed by the entries as little-endian uint32.
+// It ends with the CRC32 of everything before.
+const (
+	binaryMagic      = "HLLS"
+	binaryVersion    = 1
+	binaryHeaderSize = len(binaryMagic) + 3
+	checksumSize     = 4
+
+	flagSparse = 1 << 0
+)
+
+// ErrCorruptData is returned when decoding data whose checksum doesn't match.
+var ErrCorruptData = errors.New("corrupt data")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (h *HLL) MarshalBinary() ([]byte, error) {
+	flags := byte(0)
+	size := binaryHeaderSize + checksumSize
+	if h.registers == nil {
+		flags |= flagSparse
+		size += 4 + 4*len(h.sparse)
+	} else {
+		size += len(h.registers) / 4 * 3
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, binaryMagic...)
+	data = append(data, binaryVersion, h.precision, flags)
+	if h.registers == nil {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(h.sparse)))
+		for _, e := range h.sparse {
+			data = binary.LittleEndian.AppendUint32(data, e)
+		}
+	} else {
+		for i := 0; i < len(h.registers); i += 4 {
+			r := h.registers[i : i+4]
+			v := uint32(r[0])<<18 | uint32(r[1])<<12 | uint32(r[2])<<6 | uint32(r[3])
+			data = append(data, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data)), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The checksum is verified before anything is decoded, and ErrCorruptData is
+// returned if it doesn't match.
+func (h *HLL) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize+checksumSize {
+		return errors.New("data too short")
+	}
+	if string(data[:len(binaryMagic)]) != binaryMagic {
+		return errors.New("not a hyperloglog")
+	}
+	payload, tail := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(tail) {
+		return ErrCorruptData
+	}
+	if payload[len(binaryMagic)] != binaryVersion {
+		return errors.New("unsupported version")
+	}
+	precision, flags := payload[len(binaryMagic)+1], payload[len(binaryMagic)+2]
+	if precision < MinPrecision || precision > MaxPrecision {
+		return fmt.Errorf("%w: invalid precision", ErrCorruptData)
+	}
+
+	res := HLL{precision: precision}
+	body := payload[binaryHeaderSize:]
+	if flags&flagSparse != 0 {
+		if len(body) < 4 {
+			return errors.New("data too short")
+		}
+		n := binary.LittleEndian.Uint32(body)
+		body = body[4:]
+		if n > uint32(res.maxSparse()) || uint64(len(body)) != 4*uint64(n) {
+			return fmt.Errorf("%w: invalid sparse entries", ErrCorruptData)
+		}
+		for i := range int(n) {
+			e := binary.LittleEndian.Uint32(body[4*i:])
+			rank := e & (1<<rankBits - 1)
+			// Entries must be sorted by index, without duplicates.
+			if e>>rankBits >= 1<<sparsePrecision || rank == 0 || rank > 64-sparsePrecision+1 ||
+				(i > 0 && e>>rankBits <= res.sparse[i-1]>>rankBits) {
+				return fmt.Errorf("%w: invalid sparse entries", ErrCorruptData)
+			}
+			res.sparse = append(res.sparse, e)
+		}
+	} else {
+		m := 1 << precision
+		if len(body) != m/4*3 {
+			return fmt.Errorf("%w: invalid registers", ErrCorruptData)
+		}
+		res.registers = make([]uint8, m)
+		for i := 0; i < m; i += 4 {
+			b := body[i/4*3:]
+			v := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+			for j := range 4 {
+				r := uint8(v>>(18-6*j)) & 0x3f
+				if r > 64-precision+1 {
+					return fmt.Errorf("%w: invalid registers", ErrCorruptData)
+				}
+				res.registers[i+j] = r
+			}
+		}
+	}
+
+	*h = res
+	return nil
+}
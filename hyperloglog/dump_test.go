@@ -0,0 +1,41 @@
+package hyperloglog
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	h := New(12)
+	for _, n := range []int{0, 100, 100000} {
+		for i := 0; i < n; i++ {
+			h.Add([]byte(strconv.Itoa(i)))
+		}
+		data, err := h.MarshalBinary()
+		assert.NoError(t, err)
+		if h.registers == nil {
+			assert.Len(t, data, binaryHeaderSize+4+4*len(h.sparse)+checksumSize)
+		} else {
+			assert.Len(t, data, binaryHeaderSize+3072+checksumSize)
+		}
+
+		loaded := New(4)
+		assert.NoError(t, loaded.UnmarshalBinary(data))
+		assert.Equal(t, h, loaded)
+		assert.Equal(t, h.Count(), loaded.Count())
+	}
+	assert.NotNil(t, h.registers)
+
+	data, _ := h.MarshalBinary()
+	loaded := New(4)
+	assert.Error(t, loaded.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, loaded.UnmarshalBinary(append(bytes.Clone(data), 0)))
+	assert.Error(t, loaded.UnmarshalBinary(data[:5]))
+	corrupted := bytes.Clone(data)
+	corrupted[binaryHeaderSize+3] ^= 1
+	assert.ErrorIs(t, loaded.UnmarshalBinary(corrupted), ErrCorruptData)
+	assert.Equal(t, New(4), loaded)
+}
@@ -0,0 +1,196 @@
+// Package hyperloglog implements HyperLogLog, which estimates the number of
+// distinct items of a set in a fixed amount of memory: 2^precision registers,
+// for a standard error of 1.04/sqrt(2^precision).
+package hyperloglog
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+
+	"github.com/fukua95/pds/internal/hashing"
+)
+
+// Bounds of the precision. Below MinPrecision the estimator isn't reliable,
+// and above MaxPrecision the registers take more than 256 KiB.
+const (
+	MinPrecision = 4
+	MaxPrecision = 18
+)
+
+// sparsePrecision is the precision of the sparse representation, as in
+// HyperLogLog++.
+const sparsePrecision = 25
+
+// HLL starts sparse, as in HyperLogLog++: rather than 2^precision registers,
+// it keeps a sorted list of the registers set among 2^sparsePrecision, whose
+// count is estimated by linear counting, far more accurately than with the
+// dense registers. Once the list would take more memory than the dense
+// registers, it's converted to them.
+type HLL struct {
+	precision uint8
+	// registers hold, for the items hashed to each of them, the largest
+	// position of the first 1 bit in the rest of the hash. It's nil while the
+	// HLL is sparse.
+	registers []uint8
+	// sparse holds, sorted, an entry per register set with the sparse
+	// precision: its index, shifted by rankBits, ORed with its value.
+	sparse []uint32
+}
+
+const rankBits = 6
+
+// New returns an HLL with 2^precision registers. It panics unless precision
+// is in [MinPrecision, MaxPrecision].
+func New(precision uint8) *HLL {
+	if precision < MinPrecision || precision > MaxPrecision {
+		panic(fmt.Sprintf("hyperloglog: precision must be in [%d, %d]", MinPrecision, MaxPrecision))
+	}
+	return &HLL{precision: precision}
+}
+
+// maxSparse is the number of sparse entries above which the HLL is converted
+// to dense registers, which then take less memory.
+func (h *HLL) maxSparse() int {
+	return (1 << h.precision) / 4
+}
+
+// Precision returns the precision the HLL was created with.
+func (h *HLL) Precision() uint8 {
+	return h.precision
+}
+
+// StandardError returns the relative standard error of Count, 1.04/sqrt(m).
+func (h *HLL) StandardError() float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<h.precision))
+}
+
+// Add adds data to the set.
+func (h *HLL) Add(data []byte) {
+	hash := hashing.Sum64(data, 0)
+	if h.registers == nil {
+		// The same as below, with the sparse precision.
+		idx := uint32(hash >> (64 - sparsePrecision))
+		rank := uint8(bits.LeadingZeros64(hash<<sparsePrecision|1<<(sparsePrecision-1))) + 1
+		h.addSparse(idx<<rankBits | uint32(rank))
+		return
+	}
+	// The first precision bits select the register, and the position of the
+	// first 1 bit in the others, from 1 to 64-precision+1, updates it.
+	idx := hash >> (64 - h.precision)
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+	h.registers[idx] = max(h.registers[idx], rank)
+}
+
+// addSparse adds a sparse entry, keeping the largest value per index, and
+// converts the HLL to dense registers if the list gets too long.
+func (h *HLL) addSparse(entry uint32) {
+	i, found := slices.BinarySearchFunc(h.sparse, entry>>rankBits, func(e, idx uint32) int {
+		return cmp.Compare(e>>rankBits, idx)
+	})
+	if found {
+		h.sparse[i] = max(h.sparse[i], entry)
+		return
+	}
+	h.sparse = slices.Insert(h.sparse, i, entry)
+	if len(h.sparse) > h.maxSparse() {
+		h.toDense()
+	}
+}
+
+// toDense converts a sparse HLL to dense registers.
+func (h *HLL) toDense() {
+	h.registers = make([]uint8, 1<<h.precision)
+	for _, e := range h.sparse {
+		idx, rank := h.denseOf(e)
+		h.registers[idx] = max(h.registers[idx], rank)
+	}
+	h.sparse = nil
+}
+
+// denseOf returns the register and the value a sparse entry sets.
+func (h *HLL) denseOf(entry uint32) (uint32, uint8) {
+	shift := sparsePrecision - h.precision
+	idx := entry >> rankBits
+	// The bits of the sparse index that aren't part of the dense one come
+	// first in the rest of the hash.
+	if rest := idx & (1<<shift - 1); rest != 0 {
+		return idx >> shift, uint8(bits.LeadingZeros32(rest)-(32-int(shift))) + 1
+	}
+	return idx >> shift, uint8(entry&(1<<rankBits-1)) + shift
+}
+
+// Count returns an estimate of the number of distinct items added, with the
+// bias-corrected estimator of HyperLogLog: the harmonic mean of the registers,
+// or linear counting on the empty registers for small cardinalities.
+// Hashes are 64-bit, so no correction is needed for large cardinalities.
+func (h *HLL) Count() uint64 {
+	if h.registers == nil {
+		m := float64(uint64(1) << sparsePrecision)
+		return uint64(math.Round(m * math.Log(m/(m-float64(len(h.sparse))))))
+	}
+
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha(len(h.registers)) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// alpha returns the constant that corrects the bias of the raw estimate for m
+// registers.
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// Merge sets each register of h to its maximum over h and others, so that h
+// counts the union of their sets. All of them must have the same precision;
+// otherwise an error is returned and h is left unchanged.
+func (h *HLL) Merge(others ...*HLL) error {
+	for _, o := range others {
+		if o.precision != h.precision {
+			return errors.New("sketches have different precisions")
+		}
+	}
+
+	for _, o := range others {
+		if o.registers == nil {
+			for _, e := range o.sparse {
+				if h.registers == nil {
+					h.addSparse(e)
+				} else {
+					idx, rank := h.denseOf(e)
+					h.registers[idx] = max(h.registers[idx], rank)
+				}
+			}
+			continue
+		}
+		if h.registers == nil {
+			h.toDense()
+		}
+		for i, r := range o.registers {
+			h.registers[i] = max(h.registers[i], r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package hyperloglog
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	h := New(14)
+	assert.Nil(t, h.registers)
+	assert.Equal(t, uint8(14), h.Precision())
+	assert.InDelta(t, 0.0081, h.StandardError(), 0.0001)
+	assert.Equal(t, uint64(0), h.Count())
+
+	assert.Panics(t, func() { New(MinPrecision - 1) })
+	assert.Panics(t, func() { New(MaxPrecision + 1) })
+	assert.NotPanics(t, func() { New(MinPrecision).Add([]byte("a")) })
+}
+
+func TestCount(t *testing.T) {
+	for _, p := range []uint8{10, 14} {
+		h := New(p)
+		n := 0
+		for _, target := range []int{10, 100, 1000, 10000, 100000, 1000000} {
+			for ; n < target; n++ {
+				h.Add([]byte(strconv.Itoa(n)))
+			}
+			// Duplicates don't count.
+			h.Add([]byte("0"))
+			assert.InEpsilon(t, target, h.Count(), 3*h.StandardError()+0.001, "precision %d, %d items", p, target)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	shards := []*HLL{New(14), New(14), New(14)}
+	for i := 0; i < 300000; i++ {
+		// Shards overlap: each item is in one or two of them.
+		k := []byte(strconv.Itoa(i % 200000))
+		shards[i%3].Add(k)
+	}
+
+	union := New(14)
+	assert.NoError(t, union.Merge(shards...))
+	assert.InEpsilon(t, 200000, union.Count(), 3*union.StandardError())
+
+	// Merging is idempotent.
+	count := union.Count()
+	assert.NoError(t, union.Merge(shards...))
+	assert.Equal(t, count, union.Count())
+
+	assert.Error(t, union.Merge(shards[0], New(12)))
+	assert.Equal(t, count, union.Count())
+}
+
+func TestSparse(t *testing.T) {
+	sparse, dense := New(14), New(14)
+	dense.toDense()
+	for i := 0; i < 1<<14/4; i++ {
+		k := []byte(strconv.Itoa(i))
+		sparse.Add(k)
+		dense.Add(k)
+		if i%1000 == 0 {
+			// Linear counting on 2^25 registers is nearly exact.
+			assert.InDelta(t, i+1, sparse.Count(), 1+float64(i)/1000)
+		}
+	}
+	assert.Nil(t, sparse.registers)
+	assert.LessOrEqual(t, len(sparse.sparse), sparse.maxSparse())
+
+	// Converting gives the registers of the dense HLL.
+	for i := 1 << 14 / 4; sparse.registers == nil; i++ {
+		k := []byte(strconv.Itoa(i))
+		sparse.Add(k)
+		dense.Add(k)
+	}
+	assert.Nil(t, sparse.sparse)
+	assert.Equal(t, dense, sparse)
+
+	// So does merging sparse HLLs into a dense one, or the other way around.
+	a, b := New(10), New(10)
+	for i := 0; i < 100; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+	}
+	b.toDense()
+	for i := 0; i < 100; i++ {
+		b.Add([]byte(strconv.Itoa(i)))
+	}
+	merged := New(10)
+	merged.toDense()
+	assert.NoError(t, merged.Merge(a))
+	assert.Equal(t, b, merged)
+	assert.NoError(t, a.Merge(b))
+	assert.Equal(t, b, a)
+}
@@ -0,0 +1,59 @@
+// Package hashing provides the seeded 64-bit hash functions the structures of
+// this module derive their cells, bits and fingerprints from.
+package hashing
+
+import (
+	"github.com/aviddiviner/go-murmur"
+	"github.com/cespare/xxhash/v2"
+)
+
+// A Hasher is a seeded 64-bit hash function. Hashes of the same data with
+// different seeds must look independent, as structures derive several hashes
+// of an item from one Hasher.
+type Hasher interface {
+	Sum64(data []byte, seed uint64) uint64
+}
+
+// IDs of the hashers in the headers of serialized structures, so that a
+// structure isn't decoded with another hasher than the one that filled it.
+// Hashers other than Murmur, XXHash and Murmur2 can't be identified and are
+// all IDCustom.
+const (
+	IDMurmur  uint8 = 0
+	IDXXHash  uint8 = 1
+	IDMurmur2 uint8 = 2
+	IDCustom  uint8 = 0xff
+)
+
+// Murmur is MurmurHash64A, the default Hasher, as RedisBloom uses.
+type Murmur struct{}
+
+func (Murmur) Sum64(data []byte, seed uint64) uint64 {
+	return murmur.MurmurHash64A(data, seed)
+}
+
+// XXHash is XXH64, which is faster than MurmurHash64A on long items.
+type XXHash struct{}
+
+func (XXHash) Sum64(data []byte, seed uint64) uint64 {
+	if seed == 0 {
+		return xxhash.Sum64(data)
+	}
+	var d xxhash.Digest
+	d.ResetWithSeed(seed)
+	d.Write(data)
+	return d.Sum64()
+}
+
+// Murmur2 is the 32-bit MurmurHash2, as RedisBloom's count-min sketches use.
+// Only the low 32 bits of the seed are used, and the hash fits in 32 bits.
+type Murmur2 struct{}
+
+func (Murmur2) Sum64(data []byte, seed uint64) uint64 {
+	return uint64(murmur.MurmurHash2(data, uint32(seed)))
+}
+
+// Sum64 hashes data with the default Hasher.
+func Sum64(data []byte, seed uint64) uint64 {
+	return Murmur{}.Sum64(data, seed)
+}
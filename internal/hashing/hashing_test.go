@@ -0,0 +1,53 @@
+package hashing
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aviddiviner/go-murmur"
+	"github.com/cespare/xxhash/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSum64(t *testing.T) {
+	data := []byte("hello")
+	assert.Equal(t, murmur.MurmurHash64A(data, 0), Sum64(data, 0))
+	assert.Equal(t, murmur.MurmurHash64A(data, 7), Murmur{}.Sum64(data, 7))
+
+	// The seeded path agrees with the one-shot hash for seed 0.
+	var d xxhash.Digest
+	d.ResetWithSeed(0)
+	d.Write(data)
+	assert.Equal(t, d.Sum64(), XXHash{}.Sum64(data, 0))
+	assert.Equal(t, xxhash.NewWithSeed(7).Sum64(), XXHash{}.Sum64(nil, 7))
+
+	// Reference values of the C implementation.
+	assert.Equal(t, uint64(3848350155), Murmur2{}.Sum64(data, 0))
+	assert.Equal(t, uint64(2788266382), Murmur2{}.Sum64(data, 1))
+	assert.Equal(t, uint64(834424730), Murmur2{}.Sum64([]byte("a key of typical length"), 0))
+
+	for _, h := range []Hasher{Murmur{}, XXHash{}, Murmur2{}} {
+		for seed := uint64(0); seed < 3; seed++ {
+			seen := make(map[uint64]bool)
+			for i := 0; i < 1000; i++ {
+				seen[h.Sum64([]byte(strconv.Itoa(i)), seed)] = true
+			}
+			assert.Len(t, seen, 1000)
+			assert.NotEqual(t, h.Sum64(data, seed), h.Sum64(data, seed+1))
+		}
+	}
+}
+
+func BenchmarkSum64(b *testing.B) {
+	data := []byte("a key of typical length, 32 byte")
+	for _, bench := range []struct {
+		name string
+		h    Hasher
+	}{{"murmur", Murmur{}}, {"xxhash", XXHash{}}} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bench.h.Sum64(data, uint64(i&1))
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package tdigest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// The binary format starts with binaryMagic and binaryVersion, followed by the
+// compression, the minimum and the maximum as little-endian float64, and the
+// number of centroids as little-endian uint64. Each centroid follows as its
+// mean and weight, little-endian float64. It ends with the CRC32 of everything
+// before. Buffered values are merged before they're written.
+const (
+	binaryMagic      = "TDIG"
+	binaryVersion    = 1
+	binaryHeaderSize = len(binaryMagic) + 1 + 4*8
+	centroidSize     = 16
+	checksumSize     = 4
+)
+
+// ErrCorruptData is returned when decoding data whose checksum doesn't match.
+var ErrCorruptData = errors.New("corrupt data")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (t *TDigest) MarshalBinary() ([]byte, error) {
+	t.process()
+	data := make([]byte, 0, binaryHeaderSize+len(t.centroids)*centroidSize+checksumSize)
+	data = append(data, binaryMagic...)
+	data = append(data, binaryVersion)
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(t.compression))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(t.min))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(t.max))
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(t.centroids)))
+	for _, c := range t.centroids {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(c.mean))
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(c.weight))
+	}
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data)), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The checksum is verified before anything is decoded, and ErrCorruptData is
+// returned if it doesn't match.
+func (t *TDigest) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize+checksumSize {
+		return errors.New("data too short")
+	}
+	if string(data[:len(binaryMagic)]) != binaryMagic {
+		return errors.New("not a t-digest")
+	}
+	payload, tail := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(tail) {
+		return ErrCorruptData
+	}
+	if payload[len(binaryMagic)] != binaryVersion {
+		return errors.New("unsupported version")
+	}
+
+	rest := payload[len(binaryMagic)+1:]
+	next := func() float64 {
+		v := math.Float64frombits(binary.LittleEndian.Uint64(rest))
+		rest = rest[8:]
+		return v
+	}
+	res := TDigest{compression: next(), min: next(), max: next()}
+	n := binary.LittleEndian.Uint64(rest)
+	rest = rest[8:]
+	if !(res.compression > 0 && res.compression <= math.MaxInt32) {
+		return fmt.Errorf("%w: invalid compression", ErrCorruptData)
+	}
+	if n > uint64(len(rest)/centroidSize) || uint64(len(rest)) != n*centroidSize {
+		return fmt.Errorf("%w: invalid number of centroids", ErrCorruptData)
+	}
+	if n == 0 && !(math.IsInf(res.min, 1) && math.IsInf(res.max, -1)) {
+		return fmt.Errorf("%w: invalid bounds", ErrCorruptData)
+	}
+
+	res.centroids = make([]centroid, n)
+	for i := range res.centroids {
+		c := centroid{mean: next(), weight: next()}
+		// Centroids must be sorted, within the bounds, and have a weight.
+		if !(c.mean >= res.min && c.mean <= res.max) || !(c.weight > 0 && c.weight <= math.MaxFloat64) ||
+			(i > 0 && c.mean < res.centroids[i-1].mean) {
+			return fmt.Errorf("%w: invalid centroid", ErrCorruptData)
+		}
+		res.centroids[i] = c
+		res.processed += c.weight
+	}
+
+	*t = res
+	return nil
+}
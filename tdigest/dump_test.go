@@ -0,0 +1,40 @@
+package tdigest
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	td := New(100)
+	data, err := td.MarshalBinary()
+	assert.NoError(t, err)
+	loaded := New(10)
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, td.Count(), loaded.Count())
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		td.Add(r.ExpFloat64(), uint(i%3+1))
+	}
+	data, err = td.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, data, binaryHeaderSize+len(td.centroids)*centroidSize+checksumSize)
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, td.centroids, loaded.centroids)
+	assert.Equal(t, td.Count(), loaded.Count())
+	for _, q := range []float64{0, 0.5, 0.99, 1} {
+		assert.Equal(t, td.Quantile(q), loaded.Quantile(q))
+	}
+
+	assert.Error(t, loaded.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, loaded.UnmarshalBinary(append(bytes.Clone(data), 0)))
+	assert.Error(t, loaded.UnmarshalBinary(data[:10]))
+	corrupted := bytes.Clone(data)
+	corrupted[binaryHeaderSize+3] ^= 1
+	assert.ErrorIs(t, loaded.UnmarshalBinary(corrupted), ErrCorruptData)
+	assert.Equal(t, td.Quantile(0.5), loaded.Quantile(0.5))
+}
@@ -0,0 +1,210 @@
+// Package tdigest implements the t-digest, which estimates the quantiles of a
+// stream of values in bounded memory, with an error that's smallest at the
+// extreme quantiles, such as p99 and p999.
+package tdigest
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// A centroid summarizes the values of a range by their mean and total weight.
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// TDigest is a merging t-digest: values are buffered, and merged with the
+// centroids when the buffer is full or a quantile is requested. Merging walks
+// the values in order and groups them into centroids whose sizes are bounded by
+// the k1 scale function, k(q) = compression/(2π) asin(2q-1): a centroid must
+// span at most 1 in k, so centroids are small near the extremes and large
+// around the median. There are about compression centroids at most.
+type TDigest struct {
+	compression float64
+	// centroids are sorted by mean, and hold processed weight in total.
+	centroids []centroid
+	processed float64
+	// unmerged holds the values added since the last merge.
+	unmerged []centroid
+	min, max float64
+}
+
+// New returns a t-digest with the given compression; 100 is a common choice,
+// for an error around 0.1% at p99. It panics unless compression is positive.
+func New(compression float64) *TDigest {
+	if !(compression > 0 && compression <= math.MaxInt32) {
+		panic(fmt.Sprintf("tdigest: invalid compression %v", compression))
+	}
+	return &TDigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// bufferSize is the number of values buffered before they're merged.
+func (t *TDigest) bufferSize() int {
+	return int(5 * math.Ceil(t.compression))
+}
+
+// Add adds value, with the given weight. NaN values and a weight of 0 are
+// ignored.
+func (t *TDigest) Add(value float64, weight uint) {
+	if math.IsNaN(value) || weight == 0 {
+		return
+	}
+	t.unmerged = append(t.unmerged, centroid{mean: value, weight: float64(weight)})
+	t.min = min(t.min, value)
+	t.max = max(t.max, value)
+	if len(t.unmerged) >= t.bufferSize() {
+		t.process()
+	}
+}
+
+// Count returns the total weight of the values added.
+func (t *TDigest) Count() float64 {
+	total := t.processed
+	for _, c := range t.unmerged {
+		total += c.weight
+	}
+	return total
+}
+
+// process merges the buffered values into the centroids.
+func (t *TDigest) process() {
+	if len(t.unmerged) == 0 {
+		return
+	}
+	all := append(t.unmerged, t.centroids...)
+	slices.SortFunc(all, func(a, b centroid) int { return cmp.Compare(a.mean, b.mean) })
+	total := 0.0
+	for _, c := range all {
+		total += c.weight
+	}
+
+	merged := make([]centroid, 0, len(t.centroids)+1)
+	cur := all[0]
+	// soFar is the weight before cur, and kLeft the scale at its left edge.
+	soFar := 0.0
+	kLeft := t.scale(0)
+	for _, c := range all[1:] {
+		if t.scale((soFar+cur.weight+c.weight)/total)-kLeft <= 1 {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		merged = append(merged, cur)
+		soFar += cur.weight
+		kLeft = t.scale(soFar / total)
+		cur = c
+	}
+	merged = append(merged, cur)
+
+	t.centroids = merged
+	t.processed = total
+	t.unmerged = t.unmerged[:0]
+}
+
+// scale is the k1 scale function.
+func (t *TDigest) scale(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*min(max(q, 0), 1)-1)
+}
+
+// Quantile returns an estimate of the q-quantile of the values added, e.g. the
+// median for 0.5. It interpolates linearly between the centroids, and between
+// the extreme ones and the minimum and maximum values. It returns NaN if no
+// value was added or if q isn't in [0, 1].
+func (t *TDigest) Quantile(q float64) float64 {
+	t.process()
+	if len(t.centroids) == 0 || !(q >= 0 && q <= 1) {
+		return math.NaN()
+	}
+	if len(t.centroids) == 1 {
+		return t.centroids[0].mean
+	}
+
+	rank := q * t.processed
+	first, last := t.centroids[0], t.centroids[len(t.centroids)-1]
+	if rank < first.weight/2 {
+		return t.min + (first.mean-t.min)*rank/(first.weight/2)
+	}
+	if rank > t.processed-last.weight/2 {
+		return t.max - (t.max-last.mean)*(t.processed-rank)/(last.weight/2)
+	}
+
+	// Walk the centers of the centroids, at the middle of their weight.
+	center := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, c := t.centroids[i-1], t.centroids[i]
+		next := center + (prev.weight+c.weight)/2
+		if rank <= next {
+			return prev.mean + (c.mean-prev.mean)*(rank-center)/(next-center)
+		}
+		center = next
+	}
+	return last.mean
+}
+
+// Merge adds the values of others to t: their centroids are merged with those
+// of t, and compressed again. All digests must have the same compression;
+// otherwise an error is returned and t is left unchanged.
+func (t *TDigest) Merge(others ...*TDigest) error {
+	for _, o := range others {
+		if o.compression != t.compression {
+			return errors.New("digests have different compressions")
+		}
+	}
+
+	var added []centroid
+	for _, o := range others {
+		added = append(added, o.centroids...)
+		added = append(added, o.unmerged...)
+		t.min = min(t.min, o.min)
+		t.max = max(t.max, o.max)
+	}
+	t.unmerged = append(t.unmerged, added...)
+	t.process()
+	return nil
+}
+
+// CDF returns an estimate of the fraction of the weight of the values added
+// that are at most value, the inverse of Quantile: it interpolates the same
+// way. It returns NaN if no value was added.
+func (t *TDigest) CDF(value float64) float64 {
+	t.process()
+	if len(t.centroids) == 0 || math.IsNaN(value) {
+		return math.NaN()
+	}
+	if value < t.min {
+		return 0
+	}
+	if value >= t.max {
+		return 1
+	}
+	if len(t.centroids) == 1 {
+		return (value - t.min) / (t.max - t.min)
+	}
+
+	first, last := t.centroids[0], t.centroids[len(t.centroids)-1]
+	if value < first.mean {
+		return (value - t.min) / (first.mean - t.min) * first.weight / 2 / t.processed
+	}
+	if value >= last.mean {
+		return 1 - (t.max-value)/(t.max-last.mean)*last.weight/2/t.processed
+	}
+
+	center := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, c := t.centroids[i-1], t.centroids[i]
+		next := center + (prev.weight+c.weight)/2
+		if value < c.mean {
+			return (center + (value-prev.mean)/(c.mean-prev.mean)*(next-center)) / t.processed
+		}
+		center = next
+	}
+	return 1
+}
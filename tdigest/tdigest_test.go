@@ -0,0 +1,127 @@
+package tdigest
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	td := New(100)
+	assert.True(t, math.IsNaN(td.Quantile(0.5)))
+	assert.Equal(t, 0.0, td.Count())
+	for _, c := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		assert.Panics(t, func() { New(c) })
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for name, gen := range map[string]func() float64{
+		"uniform":     r.Float64,
+		"normal":      r.NormFloat64,
+		"exponential": r.ExpFloat64,
+	} {
+		td := New(100)
+		values := make([]float64, 100000)
+		for i := range values {
+			values[i] = gen()
+			td.Add(values[i], 1)
+		}
+		slices.Sort(values)
+		assert.Equal(t, float64(len(values)), td.Count())
+		assert.LessOrEqual(t, len(td.centroids), 100)
+
+		for _, q := range []float64{0.001, 0.01, 0.1, 0.5, 0.9, 0.99, 0.999} {
+			// The error is measured in rank, where it's bounded.
+			got := td.Quantile(q)
+			rank := float64(sortSearch(values, got)) / float64(len(values))
+			assert.InDelta(t, q, rank, 0.01*math.Min(q, 1-q)+0.0005, "%s q=%v", name, q)
+		}
+		assert.Equal(t, values[0], td.Quantile(0))
+		assert.Equal(t, values[len(values)-1], td.Quantile(1))
+	}
+}
+
+func sortSearch(values []float64, v float64) int {
+	i, _ := slices.BinarySearch(values, v)
+	return i
+}
+
+func TestAddWeighted(t *testing.T) {
+	td := New(100)
+	td.Add(1, 3)
+	td.Add(2, 1)
+	td.Add(math.NaN(), 1)
+	td.Add(5, 0)
+	assert.Equal(t, 4.0, td.Count())
+	assert.Equal(t, 1.0, td.Quantile(0))
+	assert.Equal(t, 2.0, td.Quantile(1))
+	assert.True(t, math.IsNaN(td.Quantile(1.5)))
+
+	single := New(100)
+	single.Add(7, 10)
+	assert.Equal(t, 7.0, single.Quantile(0.3))
+}
+
+func TestMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	hosts := []*TDigest{New(100), New(100), New(100)}
+	values := make([]float64, 90000)
+	for i := range values {
+		// Hosts see different distributions.
+		values[i] = r.ExpFloat64() * float64(i%3+1)
+		hosts[i%3].Add(values[i], 1)
+	}
+	slices.Sort(values)
+
+	merged := New(100)
+	assert.NoError(t, merged.Merge(hosts...))
+	assert.Equal(t, float64(len(values)), merged.Count())
+	assert.LessOrEqual(t, len(merged.centroids), 100)
+	for _, q := range []float64{0.01, 0.5, 0.99, 0.999} {
+		rank := float64(sortSearch(values, merged.Quantile(q))) / float64(len(values))
+		assert.InDelta(t, q, rank, 0.01*math.Min(q, 1-q)+0.0005, "q=%v", q)
+	}
+	assert.Equal(t, values[0], merged.Quantile(0))
+	assert.Equal(t, values[len(values)-1], merged.Quantile(1))
+
+	// Merging a digest into itself doubles its weights.
+	median := merged.Quantile(0.5)
+	assert.NoError(t, merged.Merge(merged))
+	assert.Equal(t, float64(2*len(values)), merged.Count())
+	assert.InEpsilon(t, median, merged.Quantile(0.5), 0.01)
+
+	assert.Error(t, merged.Merge(hosts[0], New(200)))
+	assert.Equal(t, float64(2*len(values)), merged.Count())
+}
+
+func TestCDF(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	td := New(100)
+	values := make([]float64, 100000)
+	for i := range values {
+		values[i] = r.NormFloat64()
+		td.Add(values[i], 1)
+	}
+	slices.Sort(values)
+
+	for _, v := range []float64{-3, -2, -1, 0, 0.5, 1, 2, 3} {
+		want := float64(sortSearch(values, v)) / float64(len(values))
+		assert.InDelta(t, want, td.CDF(v), 0.01*math.Min(want, 1-want)+0.0005, "v=%v", v)
+	}
+	for _, q := range []float64{0.001, 0.1, 0.5, 0.9, 0.999} {
+		assert.InDelta(t, q, td.CDF(td.Quantile(q)), 1e-9)
+	}
+	assert.Equal(t, 0.0, td.CDF(values[0]-1))
+	assert.Equal(t, 1.0, td.CDF(values[len(values)-1]))
+
+	assert.True(t, math.IsNaN(New(100).CDF(0)))
+	single := New(100)
+	single.Add(7, 10)
+	assert.Equal(t, 0.0, single.CDF(6))
+	assert.Equal(t, 1.0, single.CDF(7))
+}
@@ -0,0 +1,140 @@
+package topk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// The binary format starts with binaryMagic and binaryVersion, followed by k,
+// width, depth, the decay and the state of the random number generator as
+// little-endian uint64. The buckets follow as their fingerprint and count,
+// little-endian uint32, then the number of items of the heap, and each item
+// as its count, little-endian uint64, its fingerprint and length, uint32, and
+// its bytes, in the order of the heap. It ends with the CRC32 of everything
+// before.
+const (
+	binaryMagic      = "TOPK"
+	binaryVersion    = 1
+	binaryHeaderSize = len(binaryMagic) + 1 + 5*8
+	bucketSize       = 8
+	checksumSize     = 4
+)
+
+// ErrCorruptData is returned when decoding data whose checksum doesn't match.
+var ErrCorruptData = errors.New("corrupt data")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (t *TopK) MarshalBinary() ([]byte, error) {
+	size := binaryHeaderSize + len(t.buckets)*bucketSize + 8 + checksumSize
+	for _, it := range t.heap {
+		size += 16 + len(it.item)
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, binaryMagic...)
+	data = append(data, binaryVersion)
+	data = binary.LittleEndian.AppendUint64(data, uint64(t.k))
+	data = binary.LittleEndian.AppendUint64(data, uint64(t.width))
+	data = binary.LittleEndian.AppendUint64(data, uint64(t.depth))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(t.decay))
+	data = binary.LittleEndian.AppendUint64(data, t.rngState)
+	for _, b := range t.buckets {
+		data = binary.LittleEndian.AppendUint32(data, b.fp)
+		data = binary.LittleEndian.AppendUint32(data, b.count)
+	}
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(t.heap)))
+	for _, it := range t.heap {
+		data = binary.LittleEndian.AppendUint64(data, uint64(it.count))
+		data = binary.LittleEndian.AppendUint32(data, it.fp)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(it.item)))
+		data = append(data, it.item...)
+	}
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data)), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The checksum is verified before anything is decoded, and ErrCorruptData is
+// returned if it doesn't match.
+func (t *TopK) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize+8+checksumSize {
+		return errors.New("data too short")
+	}
+	if string(data[:len(binaryMagic)]) != binaryMagic {
+		return errors.New("not a top-k")
+	}
+	payload, tail := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(tail) {
+		return ErrCorruptData
+	}
+	if payload[len(binaryMagic)] != binaryVersion {
+		return errors.New("unsupported version")
+	}
+
+	rest := payload[len(binaryMagic)+1:]
+	next := func() uint64 {
+		v := binary.LittleEndian.Uint64(rest)
+		rest = rest[8:]
+		return v
+	}
+	next32 := func() uint32 {
+		v := binary.LittleEndian.Uint32(rest)
+		rest = rest[4:]
+		return v
+	}
+	k, width, depth := next(), next(), next()
+	decay, rngState := math.Float64frombits(next()), next()
+	if k == 0 || width == 0 || depth == 0 || !(decay > 0 && decay <= 1) {
+		return fmt.Errorf("%w: invalid parameters", ErrCorruptData)
+	}
+	// The buckets must fit in the data before they're allocated.
+	if width > uint64(len(rest))/bucketSize/depth {
+		return errors.New("data too short")
+	}
+
+	res := TopK{
+		k:        uint(k),
+		width:    uint(width),
+		depth:    uint(depth),
+		decay:    decay,
+		buckets:  make([]bucket, width*depth),
+		rngState: rngState,
+	}
+	res.fillDecayTable()
+	for i := range res.buckets {
+		res.buckets[i] = bucket{fp: next32(), count: next32()}
+	}
+	if len(rest) < 8 {
+		return errors.New("data too short")
+	}
+	heapLen := next()
+	if heapLen > k || heapLen > uint64(len(rest)/16) {
+		return fmt.Errorf("%w: invalid heap", ErrCorruptData)
+	}
+	// k comes from the data: the heap grows to it as items are added.
+	res.heap = make([]heapItem, 0, heapLen)
+	for i := range int(heapLen) {
+		if len(rest) < 16 {
+			return errors.New("data too short")
+		}
+		it := heapItem{count: uint(next()), fp: next32()}
+		n := next32()
+		if uint64(n) > uint64(len(rest)) {
+			return errors.New("data too short")
+		}
+		it.item, rest = string(rest[:n]), rest[n:]
+		if _, _, fp := hashes([]byte(it.item)); fp != it.fp ||
+			(i > 0 && res.heap[(i-1)/2].count > it.count) {
+			return fmt.Errorf("%w: invalid heap", ErrCorruptData)
+		}
+		res.heap = append(res.heap, it)
+	}
+	if len(rest) != 0 {
+		return errors.New("trailing data")
+	}
+
+	*t = res
+	return nil
+}
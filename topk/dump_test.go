@@ -0,0 +1,54 @@
+package topk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	tk := New(10, 100, 5, 0.9)
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.2, 1, 1000)
+	for i := 0; i < 10000; i++ {
+		tk.Add([]byte(strconv.FormatUint(z.Uint64(), 10)))
+	}
+
+	data, err := tk.MarshalBinary()
+	assert.NoError(t, err)
+	loaded := New(1, 1, 1, 1)
+	assert.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, tk, loaded)
+	assert.Equal(t, tk.ListWithCount(), loaded.ListWithCount())
+
+	// Both evolve the same way, random decays included.
+	for i := 0; i < 10000; i++ {
+		k := []byte(strconv.FormatUint(z.Uint64(), 10))
+		tk.Add(k)
+		loaded.Add(k)
+	}
+	assert.Equal(t, tk, loaded)
+
+	assert.Error(t, loaded.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, loaded.UnmarshalBinary(append(bytes.Clone(data), 0)))
+	assert.Error(t, loaded.UnmarshalBinary(data[:10]))
+	corrupted := bytes.Clone(data)
+	corrupted[binaryHeaderSize+3] ^= 1
+	assert.ErrorIs(t, loaded.UnmarshalBinary(corrupted), ErrCorruptData)
+	assert.Equal(t, tk, loaded)
+
+	// A huge k with a valid checksum isn't allocated up front.
+	huge := bytes.Clone(data)
+	binary.LittleEndian.PutUint64(huge[len(binaryMagic)+1:], 1<<60)
+	huge = binary.LittleEndian.AppendUint32(huge[:len(huge)-checksumSize], crc32.ChecksumIEEE(huge[:len(huge)-checksumSize]))
+	assert.NoError(t, loaded.UnmarshalBinary(huge))
+	assert.Equal(t, uint(1<<60), loaded.k)
+	ref := New(1, 1, 1, 1)
+	assert.NoError(t, ref.UnmarshalBinary(data))
+	assert.Equal(t, ref.ListWithCount(), loaded.ListWithCount())
+}
@@ -0,0 +1,292 @@
+// Package topk implements HeavyKeeper, which keeps the k most frequent items
+// of a stream, as the TOPK.* commands of RedisBloom do.
+package topk
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/fukua95/pds/internal/hashing"
+)
+
+// fpSeed seeds the hash of the fingerprints, so that they're independent of
+// the buckets of an item.
+const fpSeed = 1919
+
+// decayTableSize is the number of powers of the decay that are precomputed.
+const decayTableSize = 256
+
+// A bucket holds the fingerprint of the item that owns it, and its count.
+type bucket struct {
+	fp    uint32
+	count uint32
+}
+
+// Counter is an item of the top-k, and the estimate of its count.
+type Counter struct {
+	Item  string
+	Count uint
+}
+
+// heapItem is an item of the top-k, with its fingerprint to check it quickly.
+type heapItem struct {
+	item  string
+	count uint
+	fp    uint32
+}
+
+// TopK is a HeavyKeeper: depth rows of width buckets, each owned by an item.
+// Adding an item increments its buckets, and decays the count of the buckets
+// owned by other items with a probability of decay^count, so that they change
+// hands once their count reaches 0. Large counts thus only belong to frequent
+// items, and the k largest are kept in a min-heap.
+type TopK struct {
+	k       uint
+	width   uint
+	depth   uint
+	decay   float64
+	buckets []bucket
+	// heap is a min-heap of at most k items, by count.
+	heap       []heapItem
+	decayTable [decayTableSize]float64
+	rngState   uint64
+}
+
+// New returns a TopK that keeps the k most frequent items, with depth rows of
+// width buckets, and the given decay; RedisBloom uses 8 buckets per item, 7
+// rows and a decay of 0.9 by default. It panics if k, width or depth is 0, or
+// if decay isn't in (0, 1].
+func New(k, width, depth uint, decay float64) *TopK {
+	if k == 0 || width == 0 || depth == 0 || !(decay > 0 && decay <= 1) {
+		panic(fmt.Sprintf("topk: invalid parameters k=%d width=%d depth=%d decay=%v", k, width, depth, decay))
+	}
+	if width > math.MaxInt/depth {
+		panic("topk: width and depth are too large")
+	}
+	t := &TopK{
+		k:       k,
+		width:   width,
+		depth:   depth,
+		decay:   decay,
+		buckets: make([]bucket, width*depth),
+		heap:    make([]heapItem, 0, k),
+	}
+	t.fillDecayTable()
+	return t
+}
+
+func (t *TopK) fillDecayTable() {
+	for i := range t.decayTable {
+		t.decayTable[i] = math.Pow(t.decay, float64(i))
+	}
+}
+
+// hashes returns the two hashes the buckets of data are derived from, by
+// double hashing, and its fingerprint.
+func hashes(data []byte) (h1, h2 uint64, fp uint32) {
+	h1 = hashing.Sum64(data, 0)
+	return h1, hashing.Sum64(data, h1), uint32(hashing.Sum64(data, fpSeed))
+}
+
+// bucket returns the bucket in row i of the item hashed to h1 and h2.
+func (t *TopK) bucket(h1, h2 uint64, i uint) *bucket {
+	return &t.buckets[i*t.width+uint((h1+uint64(i)*h2)%uint64(t.width))]
+}
+
+// nextRand returns the next number of the splitmix64 sequence.
+func (t *TopK) nextRand() uint64 {
+	t.rngState += 0x9e3779b97f4a7c15
+	z := t.rngState
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// decayProb returns decay^count.
+func (t *TopK) decayProb(count uint32) float64 {
+	if count < decayTableSize {
+		return t.decayTable[count]
+	}
+	return math.Pow(t.decayTable[decayTableSize-1], float64(count/(decayTableSize-1))) *
+		t.decayTable[count%(decayTableSize-1)]
+}
+
+// Add adds an occurrence of data. If data enters the top-k, changed is true,
+// and evicted is the item it replaced, if the top-k was full.
+func (t *TopK) Add(data []byte) (evicted string, changed bool) {
+	return t.IncrBy(data, 1)
+}
+
+// IncrBy adds count occurrences of data, with the same result as adding them
+// one by one, and reports the changes to the top-k as Add does. Counts are
+// clamped at math.MaxUint32.
+func (t *TopK) IncrBy(data []byte, count uint) (evicted string, changed bool) {
+	incr := uint32(min(count, math.MaxUint32))
+	if incr == 0 {
+		return "", false
+	}
+	h1, h2, fp := hashes(data)
+	maxCount := uint32(0)
+	for i := range t.depth {
+		b := t.bucket(h1, h2, i)
+		switch {
+		case b.count == 0:
+			b.fp, b.count = fp, incr
+		case b.fp == fp:
+			b.count = addSat(b.count, incr)
+		default:
+			t.decayBucket(b, fp, incr)
+		}
+		if b.fp == fp {
+			maxCount = max(maxCount, b.count)
+		}
+	}
+	return t.updateHeap(data, fp, uint(maxCount))
+}
+
+// decayBucket applies incr occurrences of the item with fingerprint fp to a
+// bucket owned by another item: each one decrements the count with a
+// probability of decay^count, and takes the bucket over once it's 0, with the
+// occurrences left. Rather than one random draw per occurrence, the number of
+// occurrences until the next decrement is drawn from its geometric
+// distribution.
+func (t *TopK) decayBucket(b *bucket, fp uint32, incr uint32) {
+	for left := uint64(incr); left > 0; {
+		p := t.decayProb(b.count)
+		if p == 0 {
+			return
+		}
+		// The trial, from 1, of the first success.
+		trial := uint64(1)
+		if p < 1 {
+			u := 1 - float64(t.nextRand()>>11)/(1<<53)
+			n := math.Floor(math.Log(u)/math.Log1p(-p)) + 1
+			if n > float64(left) {
+				return
+			}
+			trial = uint64(n)
+		}
+		left -= trial - 1
+		b.count--
+		if b.count == 0 {
+			b.fp, b.count = fp, uint32(left)
+			return
+		}
+		left--
+	}
+}
+
+// Count returns the estimate of the count of data if it's in the top-k, and
+// 0 otherwise.
+func (t *TopK) Count(data []byte) uint {
+	h1, h2, fp := hashes(data)
+	if t.find(data, fp) < 0 {
+		return 0
+	}
+	count := uint32(0)
+	for i := range t.depth {
+		if b := t.bucket(h1, h2, i); b.fp == fp {
+			count = max(count, b.count)
+		}
+	}
+	return uint(count)
+}
+
+// updateHeap sets the count of data in the top-k, if it's there, or adds it
+// if its count is large enough.
+func (t *TopK) updateHeap(data []byte, fp uint32, count uint) (evicted string, changed bool) {
+	if i := t.find(data, fp); i >= 0 {
+		t.heap[i].count = count
+		t.down(i)
+		return "", false
+	}
+	item := heapItem{item: string(data), count: count, fp: fp}
+	if uint(len(t.heap)) < t.k {
+		t.heap = append(t.heap, item)
+		t.up(len(t.heap) - 1)
+		return "", true
+	}
+	if count <= t.heap[0].count {
+		return "", false
+	}
+	evicted = t.heap[0].item
+	t.heap[0] = item
+	t.down(0)
+	return evicted, true
+}
+
+// find returns the position of data in the heap, or -1.
+func (t *TopK) find(data []byte, fp uint32) int {
+	for i := range t.heap {
+		if t.heap[i].fp == fp && t.heap[i].item == string(data) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *TopK) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if t.heap[parent].count <= t.heap[i].count {
+			return
+		}
+		t.heap[parent], t.heap[i] = t.heap[i], t.heap[parent]
+		i = parent
+	}
+}
+
+func (t *TopK) down(i int) {
+	for {
+		smallest := i
+		for c := 2*i + 1; c <= 2*i+2 && c < len(t.heap); c++ {
+			if t.heap[c].count < t.heap[smallest].count {
+				smallest = c
+			}
+		}
+		if smallest == i {
+			return
+		}
+		t.heap[smallest], t.heap[i] = t.heap[i], t.heap[smallest]
+		i = smallest
+	}
+}
+
+// Query reports whether data is in the top-k.
+func (t *TopK) Query(data []byte) bool {
+	_, _, fp := hashes(data)
+	return t.find(data, fp) >= 0
+}
+
+// List returns the items of the top-k, by decreasing count.
+func (t *TopK) List() []string {
+	list := t.ListWithCount()
+	res := make([]string, len(list))
+	for i, c := range list {
+		res[i] = c.Item
+	}
+	return res
+}
+
+// ListWithCount returns the items of the top-k and their counts, by decreasing
+// count.
+func (t *TopK) ListWithCount() []Counter {
+	res := make([]Counter, len(t.heap))
+	for i, it := range t.heap {
+		res[i] = Counter{Item: it.item, Count: it.count}
+	}
+	slices.SortFunc(res, func(a, b Counter) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Item, b.Item))
+	})
+	return res
+}
+
+// addSat returns a + b, or math.MaxUint32 if it overflows.
+func addSat(a, b uint32) uint32 {
+	if a+b < a {
+		return math.MaxUint32
+	}
+	return a + b
+}
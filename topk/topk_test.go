@@ -0,0 +1,118 @@
+package topk
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	tk := New(10, 80, 7, 0.9)
+	assert.Len(t, tk.buckets, 560)
+	assert.Empty(t, tk.List())
+	assert.Empty(t, tk.ListWithCount())
+	assert.InDelta(t, 0.9, tk.decayProb(1), 1e-12)
+	assert.InDelta(t, 0.9*0.9*0.9, tk.decayProb(3), 1e-12)
+	assert.InEpsilon(t, math.Pow(0.9, 1000), tk.decayProb(1000), 1e-9)
+
+	for _, p := range []struct {
+		k, width, depth uint
+		decay           float64
+	}{{0, 80, 7, 0.9}, {10, 0, 7, 0.9}, {10, 80, 0, 0.9}, {10, 80, 7, 0}, {10, 80, 7, 1.5}} {
+		assert.Panics(t, func() { New(p.k, p.width, p.depth, p.decay) })
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tk := New(3, 50, 5, 0.9)
+	evicted, changed := tk.Add([]byte("a"))
+	assert.Equal(t, "", evicted)
+	assert.True(t, changed)
+	evicted, changed = tk.Add([]byte("a"))
+	assert.Equal(t, "", evicted)
+	assert.False(t, changed)
+	tk.Add([]byte("b"))
+	tk.Add([]byte("b"))
+	tk.Add([]byte("c"))
+	assert.Equal(t, []Counter{{"a", 2}, {"b", 2}, {"c", 1}}, tk.ListWithCount())
+	assert.Equal(t, []string{"a", "b", "c"}, tk.List())
+
+	// An item must have a larger count than the smallest to enter.
+	_, changed = tk.Add([]byte("d"))
+	assert.False(t, changed)
+	evicted, changed = tk.Add([]byte("d"))
+	assert.Equal(t, "c", evicted)
+	assert.True(t, changed)
+	assert.True(t, tk.Query([]byte("d")))
+	assert.False(t, tk.Query([]byte("c")))
+}
+
+func TestZipf(t *testing.T) {
+	const k = 10
+	tk := New(k, 1000, 7, 0.9)
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.2, 1, 100000)
+	counts := make(map[uint64]uint)
+	for i := 0; i < 200000; i++ {
+		v := z.Uint64()
+		counts[v]++
+		tk.Add([]byte(strconv.FormatUint(v, 10)))
+	}
+
+	// The most frequent values of a Zipf distribution are the smallest ones.
+	list := tk.ListWithCount()
+	assert.Len(t, list, k)
+	for i, c := range list {
+		assert.Equal(t, strconv.Itoa(i), c.Item)
+		assert.InEpsilon(t, counts[uint64(i)], c.Count, 0.05)
+	}
+}
+
+func TestIncrBy(t *testing.T) {
+	tk := New(3, 50, 5, 0.9)
+	tk.IncrBy([]byte("a"), 10)
+	tk.IncrBy([]byte("b"), 5)
+	tk.Add([]byte("b"))
+	_, changed := tk.IncrBy([]byte("c"), 0)
+	assert.False(t, changed)
+	assert.Equal(t, []Counter{{"a", 10}, {"b", 6}}, tk.ListWithCount())
+	assert.Equal(t, uint(10), tk.Count([]byte("a")))
+	assert.Equal(t, uint(6), tk.Count([]byte("b")))
+	assert.Equal(t, uint(0), tk.Count([]byte("c")))
+
+	tk.IncrBy([]byte("c"), 1)
+	evicted, changed := tk.IncrBy([]byte("d"), 2)
+	assert.Equal(t, "c", evicted)
+	assert.True(t, changed)
+	assert.Equal(t, uint(0), tk.Count([]byte("c")))
+	tk.IncrBy([]byte("a"), math.MaxUint)
+	assert.Equal(t, uint(math.MaxUint32), tk.Count([]byte("a")))
+}
+
+// The decay of weighted increments follows that of as many single ones.
+func TestIncrByDecay(t *testing.T) {
+	const trials = 2000
+	takeovers := [2]int{}
+	for i := 0; i < trials; i++ {
+		for j, weighted := range []bool{false, true} {
+			tk := New(1, 1, 1, 0.9)
+			tk.rngState = uint64(i)
+			tk.IncrBy([]byte("a"), 10)
+			if weighted {
+				tk.IncrBy([]byte("b"), 18)
+			} else {
+				for range 18 {
+					tk.Add([]byte("b"))
+				}
+			}
+			_, _, fp := hashes([]byte("b"))
+			if tk.buckets[0].fp == fp {
+				takeovers[j]++
+			}
+		}
+	}
+	assert.InDelta(t, float64(takeovers[0])/trials, float64(takeovers[1])/trials, 0.05)
+}